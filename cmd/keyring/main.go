@@ -0,0 +1,208 @@
+package main
+
+import (
+	"distributed-task-queue/internal/config"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/keyring"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const usage = `Manage the master keys that encrypt job payloads and error messages at rest.
+
+Usage:
+  keyring [-keyring path] init
+  keyring [-keyring path] [-db path] rotate
+  keyring [-keyring path] [-db path] status
+  keyring [-keyring path] [-db path] retire <key id>
+`
+
+func main() {
+	dbPath := os.Getenv(config.EnvName("db"))
+	if dbPath == "" {
+		dbPath = config.Default().Database.Path
+	}
+	keyringPath := os.Getenv(config.EnvName("keyring"))
+
+	flag.StringVar(&dbPath, "db", dbPath, "SQLite database path (env "+config.EnvName("db")+")")
+	flag.StringVar(&keyringPath, "keyring", keyringPath, "keyring file (env "+config.EnvName("keyring")+")")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if keyringPath == "" {
+		fatal(errors.New("no keyring file given (-keyring or " + config.EnvName("keyring") + ")"))
+	}
+
+	args := flag.Args()[1:]
+	var err error
+	switch flag.Arg(0) {
+	case "init":
+		err = initKeyring(keyringPath)
+	case "rotate":
+		err = rotate(keyringPath, dbPath)
+	case "status":
+		err = status(keyringPath, dbPath)
+	case "retire":
+		err = retire(keyringPath, dbPath, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func initKeyring(path string) error {
+	kr, err := keyring.Create(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created keyring %s with master key %s\n", path, kr.Primary())
+	fmt.Println("Back it up: jobs encrypted with its keys cannot be read without it.")
+	return nil
+}
+
+// rotate adds a primary master key and a data key for every tenant. Running
+// servers read the new master key from the keyring file when they first meet
+// it and re-encrypt older jobs in the background.
+func rotate(keyringPath, dbPath string) error {
+	kr, err := keyring.Load(keyringPath)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	db.SetKeyring(kr)
+
+	id, err := kr.Rotate()
+	if err != nil {
+		return err
+	}
+	n, err := db.RotateDataKeys()
+	if err != nil {
+		return fmt.Errorf("added master key %s, but rotating data keys failed: %w", id, err)
+	}
+
+	fmt.Printf("Added master key %s as primary and %d tenant data keys\n", id, n)
+	fmt.Println("Servers encrypt new jobs with them at once and re-encrypt older jobs in the background;")
+	fmt.Println("run status to follow, then retire the previous master keys.")
+	return nil
+}
+
+func status(keyringPath, dbPath string) error {
+	kr, err := keyring.Load(keyringPath)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	keys, err := db.ListDataKeys()
+	if err != nil {
+		return err
+	}
+	plaintext, err := db.CountPlaintextJobs()
+	if err != nil {
+		return err
+	}
+
+	ids := kr.IDs()
+	for i, id := range ids {
+		if id == kr.Primary() {
+			ids[i] += " (primary)"
+		}
+	}
+	fmt.Printf("Master keys: %s\n\n", strings.Join(ids, ", "))
+
+	pending := plaintext
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATA KEY\tTENANT\tMASTER KEY\tCREATED\tJOBS\tSTATUS")
+	for _, k := range keys {
+		status := "current"
+		if !k.Current {
+			status = "previous"
+			pending += k.Jobs
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s\n", k.ID, k.TenantID, k.MasterKey,
+			k.CreatedAt.Format(time.RFC3339), k.Jobs, status)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nJobs awaiting re-encryption: %d (%d in plaintext)\n", pending, plaintext)
+	return nil
+}
+
+// retire removes a master key from the keyring once no data key needs it
+func retire(keyringPath, dbPath string, args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected exactly one master key id")
+	}
+	id := args[0]
+
+	kr, err := keyring.Load(keyringPath)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	keys, err := db.ListDataKeys()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if k.MasterKey == id {
+			return fmt.Errorf("master key %s still wraps data key %d; run rotate first", id, k.ID)
+		}
+	}
+
+	if err := kr.Retire(id); err != nil {
+		return err
+	}
+	fmt.Printf("Retired master key %s\n", id)
+	return nil
+}
+
+func openDB(path string) (*database.DB, error) {
+	db, err := database.New(database.SecureDelete(path))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.InitSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "keyring:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/config"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const usage = `Manage API keys directly in the database, e.g. to create the first admin key.
+
+Usage:
+  keys [-db path] create -role viewer|submitter|operator|admin [-tenant id] [-name name]
+  keys [-db path] list
+  keys [-db path] rotate <key id>
+  keys [-db path] revoke <key id>
+`
+
+func main() {
+	dbPath := os.Getenv(config.EnvName("db"))
+	if dbPath == "" {
+		dbPath = config.Default().Database.Path
+	}
+
+	flag.StringVar(&dbPath, "db", dbPath, "SQLite database path (env "+config.EnvName("db")+")")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := database.New(dbPath)
+	if err != nil {
+		fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.InitSchema(); err != nil {
+		fatal(err)
+	}
+
+	args := flag.Args()[1:]
+	switch flag.Arg(0) {
+	case "create":
+		err = create(db, args)
+	case "list":
+		err = list(db)
+	case "rotate":
+		err = rotate(db, args)
+	case "revoke":
+		err = revoke(db, args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func create(db *database.DB, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	var req models.APIKeyRequest
+	fs.StringVar(&req.Role, "role", auth.RoleSubmitter, "role of the key: viewer, submitter, operator or admin")
+	fs.StringVar(&req.TenantID, "tenant", "", "tenant the key acts for (viewer and submitter keys only)")
+	fs.StringVar(&req.Name, "name", "", "description of the key")
+	fs.Parse(args)
+
+	k, key, hash, err := auth.NewKey(req)
+	if err != nil {
+		return err
+	}
+	if err := db.InsertAPIKey(k, hash); err != nil {
+		return err
+	}
+
+	fmt.Printf("Created %s key %s\n", k.Role, k.ID)
+	printKey(key)
+	return nil
+}
+
+func list(db *database.DB) error {
+	keys, err := db.ListAPIKeys()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tROLE\tTENANT\tCREATED\tLAST USED\tSTATUS")
+	for _, k := range keys {
+		status := "active"
+		if k.RevokedAt != nil {
+			status = "revoked " + k.RevokedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, k.Role, k.TenantID,
+			k.CreatedAt.Format(time.RFC3339), formatTime(k.LastUsedAt), status)
+	}
+	return tw.Flush()
+}
+
+func rotate(db *database.DB, args []string) error {
+	id, err := keyID(args)
+	if err != nil {
+		return err
+	}
+
+	key, hash, err := auth.NewSecret(id)
+	if err != nil {
+		return err
+	}
+	if err := db.RotateAPIKey(id, hash); err != nil {
+		return notFound(id, err)
+	}
+
+	fmt.Printf("Rotated key %s; the previous key no longer works\n", id)
+	printKey(key)
+	return nil
+}
+
+func revoke(db *database.DB, args []string) error {
+	id, err := keyID(args)
+	if err != nil {
+		return err
+	}
+	if err := db.RevokeAPIKey(id); err != nil {
+		return notFound(id, err)
+	}
+
+	fmt.Printf("Revoked key %s\n", id)
+	return nil
+}
+
+func keyID(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("expected exactly one key id")
+	}
+	return args[0], nil
+}
+
+func notFound(id string, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no active key %s", id)
+	}
+	return err
+}
+
+func printKey(key string) {
+	fmt.Printf("\n  %s\n\nStore it now, it cannot be shown again.\n", key)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "keys:", err)
+	os.Exit(1)
+}
@@ -2,58 +2,354 @@ package main
 
 import (
 	"context"
+	"distributed-task-queue/internal/alerting"
 	"distributed-task-queue/internal/api"
+	"distributed-task-queue/internal/config"
 	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/keyring"
+	"distributed-task-queue/internal/logging"
+	"distributed-task-queue/internal/models"
+	"distributed-task-queue/internal/tlscert"
+	"distributed-task-queue/internal/tracing"
 	"distributed-task-queue/internal/websocket"
 	"distributed-task-queue/internal/worker"
-	"log"
+	"errors"
+	"flag"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// certCheckInterval is how often the TLS certificate files are checked for
+// changes
+const certCheckInterval = 30 * time.Second
+
+// readHeaderTimeout and readTimeout bound how long a client may take to send
+// a request's headers and the whole request, so that slow clients cannot
+// hold connections open. Responses such as event streams are not limited.
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 5 * time.Minute
+)
+
+// reencryptBatch is the number of jobs read at a time while re-encrypting
+const reencryptBatch = 100
+
 func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	if err := logging.Setup(os.Stderr, cfg.Log.Level, cfg.Log.Format); err != nil {
+		fatal("Invalid log settings", err)
+	}
+
+	// Export job traces
+	exporter, closeTraces, err := tracing.Open(cfg.Tracing.Output)
+	if err != nil {
+		fatal("Failed to open trace output", err)
+	}
+	defer closeTraces.Close()
+	tracing.SetExporter(exporter)
+
 	// Open database
-	db, err := database.New("./jobs.db")
+	dsn := cfg.Database.Path
+	if cfg.Encryption.Keyring != "" {
+		dsn = database.SecureDelete(dsn)
+	}
+	db, err := database.New(dsn)
 	if err != nil {
-		log.Fatal("Failed to open database:", err)
+		fatal("Failed to open database", err)
 	}
 	defer db.Close()
 
 	// Initialize database schema
 	if err := db.InitSchema(); err != nil {
-		log.Fatal("Failed to initialize database:", err)
+		fatal("Failed to initialize database", err)
+	}
+	slog.Info("Database initialized", "path", cfg.Database.Path)
+	db.SetMaxLogLines(cfg.JobLogs.MaxLines)
+
+	// Encrypt job data at rest with the master keys of the keyring
+	if cfg.Encryption.Keyring != "" {
+		kr, err := keyring.Load(cfg.Encryption.Keyring)
+		if err != nil {
+			fatal("Failed to load keyring", err)
+		}
+		db.SetKeyring(kr)
+		slog.Info("Encryption at rest enabled", "keyring", cfg.Encryption.Keyring, "primary", kr.Primary())
+	} else if encrypted, err := db.HasEncryptedJobs(); err != nil {
+		fatal("Failed to check for encrypted jobs", err)
+	} else if encrypted {
+		fatal("Jobs are encrypted but no keyring is configured", errors.New("set -keyring"))
 	}
-	log.Println("[INIT] Database initialized")
 
 	// Create WebSocket manager
 	wsManager := websocket.New(db)
 
-	// Create context for workers
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Workers stop leasing as soon as a shutdown signal arrives
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Start workers
-	numWorkers := 3
-	pollInterval := 2 * time.Second
+	// Start workers. Workers are woken as soon as a job is submitted or
+	// retried; the poll interval only caps the idle backoff between polls.
+	pool := worker.NewPool(ctx, func(n int, ctx context.Context) *worker.Worker {
+		return worker.New(n, db, cfg.Workers, ctx, wsManager.Broadcast)
+	})
+	pool.Resize(cfg.Workers.Count)
 
-	for i := 1; i <= numWorkers; i++ {
-		w := worker.New(i, db, pollInterval, ctx, wsManager.Broadcast)
-		go w.Start()
+	// Scale the pool with backlog depth and queue wait
+	policy := worker.DefaultScalingPolicy(cfg.Workers.MinCount, cfg.Workers.MaxCount)
+	autoscaler := worker.NewAutoscaler(pool, db, policy, ctx)
+	if !cfg.Workers.Autoscale {
+		autoscaler.Apply(models.PoolResizeRequest{Autoscale: &cfg.Workers.Autoscale})
+	}
+	go autoscaler.Start()
+	slog.Info("Started workers", "count", pool.Size(), "min", cfg.Workers.MinCount,
+		"max", cfg.Workers.MaxCount, "autoscale", cfg.Workers.Autoscale)
+
+	// Reclaim jobs from workers that stop heartbeating
+	reaper := worker.NewReaper(db, 3*worker.HeartbeatInterval, ctx, wsManager.Broadcast)
+	go reaper.Start()
+
+	// Drop audit log entries and job logs past their retention
+	if cfg.Audit.Retention > 0 {
+		go prune(ctx, "audit log", cfg.Audit.Retention, db.DeleteAuditEntriesBefore)
+	}
+	if cfg.JobLogs.Retention > 0 {
+		go prune(ctx, "job logs", cfg.JobLogs.Retention, db.DeleteJobLogsBefore)
+	}
+
+	// Write per-minute job statistics and downsample older ones
+	go recordMetricsHistory(ctx, db)
+
+	// Move jobs to their tenant's newest data key after a key rotation, and
+	// encrypt jobs stored before encryption was turned on
+	if cfg.Encryption.Keyring != "" {
+		go reencryptJobs(ctx, db, cfg.Encryption.ReencryptInterval)
+	}
+
+	// Evaluate alert rules and show firing alerts on the dashboard
+	var alerts *alerting.Engine
+	if cfg.Alerting.Interval > 0 {
+		alerts = alerting.New(db, wsManager.Broadcast)
+		wsManager.SetAlerts(alerts.Banner)
+		go alerts.Start(ctx, cfg.Alerting.Interval)
 	}
-	log.Printf("[INIT] Started %d workers", numWorkers)
 
 	// Create API server
-	apiServer := api.NewServer(db, wsManager)
+	if !cfg.Auth.Enabled {
+		slog.Warn("Authentication is disabled, every API request acts as admin. " +
+			"Create an admin key with cmd/keys and restart with -auth")
+	}
+	apiServer := api.NewServer(db, wsManager, cfg)
+	apiServer.SetPool(autoscaler)
+	apiServer.SetAlerts(alerts)
 
 	// Setup routes
 	mux := http.NewServeMux()
 	apiServer.SetupRoutes(mux)
+	httpServer := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+	}
+
+	// Serve HTTPS when a certificate is configured. The certificate is
+	// reloaded when its files change and on SIGHUP.
+	var certs *tlscert.Reloader
+	if tlsCfg := cfg.Server.TLS; tlsCfg.CertFile != "" {
+		certs, err = tlscert.NewReloader(tlsCfg.CertFile, tlsCfg.KeyFile)
+		if err != nil {
+			fatal("Failed to load TLS certificate", err)
+		}
+		httpServer.TLSConfig, err = tlscert.ServerConfig(certs, tlsCfg.ClientCAFile, tlsCfg.RequireClientCert)
+		if err != nil {
+			fatal("Failed to load client CA", err)
+		}
+		go certs.Watch(ctx, certCheckInterval)
+	}
+
+	// Reload limits and the certificate on SIGHUP; other settings need a
+	// restart
+	go reloadOnHangup(ctx, apiServer, certs)
 
 	// Start HTTP server
-	port := ":8080"
-	log.Printf("[INIT] Server starting on http://localhost%s", port)
-	log.Fatal(http.ListenAndServe(port, mux))
+	serveErr := make(chan error, 1)
+	go func() {
+		if certs != nil {
+			slog.Info("Server starting", "url", "https://localhost"+cfg.Server.Addr,
+				"client_certificates", httpServer.TLSConfig.ClientAuth.String())
+			serveErr <- httpServer.ListenAndServeTLS("", "")
+			return
+		}
+		slog.Info("Server starting", "url", "http://localhost"+cfg.Server.Addr)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		fatal("Server failed", err)
+	case <-ctx.Done():
+	}
+	// Restore the default handling so that a second signal kills the process
+	stop()
+
+	// Drain: stop accepting submissions, let in-flight jobs finish, then
+	// release whatever is left so no job waits for its lease to expire. The
+	// HTTP server keeps running meanwhile so remote workers can report.
+	slog.Info("Signal received, draining", "timeout", cfg.Server.DrainTimeout)
+	apiServer.SetDraining(true)
+
+	if pool.Drain(cfg.Server.DrainTimeout) {
+		slog.Info("All in-flight jobs finished")
+	}
+	if err := db.ReleaseWorkerLeases(pool.IDs()); err != nil {
+		slog.Error("Failed to release leases", "err", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("HTTP server shutdown failed", "err", err)
+	}
+	wsManager.Close()
+
+	// Keep the statistics of the minute in progress
+	if err := db.FlushMetricsHistory(time.Now().Add(time.Minute)); err != nil {
+		slog.Error("Failed to write metrics history", "err", err)
+	}
+
+	slog.Info("Server stopped")
+}
+
+// reloadOnHangup re-reads the configuration on every SIGHUP and applies the
+// limits, which are safe to change at runtime. It also reloads the TLS
+// certificate, if any.
+func reloadOnHangup(ctx context.Context, apiServer *api.Server, certs *tlscert.Reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+
+		if certs != nil {
+			if err := certs.Reload(); err != nil {
+				slog.Warn("Keeping current certificate", "err", err)
+			} else {
+				slog.Info("Certificate reloaded")
+			}
+		}
+
+		cfg, err := config.Load(os.Args[1:])
+		if err != nil {
+			slog.Warn("Keeping current configuration", "err", err)
+			continue
+		}
+
+		apiServer.SetLimits(cfg.Limits)
+		slog.Info("Limits reloaded", "limits", cfg.Limits)
+	}
+}
+
+// prune calls deleteBefore every hour to delete the entries of log that are
+// older than retention
+func prune(ctx context.Context, log string, retention time.Duration, deleteBefore func(time.Time) (int64, error)) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		n, err := deleteBefore(time.Now().Add(-retention))
+		if err != nil {
+			slog.Error("Failed to prune "+log, "err", err)
+		} else if n > 0 {
+			slog.Info("Pruned "+log, "entries", n, "retention", retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordMetricsHistory writes the statistics of every finished minute to the
+// metrics history and downsamples it every hour
+func recordMetricsHistory(ctx context.Context, db *database.DB) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	var downsampled time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := db.FlushMetricsHistory(now); err != nil {
+				slog.Error("Failed to write metrics history", "err", err)
+			}
+			if now.Sub(downsampled) >= time.Hour {
+				if err := db.DownsampleMetricsHistory(now); err != nil {
+					slog.Error("Failed to downsample metrics history", "err", err)
+				}
+				downsampled = now
+			}
+		}
+	}
+}
+
+// reencryptJobs re-encrypts jobs every interval until ctx is cancelled.
+// Passes are skipped while no data key has been added since the last one
+// that left nothing behind.
+func reencryptJobs(ctx context.Context, db *database.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	swept := int64(-1)
+	for {
+		newest, err := db.NewestDataKey()
+		if err != nil {
+			slog.Error("Failed to check data keys", "err", err)
+		} else if newest != swept {
+			done, failed, err := db.ReencryptJobs(reencryptBatch)
+			if done > 0 {
+				slog.Info("Re-encrypted jobs", "jobs", done)
+			}
+			if failed > 0 {
+				slog.Error("Failed to decrypt jobs for re-encryption", "jobs", failed)
+			}
+			if err != nil {
+				slog.Error("Failed to re-encrypt jobs", "err", err)
+			} else if failed == 0 {
+				swept = newest
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
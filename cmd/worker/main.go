@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"distributed-task-queue/internal/config"
+	"distributed-task-queue/internal/logging"
+	"distributed-task-queue/internal/models"
+	"distributed-task-queue/internal/tlscert"
+	"distributed-task-queue/internal/tracing"
+	"distributed-task-queue/internal/worker"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	serverURL := flag.String("server", "http://localhost:8080", "base URL of the task queue server")
+	apiKey := flag.String("api-key", os.Getenv("TASKQ_API_KEY"), "operator or admin API key, if the server requires one (env TASKQ_API_KEY)")
+	caFile := flag.String("ca", "", "CA file to verify an https server against (system roots if empty)")
+	certFile := flag.String("cert", "", "client certificate file, for servers verifying client certificates")
+	keyFile := flag.String("key", "", "client certificate key file")
+	logLevel := flag.String("log-level", "info", "minimum log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", logging.FormatText, "log format: text or json")
+	traceOutput := flag.String("trace-output", "", "where to write trace spans as JSON lines: stdout or a file path (off if empty)")
+	workerID := flag.String("id", "", "worker ID (defaults to <hostname>-<pid>)")
+	queues := flag.String("queues", "", "comma-separated queues to lease from (all if empty)")
+	concurrency := flag.Int("concurrency", 1, "number of jobs to run in parallel")
+	pollInterval := flag.Duration("poll", 2*time.Second, "maximum idle poll interval")
+	leaseDuration := flag.Duration("lease", 30*time.Second, "how long a job is leased between renewals")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second,
+		"how long to wait for in-flight jobs on shutdown before releasing them")
+	flag.Parse()
+
+	if err := logging.Setup(os.Stderr, *logLevel, *logFormat); err != nil {
+		fatal("Invalid log settings", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *workerID == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			host = "worker"
+		}
+		*workerID = host + "-" + strconv.Itoa(os.Getpid())
+	}
+
+	exporter, closeTraces, err := tracing.Open(*traceOutput)
+	if err != nil {
+		fatal("Failed to open trace output", err)
+	}
+	defer closeTraces.Close()
+	tracing.SetExporter(exporter)
+
+	tlsConfig, err := tlscert.ClientConfig(*caFile, *certFile, *keyFile)
+	if err != nil {
+		fatal("Invalid TLS settings", err)
+	}
+	source := worker.NewRemoteSource(*serverURL, *apiKey, tlsConfig)
+
+	// Register handlers. The job types they cover are advertised to the
+	// server so that only matching jobs are leased to this worker.
+	handlers := map[string]worker.Handler{
+		models.DefaultType: worker.Simulate,
+		"echo":             echo,
+	}
+
+	// Each slot registers separately so the server can tell their leases
+	// apart
+	pool := worker.NewPool(ctx, func(n int, ctx context.Context) *worker.Worker {
+		id := *workerID
+		if *concurrency > 1 {
+			id += "-" + strconv.Itoa(n)
+		}
+
+		w := worker.NewWithSource(id, source, config.WorkerConfig{
+			PollInterval:  *pollInterval,
+			LeaseDuration: *leaseDuration,
+		}, ctx)
+		w.SetQueues(splitFlag(*queues))
+		for t, h := range handlers {
+			w.Handle(t, h)
+		}
+		return w
+	})
+	pool.Resize(*concurrency)
+	slog.Info("Started worker slots", "count", *concurrency, "server", *serverURL)
+
+	<-ctx.Done()
+	// Restore the default handling so that a second signal kills the process
+	stop()
+	slog.Info("Signal received, draining", "timeout", *drainTimeout)
+	if pool.Drain(*drainTimeout) {
+		slog.Info("All in-flight jobs finished")
+	}
+}
+
+// echo logs the size of the job payload with the job's logger and succeeds.
+// The payload itself is not logged, as it may be secret.
+func echo(ctx context.Context, job *models.Job) error {
+	data, err := job.PayloadData()
+	if err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Info("Echo", "bytes", len(data), "content_type", job.ContentType)
+	return nil
+}
+
+func splitFlag(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+// fatal logs err and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
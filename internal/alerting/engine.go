@@ -0,0 +1,296 @@
+// Package alerting evaluates alert rules against queue metrics and notifies
+// sinks when alerts fire and resolve
+package alerting
+
+import (
+	"context"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxResolved is the number of resolved alerts kept for listing
+const maxResolved = 100
+
+// alertKey identifies the alert of a rule for one group
+type alertKey struct {
+	rule string
+	group
+}
+
+// tracked is an alert that is pending or firing, with the rule that raised it
+type tracked struct {
+	alert models.Alert
+	rule  models.AlertRule
+}
+
+// sample is a gauge value seen by a rule comparing changes
+type sample struct {
+	at    time.Time
+	value float64
+}
+
+// notification is an alert that fired or resolved
+type notification struct {
+	alert models.Alert
+	rule  models.AlertRule
+}
+
+// Engine evaluates the alert rules stored in the database. Alert state is
+// kept in memory: after a restart, conditions that still hold go through
+// their for duration again.
+type Engine struct {
+	db       *database.DB
+	onChange func() // Called when alerts fire or resolve
+	client   *http.Client
+
+	mu        sync.Mutex
+	active    map[alertKey]*tracked
+	resolved  []models.Alert // Newest first
+	samples   map[alertKey][]sample
+	baselined map[string]bool // Rules comparing changes that have been sampled before
+}
+
+// New creates an engine. onChange may be nil.
+func New(db *database.DB, onChange func()) *Engine {
+	return &Engine{
+		db:        db,
+		onChange:  onChange,
+		client:    &http.Client{Timeout: webhookTimeout},
+		active:    make(map[alertKey]*tracked),
+		samples:   make(map[alertKey][]sample),
+		baselined: make(map[string]bool),
+	}
+}
+
+// Start evaluates the rules every interval until ctx is cancelled
+func (e *Engine) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.Evaluate(now)
+		}
+	}
+}
+
+// Evaluate checks every enabled rule once, moving alerts between pending,
+// firing and resolved and notifying the rules' sinks of alerts that fire or
+// resolve
+func (e *Engine) Evaluate(now time.Time) {
+	rules, err := e.db.ListAlertRules()
+	if err != nil {
+		slog.Error("Failed to list alert rules", "err", err)
+		return
+	}
+
+	m := newMeasurer(e.db, now)
+	var notes []notification
+
+	e.mu.Lock()
+	evaluated := make(map[string]bool)
+	for _, rule := range rules {
+		if rule.Disabled {
+			continue
+		}
+		// Alerts of a rule that cannot be measured keep their state
+		evaluated[rule.Name] = true
+
+		values, err := m.measure(rule)
+		if err != nil {
+			slog.Error("Failed to evaluate alert rule", "rule", rule.Name, "err", err)
+			continue
+		}
+		if rule.Change {
+			values = e.changes(rule, values, now)
+		}
+		notes = append(notes, e.apply(rule, values, now)...)
+	}
+
+	// Resolve the alerts of rules that were deleted or disabled
+	for key, t := range e.active {
+		if !evaluated[key.rule] {
+			if n, ok := e.resolve(key, t, now); ok {
+				notes = append(notes, n)
+			}
+		}
+	}
+	for key := range e.samples {
+		if !evaluated[key.rule] {
+			delete(e.samples, key)
+		}
+	}
+	for name := range e.baselined {
+		if !evaluated[name] {
+			delete(e.baselined, name)
+		}
+	}
+	e.mu.Unlock()
+
+	for _, n := range notes {
+		e.notify(n)
+	}
+	if len(notes) > 0 && e.onChange != nil {
+		e.onChange()
+	}
+}
+
+// apply updates the alerts of a rule with its values and returns the alerts
+// that fired or resolved
+func (e *Engine) apply(rule models.AlertRule, values map[group]float64, now time.Time) []notification {
+	var notes []notification
+
+	holding := make(map[alertKey]bool)
+	for g, v := range values {
+		if holds := compare(rule.Op, v, rule.Threshold); holds == nil || !*holds {
+			continue
+		}
+		key := alertKey{rule.Name, g}
+		holding[key] = true
+
+		t, ok := e.active[key]
+		if !ok {
+			t = &tracked{alert: models.Alert{
+				Rule:     rule.Name,
+				Queue:    g.queue,
+				TenantID: g.tenantID,
+				State:    models.AlertPending,
+				ActiveAt: now,
+			}}
+			e.active[key] = t
+		}
+		t.rule = rule
+		t.alert.Value = v
+		t.alert.Threshold = rule.Threshold
+		t.alert.Summary = summary(rule, t.alert)
+
+		if t.alert.State == models.AlertPending && now.Sub(t.alert.ActiveAt) >= time.Duration(rule.ForSeconds)*time.Second {
+			firedAt := now
+			t.alert.State = models.AlertFiring
+			t.alert.FiredAt = &firedAt
+			notes = append(notes, notification{alert: t.alert, rule: rule})
+		}
+	}
+
+	for key, t := range e.active {
+		if key.rule == rule.Name && !holding[key] {
+			if v, ok := values[key.group]; ok {
+				t.alert.Value = v
+				t.alert.Summary = summary(rule, t.alert)
+			}
+			if n, ok := e.resolve(key, t, now); ok {
+				notes = append(notes, n)
+			}
+		}
+	}
+	return notes
+}
+
+// resolve ends an alert whose condition no longer holds. Only alerts that
+// fired are kept as resolved and returned for notification.
+func (e *Engine) resolve(key alertKey, t *tracked, now time.Time) (notification, bool) {
+	delete(e.active, key)
+	if t.alert.State != models.AlertFiring {
+		return notification{}, false
+	}
+
+	resolvedAt := now
+	t.alert.State = models.AlertResolved
+	t.alert.ResolvedAt = &resolvedAt
+	e.resolved = append([]models.Alert{t.alert}, e.resolved...)
+	if len(e.resolved) > maxResolved {
+		e.resolved = e.resolved[:maxResolved]
+	}
+	return notification{alert: t.alert, rule: t.rule}, true
+}
+
+// changes returns how much each value changed over the rule's window,
+// comparing with the value seen a window ago, or the oldest one seen since.
+// Groups that appear after the rule was first evaluated changed from zero.
+func (e *Engine) changes(rule models.AlertRule, values map[group]float64, now time.Time) map[group]float64 {
+	cutoff := now.Add(-window(rule))
+	changes := make(map[group]float64, len(values))
+
+	for g, v := range values {
+		key := alertKey{rule.Name, g}
+		samples := e.samples[key]
+		// Keep the newest sample at or before the cutoff as the baseline
+		for len(samples) > 1 && !samples[1].at.After(cutoff) {
+			samples = samples[1:]
+		}
+
+		switch {
+		case len(samples) > 0:
+			changes[g] = v - samples[0].value
+		case e.baselined[rule.Name]:
+			changes[g] = v
+		default:
+			changes[g] = 0
+		}
+		e.samples[key] = append(samples, sample{at: now, value: v})
+	}
+
+	// Groups that went away dropped to zero
+	for key := range e.samples {
+		if _, ok := values[key.group]; key.rule == rule.Name && !ok {
+			delete(e.samples, key)
+		}
+	}
+	e.baselined[rule.Name] = true
+	return changes
+}
+
+// Alerts returns the pending and firing alerts, oldest first, followed by the
+// most recently resolved ones, newest first. A non-empty tenantID limits them
+// to that tenant.
+func (e *Engine) Alerts(tenantID string) []models.Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	alerts := []models.Alert{}
+	for _, t := range e.active {
+		alerts = append(alerts, t.alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		a, b := alerts[i], alerts[j]
+		if !a.ActiveAt.Equal(b.ActiveAt) {
+			return a.ActiveAt.Before(b.ActiveAt)
+		}
+		return a.Rule < b.Rule || (a.Rule == b.Rule && a.Queue+"/"+a.TenantID < b.Queue+"/"+b.TenantID)
+	})
+	alerts = append(alerts, e.resolved...)
+
+	if tenantID == "" {
+		return alerts
+	}
+	own := alerts[:0]
+	for _, a := range alerts {
+		if a.TenantID == tenantID {
+			own = append(own, a)
+		}
+	}
+	return own
+}
+
+// Banner returns the firing alerts of rules that notify the dashboard
+func (e *Engine) Banner() []models.Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	alerts := []models.Alert{}
+	for _, t := range e.active {
+		if t.alert.State == models.AlertFiring && hasSink(t.rule, SinkWebSocket) {
+			alerts = append(alerts, t.alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].FiredAt.Before(*alerts[j].FiredAt) })
+	return alerts
+}
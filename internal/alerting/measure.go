@@ -0,0 +1,174 @@
+package alerting
+
+import (
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/metrics"
+	"distributed-task-queue/internal/models"
+	"time"
+)
+
+// group is the queue and tenant an alert is about. Fields are empty unless
+// the rule groups or filters by them.
+type group struct {
+	queue, tenantID string
+}
+
+// summaryKey identifies a summary of the metrics history
+type summaryKey struct {
+	window            time.Duration
+	byQueue, byTenant bool
+}
+
+// measurer reads the metrics of one evaluation, querying each source once
+type measurer struct {
+	db  *database.DB
+	now time.Time
+
+	depth     []metrics.Sample
+	summaries map[summaryKey][]models.MetricsSeries
+}
+
+func newMeasurer(db *database.DB, now time.Time) *measurer {
+	return &measurer{db: db, now: now, summaries: make(map[summaryKey][]models.MetricsSeries)}
+}
+
+// measure returns the value of a rule's metric per group. Groups without a
+// value, such as the failure rate of a queue that ran nothing, are left out.
+func (m *measurer) measure(rule models.AlertRule) (map[group]float64, error) {
+	values := make(map[group]float64)
+	// A total is zero rather than missing when nothing matches
+	if rule.GroupBy == "" && rule.Metric != MetricFailureRate && rule.Metric != MetricWaitP95 &&
+		rule.Metric != MetricRunP95 {
+		values[groupOf(rule, "", "")] = 0
+	}
+
+	switch {
+	case rule.Metric == MetricWorkersAlive:
+		workers, err := m.db.ListWorkers(models.WorkerAlive)
+		if err != nil {
+			return nil, err
+		}
+		values[group{}] = float64(len(workers))
+	case gauges[rule.Metric]:
+		depth, err := m.queueDepth()
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range depth {
+			status, queue, tenantID := s.LabelValues[0], s.LabelValues[1], s.LabelValues[2]
+			if !countsTowards(rule.Metric, status) || !matches(rule, queue, tenantID) {
+				continue
+			}
+			values[groupOf(rule, queue, tenantID)] += s.Value
+		}
+	default:
+		series, err := m.summary(rule)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range series {
+			if !matches(rule, s.Queue, s.TenantID) || len(s.Points) == 0 {
+				continue
+			}
+			// Failure rates and percentiles cannot be added up, so series
+			// are summarized per group
+			if v, ok := windowValue(rule.Metric, s.Points[0]); ok {
+				values[groupOf(rule, s.Queue, s.TenantID)] = v
+			}
+		}
+	}
+	return values, nil
+}
+
+// queueDepth returns the jobs that are not done by status, queue and tenant
+func (m *measurer) queueDepth() ([]metrics.Sample, error) {
+	if m.depth == nil {
+		depth, err := m.db.GetQueueDepth()
+		if err != nil {
+			return nil, err
+		}
+		m.depth = depth
+	}
+	return m.depth, nil
+}
+
+// summary returns the metrics history over a rule's window, merged down to
+// the queues and tenants the rule groups or filters by
+func (m *measurer) summary(rule models.AlertRule) ([]models.MetricsSeries, error) {
+	key := summaryKey{
+		window:   window(rule),
+		byQueue:  rule.GroupBy == GroupByQueue || rule.Queue != "",
+		byTenant: rule.GroupBy == GroupByTenant || rule.TenantID != "",
+	}
+	if series, ok := m.summaries[key]; ok {
+		return series, nil
+	}
+
+	from := m.now.Add(-key.window).Truncate(database.HistoryMinute)
+	series, err := m.db.SummarizeMetricsHistory(from, m.now, key.byQueue, key.byTenant)
+	if err != nil {
+		return nil, err
+	}
+	m.summaries[key] = series
+	return series, nil
+}
+
+// countsTowards reports whether jobs with a queue depth status count towards
+// a gauge metric
+func countsTowards(metric, status string) bool {
+	switch metric {
+	case MetricBacklog:
+		return status == models.StatusPending || status == models.StatusFailed
+	case MetricRunning:
+		return status == models.StatusRunning
+	case MetricDLQ:
+		return status == "dlq"
+	}
+	return false
+}
+
+// matches reports whether a queue and tenant pass a rule's filters
+func matches(rule models.AlertRule, queue, tenantID string) bool {
+	return (rule.Queue == "" || rule.Queue == queue) && (rule.TenantID == "" || rule.TenantID == tenantID)
+}
+
+// groupOf returns the group of a rule that a queue and tenant fall in
+func groupOf(rule models.AlertRule, queue, tenantID string) group {
+	g := group{queue: rule.Queue, tenantID: rule.TenantID}
+	switch rule.GroupBy {
+	case GroupByQueue:
+		g.queue = queue
+	case GroupByTenant:
+		g.tenantID = tenantID
+	}
+	return g
+}
+
+// windowValue returns a windowed metric from a summary point, if it has one
+func windowValue(metric string, p models.MetricsPoint) (float64, bool) {
+	switch metric {
+	case MetricEnqueued:
+		return float64(p.Enqueued), true
+	case MetricCompleted:
+		return float64(p.Completed), true
+	case MetricFailed:
+		return float64(p.Failed), true
+	case MetricFailureRate:
+		finished := p.Completed + p.Failed
+		if finished == 0 {
+			return 0, false
+		}
+		return float64(p.Failed) / float64(finished), true
+	case MetricWaitP95:
+		if p.WaitP95 == nil {
+			return 0, false
+		}
+		return *p.WaitP95, true
+	case MetricRunP95:
+		if p.RunP95 == nil {
+			return 0, false
+		}
+		return *p.RunP95, true
+	}
+	return 0, false
+}
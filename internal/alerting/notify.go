@@ -0,0 +1,72 @@
+package alerting
+
+import (
+	"bytes"
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Webhook delivery
+const (
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = 3
+	webhookBackoff  = 2 * time.Second // Doubled after each failed attempt
+)
+
+// notify sends an alert that fired or resolved to its rule's sinks. Dashboard
+// banners are not sent but read through Banner.
+func (e *Engine) notify(n notification) {
+	for _, sink := range sinks(n.rule) {
+		switch sink.Type {
+		case SinkLog:
+			attrs := []interface{}{"rule", n.alert.Rule, "summary", n.alert.Summary,
+				"queue", n.alert.Queue, "tenant_id", n.alert.TenantID}
+			if n.alert.State == models.AlertFiring {
+				slog.Warn("Alert firing", attrs...)
+			} else {
+				slog.Info("Alert resolved", attrs...)
+			}
+		case SinkWebhook:
+			go e.postWebhook(sink.URL, n)
+		}
+	}
+}
+
+// postWebhook posts an alert as JSON, retrying failed deliveries
+func (e *Engine) postWebhook(url string, n notification) {
+	body, err := json.Marshal(n.alert)
+	if err != nil {
+		slog.Error("Failed to encode alert", "rule", n.alert.Rule, "err", err)
+		return
+	}
+
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err = e.post(url, body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	slog.Error("Failed to deliver alert webhook", "rule", n.alert.Rule, "state", n.alert.State,
+		"attempts", webhookAttempts, "err", err)
+}
+
+func (e *Engine) post(url string, body []byte) error {
+	resp, err := e.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package alerting
+
+import (
+	"distributed-task-queue/internal/models"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Metrics a rule can watch. Gauges are read at each evaluation; the others
+// are counted over the rule's window from the metrics history.
+const (
+	MetricBacklog      = "backlog" // Jobs waiting to run, including failed jobs awaiting a retry
+	MetricRunning      = "running"
+	MetricDLQ          = "dlq"
+	MetricWorkersAlive = "workers_alive" // Registered workers, in-process or remote
+	MetricEnqueued     = "enqueued"
+	MetricCompleted    = "completed"
+	MetricFailed       = "failed"       // Failed attempts, including those moved to the DLQ
+	MetricFailureRate  = "failure_rate" // Failed attempts over finished attempts, 0 to 1
+	MetricWaitP95      = "wait_p95"     // Queue wait in seconds
+	MetricRunP95       = "run_p95"      // Execution time in seconds
+)
+
+// gauges are the metrics read at each evaluation
+var gauges = map[string]bool{
+	MetricBacklog:      true,
+	MetricRunning:      true,
+	MetricDLQ:          true,
+	MetricWorkersAlive: true,
+}
+
+// windowed are the metrics counted over a window
+var windowed = map[string]bool{
+	MetricEnqueued:    true,
+	MetricCompleted:   true,
+	MetricFailed:      true,
+	MetricFailureRate: true,
+	MetricWaitP95:     true,
+	MetricRunP95:      true,
+}
+
+// Groupings of a rule's alerts
+const (
+	GroupByQueue  = "queue"
+	GroupByTenant = "tenant"
+)
+
+// Sink types
+const (
+	SinkLog       = "log"
+	SinkWebSocket = "websocket" // Banner on the dashboard
+	SinkWebhook   = "webhook"
+)
+
+// DefaultWindow is the window of rules that do not set one
+const DefaultWindow = 5 * time.Minute
+
+// defaultSinks notify rules that do not list any
+var defaultSinks = []models.AlertSink{{Type: SinkLog}, {Type: SinkWebSocket}}
+
+// Validate checks that a rule can be evaluated
+func Validate(rule models.AlertRule) error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(rule.Name != "" && !strings.Contains(rule.Name, "/"), "name is required and must not contain /")
+	check(gauges[rule.Metric] || windowed[rule.Metric], "unknown metric %q", rule.Metric)
+	check(!rule.Change || gauges[rule.Metric], "change only applies to backlog, running, dlq and workers_alive")
+	check(compare(rule.Op, 0, 0) != nil, "op must be >, >=, < or <=")
+	check(!math.IsNaN(rule.Threshold) && !math.IsInf(rule.Threshold, 0), "threshold must be a number")
+	check(rule.WindowSeconds == 0 || rule.WindowSeconds >= 60, "window_seconds must be at least 60")
+	check(rule.ForSeconds >= 0, "for_seconds must not be negative")
+	check(rule.GroupBy == "" || rule.GroupBy == GroupByQueue || rule.GroupBy == GroupByTenant,
+		"group_by must be %q or %q", GroupByQueue, GroupByTenant)
+	check(rule.Metric != MetricWorkersAlive || (rule.GroupBy == "" && rule.Queue == "" && rule.TenantID == ""),
+		"workers_alive cannot be grouped or filtered")
+
+	for _, sink := range rule.Notify {
+		switch sink.Type {
+		case SinkLog, SinkWebSocket:
+		case SinkWebhook:
+			u, err := url.Parse(sink.URL)
+			check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+				"webhook url must be an http or https URL")
+		default:
+			check(false, "unknown sink type %q", sink.Type)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// window returns the span of a rule's windowed metric or change
+func window(rule models.AlertRule) time.Duration {
+	if rule.WindowSeconds == 0 {
+		return DefaultWindow
+	}
+	return time.Duration(rule.WindowSeconds) * time.Second
+}
+
+// sinks returns where a rule's alerts are sent
+func sinks(rule models.AlertRule) []models.AlertSink {
+	if len(rule.Notify) == 0 {
+		return defaultSinks
+	}
+	return rule.Notify
+}
+
+// hasSink reports whether a rule's alerts are sent to a sink of type
+func hasSink(rule models.AlertRule, typ string) bool {
+	for _, sink := range sinks(rule) {
+		if sink.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// compare reports whether value op threshold holds, or nil if op is unknown
+func compare(op string, value, threshold float64) *bool {
+	var holds bool
+	switch op {
+	case ">":
+		holds = value > threshold
+	case ">=":
+		holds = value >= threshold
+	case "<":
+		holds = value < threshold
+	case "<=":
+		holds = value <= threshold
+	default:
+		return nil
+	}
+	return &holds
+}
+
+// summary describes an alert, e.g. "backlog > 100 for queue emails: 153"
+func summary(rule models.AlertRule, alert models.Alert) string {
+	var b strings.Builder
+	if rule.Change {
+		fmt.Fprintf(&b, "change of %s over %v", rule.Metric, window(rule))
+	} else if windowed[rule.Metric] {
+		fmt.Fprintf(&b, "%s over %v", rule.Metric, window(rule))
+	} else {
+		b.WriteString(rule.Metric)
+	}
+	fmt.Fprintf(&b, " %s %g", rule.Op, rule.Threshold)
+
+	var labels []string
+	if alert.Queue != "" {
+		labels = append(labels, "queue "+alert.Queue)
+	}
+	if alert.TenantID != "" {
+		labels = append(labels, "tenant "+alert.TenantID)
+	}
+	if len(labels) > 0 {
+		b.WriteString(" for " + strings.Join(labels, ", "))
+	}
+
+	fmt.Fprintf(&b, ": %g", math.Round(alert.Value*1e4)/1e4)
+	return b.String()
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"net/http"
+)
+
+// PoolController exposes the in-process worker pool to the admin API
+type PoolController interface {
+	Stats() models.PoolStats
+	Apply(req models.PoolResizeRequest) error
+}
+
+// SetPool enables the pool admin endpoint and pool metrics
+func (s *Server) SetPool(pool PoolController) {
+	s.pool = pool
+}
+
+// HandlePool returns the worker pool state on GET and resizes it on POST
+func (s *Server) HandlePool(w http.ResponseWriter, r *http.Request) {
+	if s.pool == nil {
+		http.Error(w, "No worker pool in this process", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req models.PoolResizeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		before := poolSettings(s.pool.Stats())
+		recordChange(r, "pool.resize", "pool", nil, nil)
+		if err := s.pool.Apply(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		recordChange(r, "pool.resize", "pool", before, poolSettings(s.pool.Stats()))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.pool.Stats())
+}
+
+// poolSettings returns the part of the pool state that requests change
+func poolSettings(stats models.PoolStats) map[string]interface{} {
+	return map[string]interface{}{
+		"size":      stats.Size,
+		"min_size":  stats.MinSize,
+		"max_size":  stats.MaxSize,
+		"autoscale": stats.Autoscale,
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/alerting"
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// SetAlerts enables listing the alerts of an engine; nil when alerting is off
+func (s *Server) SetAlerts(alerts *alerting.Engine) {
+	s.alerts = alerts
+}
+
+// ListAlerts returns the pending and firing alerts followed by recently
+// resolved ones. Tenant keys only see their own tenant's alerts.
+func (s *Server) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	alerts := []models.Alert{}
+	if s.alerts != nil {
+		alerts = s.alerts.Alerts(principal(r).TenantID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// ListAlertRules returns all alert rules
+func (s *Server) ListAlertRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rules, err := s.db.ListAlertRules()
+	if err != nil {
+		slog.Error("Failed to list alert rules", "err", err)
+		http.Error(w, "Failed to fetch alert rules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// HandleAlertRule returns an alert rule on GET, creates or replaces it on PUT
+// and deletes it on DELETE. The rule is named by the path.
+func (s *Server) HandleAlertRule(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/admin/alert-rules/")
+	if name == "" || strings.Contains(name, "/") {
+		http.Error(w, "rule name is required", http.StatusBadRequest)
+		return
+	}
+
+	target := "alert_rule:" + name
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		recordChange(r, "alert_rule.update", target, nil, nil)
+		var rule models.AlertRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		rule.Name = name
+		if err := alerting.Validate(rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		before, err := s.alertRule(name)
+		if err != nil {
+			slog.Error("Failed to get alert rule", "rule", name, "err", err)
+			http.Error(w, "Failed to save alert rule", http.StatusInternalServerError)
+			return
+		}
+
+		if err := s.db.UpsertAlertRule(&rule); err != nil {
+			slog.Error("Failed to save alert rule", "rule", name, "err", err)
+			http.Error(w, "Failed to save alert rule", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Alert rule saved", "rule", name, "by", principal(r).Name)
+		recordChange(r, "alert_rule.update", target, before, rule)
+	case http.MethodDelete:
+		recordChange(r, "alert_rule.delete", target, nil, nil)
+		before, err := s.alertRule(name)
+		if err != nil {
+			slog.Error("Failed to get alert rule", "rule", name, "err", err)
+			http.Error(w, "Failed to delete alert rule", http.StatusInternalServerError)
+			return
+		}
+
+		found, err := s.db.DeleteAlertRule(name)
+		if err != nil {
+			slog.Error("Failed to delete alert rule", "rule", name, "err", err)
+			http.Error(w, "Failed to delete alert rule", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Alert rule not found", http.StatusNotFound)
+			return
+		}
+		slog.Info("Alert rule deleted", "rule", name, "by", principal(r).Name)
+		recordChange(r, "alert_rule.delete", target, before, nil)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rule, err := s.db.GetAlertRule(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Alert rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to get alert rule", "rule", name, "err", err)
+		http.Error(w, "Failed to fetch alert rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// alertRule returns an alert rule, or nil if there is none by that name
+func (s *Server) alertRule(name string) (*models.AlertRule, error) {
+	rule, err := s.db.GetAlertRule(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return rule, err
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/config"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"distributed-task-queue/internal/websocket"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newTestDB opens an empty database in a temporary directory
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	return openTestDB(t, filepath.Join(t.TempDir(), "tasks.db"))
+}
+
+// openTestDB opens the database at path, creating it if needed
+func openTestDB(t *testing.T, path string) *database.DB {
+	t.Helper()
+	db, err := database.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// testServer is an API server with authentication enabled and its routes
+type testServer struct {
+	*Server
+	mux *http.ServeMux
+}
+
+// newTestServer creates a server on db with the default configuration,
+// changed by configure if it is not nil
+func newTestServer(t *testing.T, db *database.DB, configure func(*config.Config)) *testServer {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Auth.Enabled = true
+	if configure != nil {
+		configure(cfg)
+	}
+
+	wsManager := websocket.New(db)
+	t.Cleanup(wsManager.Close)
+	s := &testServer{Server: NewServer(db, wsManager, cfg), mux: http.NewServeMux()}
+	s.SetupRoutes(s.mux)
+	return s
+}
+
+// key creates an API key and returns its secret
+func (s *testServer) key(t *testing.T, role, tenantID string) string {
+	t.Helper()
+	k, key, hash, err := auth.NewKey(models.APIKeyRequest{Name: role, Role: role, TenantID: tenantID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.InsertAPIKey(k, hash); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// do sends a request with an API key, if not empty, and a JSON body, if not
+// empty
+func (s *testServer) do(method, path, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	return w
+}
+
+// expect fails the test unless a response has the wanted status
+func expect(t *testing.T, w *httptest.ResponseRecorder, status int, what string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("%s: got %d, want %d: %s", what, w.Code, status, strings.TrimSpace(w.Body.String()))
+	}
+}
+
+// decode decodes a JSON response body into v
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+		t.Fatalf("decoding %q: %v", w.Body.String(), err)
+	}
+}
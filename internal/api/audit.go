@@ -0,0 +1,215 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/models"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pagination of GET /api/audit
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// requestIDHeader carries the ID of a request. A valid ID sent by the client
+// is kept so that its own logs can be matched with the audit log.
+const requestIDHeader = "X-Request-ID"
+
+// auditChange is the change a handler made, filled in through recordChange
+type auditChange struct {
+	action, target string
+	before, after  interface{}
+}
+
+type auditKey struct{}
+
+// recordChange describes the change made by an audited request. before is
+// nil for creations and after is nil for deletions; only the fields that
+// differ between them are stored.
+func recordChange(r *http.Request, action, target string, before, after interface{}) {
+	if c, ok := r.Context().Value(auditKey{}).(*auditChange); ok {
+		*c = auditChange{action: action, target: target, before: before, after: after}
+	}
+}
+
+// withAuditChange returns r with room for the change its handler makes
+func withAuditChange(r *http.Request) (*http.Request, *auditChange) {
+	c := &auditChange{}
+	return r.WithContext(context.WithValue(r.Context(), auditKey{}, c)), c
+}
+
+// newAuditEntry starts the audit entry of a request made by p
+func newAuditEntry(r *http.Request, p *auth.Principal, perm auth.Permission, requestID string) *models.AuditEntry {
+	return &models.AuditEntry{
+		CreatedAt:  time.Now(),
+		Actor:      p.Name,
+		KeyID:      p.KeyID,
+		Role:       p.Role,
+		SourceIP:   sourceIP(r),
+		RequestID:  requestID,
+		Permission: string(perm),
+		Action:     strings.ToLower(r.Method),
+		Target:     r.URL.Path,
+	}
+}
+
+// audit completes an entry with the change its handler recorded, logs it and
+// appends it to the audit log
+func (s *Server) audit(e *models.AuditEntry, c *auditChange) {
+	if c != nil && c.action != "" {
+		e.Action, e.Target = c.action, c.target
+		e.Before, e.After = diff(c.before, c.after)
+	}
+
+	slog.Info("Audit", "request_id", e.RequestID, "actor", e.Actor, "key_id", e.KeyID, "role", e.Role,
+		"source_ip", e.SourceIP, "action", e.Action, "target", e.Target, "outcome", e.Outcome, "status", e.Status)
+	if err := s.db.InsertAuditEntry(e); err != nil {
+		slog.Error("Failed to write audit log", "request_id", e.RequestID, "err", err)
+	}
+}
+
+// diff returns the JSON fields of before and after that differ. Values that
+// are not JSON objects are returned whole.
+func diff(before, after interface{}) (json.RawMessage, json.RawMessage) {
+	b, bok := fields(before)
+	a, aok := fields(after)
+	if bok && aok {
+		for k, v := range b {
+			if av, ok := a[k]; ok && reflect.DeepEqual(v, av) {
+				delete(b, k)
+				delete(a, k)
+			}
+		}
+	}
+	return marshal(b, before), marshal(a, after)
+}
+
+// fields decodes v into its JSON object fields
+func fields(v interface{}) (map[string]interface{}, bool) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, false
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(raw, &m); err != nil || m == nil {
+		return nil, false
+	}
+	return m, true
+}
+
+func marshal(m map[string]interface{}, v interface{}) json.RawMessage {
+	if m == nil {
+		if v == nil || reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil() {
+			return nil
+		}
+		raw, _ := json.Marshal(v)
+		return raw
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	raw, _ := json.Marshal(m)
+	return raw
+}
+
+// requestID returns the client's request ID if it is reasonable, or a new one
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= 64 &&
+		strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.:") == "" {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sourceIP returns the address a request came from
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// ListAudit returns audit entries, newest first. It filters on the key_id,
+// action, target and outcome query parameters and on since and until
+// (RFC 3339). Pages hold up to limit entries; pass next_before from a
+// response as before to get the next page.
+func (s *Server) ListAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	f := models.AuditFilter{
+		KeyID:   q.Get("key_id"),
+		Action:  q.Get("action"),
+		Target:  q.Get("target"),
+		Outcome: q.Get("outcome"),
+		Limit:   defaultAuditLimit,
+	}
+
+	var err error
+	parseTime := func(name string, t *time.Time) {
+		if v := q.Get(name); v != "" && err == nil {
+			*t, err = time.Parse(time.RFC3339, v)
+		}
+	}
+	parseInt := func(name string, n *int64) {
+		if v := q.Get(name); v != "" && err == nil {
+			*n, err = strconv.ParseInt(v, 10, 64)
+		}
+	}
+	var limit, before int64
+	parseTime("since", &f.Since)
+	parseTime("until", &f.Until)
+	parseInt("limit", &limit)
+	parseInt("before", &before)
+	if err != nil || limit < 0 || before < 0 {
+		http.Error(w, "Invalid query parameter", http.StatusBadRequest)
+		return
+	}
+	if limit > 0 {
+		f.Limit = int(min(limit, maxAuditLimit))
+	}
+	f.BeforeID = before
+
+	entries, err := s.db.ListAuditEntries(f)
+	if err != nil {
+		slog.Error("Failed to query audit log", "err", err)
+		http.Error(w, "Failed to fetch audit log", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{"entries": entries}
+	if len(entries) == f.Limit {
+		resp["next_before"] = entries[len(entries)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/models"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// keyTouchInterval limits how often the last use of a key is written
+const keyTouchInterval = time.Minute
+
+var errInvalidKey = errors.New("missing or invalid API key")
+
+// keyUsage remembers when each key's last use was written
+type keyUsage struct {
+	mu      sync.Mutex
+	touched map[string]time.Time
+}
+
+// authenticate returns the principal making a request, identified by its API
+// key or else its client certificate. Every request acts as auth.Anonymous
+// when authentication is disabled.
+func (s *Server) authenticate(r *http.Request) (*auth.Principal, error) {
+	if !s.authEnabled {
+		return auth.Anonymous, nil
+	}
+
+	key := requestKey(r)
+	if key == "" {
+		if p := s.certPrincipal(r); p != nil {
+			return p, nil
+		}
+	}
+	id, ok := auth.ParseKey(key)
+	if !ok {
+		return nil, errInvalidKey
+	}
+
+	k, hash, err := s.db.GetAPIKey(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if k.RevokedAt != nil || !auth.MatchKey(key, hash) {
+		return nil, errInvalidKey
+	}
+
+	s.touchKey(id)
+	return &auth.Principal{KeyID: k.ID, Name: k.Name, TenantID: k.TenantID, Role: k.Role}, nil
+}
+
+// certPrincipal returns the identity mapped to the subject of a request's
+// verified client certificate, or nil
+func (s *Server) certPrincipal(r *http.Request) *auth.Principal {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	id, ok := s.clientSubjects[subject]
+	if !ok {
+		return nil
+	}
+	return &auth.Principal{Name: "cert:" + subject, TenantID: id.TenantID, Role: id.Role}
+}
+
+// checkOrigin returns the WebSocket origin check for the allowed origins.
+// Without any, only same-origin browsers and non-browser clients may connect.
+func checkOrigin(allowed []string) func(r *http.Request) bool {
+	if len(allowed) == 0 {
+		return nil
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, a := range allowed {
+			if a == "*" || strings.EqualFold(a, origin) {
+				return true
+			}
+		}
+		slog.Warn("Rejected WebSocket origin", "origin", origin)
+		return false
+	}
+}
+
+// requestKey returns the API key sent with a request
+func requestKey(r *http.Request) string {
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return key
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	// Browsers cannot set headers on WebSocket connections or event streams
+	if r.URL.Path == "/ws" || r.Header.Get("Accept") == "text/event-stream" {
+		return r.URL.Query().Get("api_key")
+	}
+	return ""
+}
+
+// touchKey records the use of a key at most once per keyTouchInterval
+func (s *Server) touchKey(id string) {
+	now := time.Now()
+
+	s.keyUsage.mu.Lock()
+	if now.Sub(s.keyUsage.touched[id]) < keyTouchInterval {
+		s.keyUsage.mu.Unlock()
+		return
+	}
+	s.keyUsage.touched[id] = now
+	s.keyUsage.mu.Unlock()
+
+	if err := s.db.TouchAPIKey(id, now); err != nil {
+		slog.Error("Failed to record use of key", "key_id", id, "err", err)
+	}
+}
+
+// require authenticates a request and passes it to h if the caller's role
+// grants perm. Denials and changes are written to the audit log.
+func (s *Server) require(perm auth.Permission, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reqID := requestID(r)
+		w.Header().Set(requestIDHeader, reqID)
+
+		p, err := s.authenticate(r)
+		if errors.Is(err, errInvalidKey) {
+			slog.Warn("Rejected request", "request_id", reqID, "method", r.Method, "path", r.URL.Path,
+				"source_ip", sourceIP(r), "err", err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="task-queue"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			slog.Error("Failed to authenticate request", "request_id", reqID, "err", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		entry := newAuditEntry(r, p, perm, reqID)
+		if !p.Can(perm) {
+			entry.Outcome, entry.Status = models.AuditDenied, http.StatusForbidden
+			s.audit(entry, nil)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		r = r.WithContext(auth.WithPrincipal(r.Context(), p))
+		if !perm.Audited() || r.Method == http.MethodGet {
+			h(w, r)
+			return
+		}
+
+		r, change := withAuditChange(r)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r)
+		entry.Outcome, entry.Status = models.AuditSucceeded, rec.status
+		if rec.status >= 400 {
+			entry.Outcome = models.AuditFailed
+		}
+		s.audit(entry, change)
+	}
+}
+
+// principal returns the caller of a request passed through require. A handler
+// reached without require is a routing mistake, and it panics rather than
+// act for anyone.
+func principal(r *http.Request) *auth.Principal {
+	p := auth.FromContext(r.Context())
+	if p == nil {
+		panic("api: request for " + r.URL.Path + " has no principal")
+	}
+	return p
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/config"
+	"distributed-task-queue/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ws "github.com/gorilla/websocket"
+)
+
+func TestClientCertificateSubjects(t *testing.T) {
+	s := newTestServer(t, newTestDB(t), func(cfg *config.Config) {
+		cfg.Server.TLS.ClientSubjects = map[string]config.ClientIdentity{
+			"worker-a": {Role: auth.RoleOperator},
+			"acme-ci":  {Role: auth.RoleSubmitter, TenantID: "acme"},
+		}
+	})
+
+	// withCert sends a request over a connection with a verified client
+	// certificate for subject, or without one if subject is empty
+	withCert := func(method, path, subject, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.TLS = &tls.ConnectionState{}
+		if subject != "" {
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: subject}}
+			r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		w := httptest.NewRecorder()
+		s.mux.ServeHTTP(w, r)
+		return w
+	}
+
+	expect(t, withCert("GET", "/api/workers", "worker-a", ""), http.StatusOK, "operator certificate")
+	expect(t, withCert("GET", "/api/workers", "", ""), http.StatusUnauthorized, "no certificate")
+	expect(t, withCert("GET", "/api/workers", "unknown", ""), http.StatusUnauthorized, "unmapped certificate")
+	expect(t, withCert("GET", "/api/workers", "acme-ci", ""), http.StatusForbidden, "submitter certificate")
+
+	// A tenant certificate submits for its own tenant only
+	w := withCert("POST", "/api/jobs", "acme-ci", `{"tenant_id": "globex", "payload": "x"}`)
+	expect(t, w, http.StatusForbidden, "submission for another tenant")
+	w = withCert("POST", "/api/jobs", "acme-ci", `{"payload": "x"}`)
+	expect(t, w, http.StatusCreated, "submission")
+	var job models.Job
+	decode(t, w, &job)
+	if job.TenantID != "acme" {
+		t.Fatalf("job submitted for %q, want acme", job.TenantID)
+	}
+
+	// An API key takes precedence over the certificate
+	r := httptest.NewRequest("GET", "/api/workers", nil)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "worker-a"}}}}}
+	r.Header.Set("Authorization", "Bearer "+s.key(t, auth.RoleViewer, ""))
+	w = httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	expect(t, w, http.StatusForbidden, "viewer key with an operator certificate")
+}
+
+func TestWebSocketOrigins(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		allowed []string
+		origin  string
+		want    int
+	}{
+		{"no origin", []string{"https://dash.example.com"}, "", http.StatusSwitchingProtocols},
+		{"allowed origin", []string{"https://dash.example.com"}, "https://DASH.example.com", http.StatusSwitchingProtocols},
+		{"other origin", []string{"https://dash.example.com"}, "https://evil.example.com", http.StatusForbidden},
+		{"any origin", []string{"*"}, "https://evil.example.com", http.StatusSwitchingProtocols},
+		{"cross origin by default", nil, "https://evil.example.com", http.StatusForbidden},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, newTestDB(t), func(cfg *config.Config) {
+				cfg.Server.AllowedOrigins = tt.allowed
+			})
+			key := s.key(t, auth.RoleViewer, "")
+			srv := httptest.NewServer(s.mux)
+			defer srv.Close()
+
+			header := http.Header{"Authorization": {"Bearer " + key}}
+			if tt.origin != "" {
+				header.Set("Origin", tt.origin)
+			}
+			conn, resp, err := ws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+			if err == nil {
+				conn.Close()
+			}
+			if resp == nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Fatalf("got %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
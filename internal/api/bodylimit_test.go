@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bytes"
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/config"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// endless is a body that never ends and counts the bytes read from it
+type endless struct{ read int64 }
+
+func (e *endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'a'
+	}
+	e.read += int64(len(p))
+	return len(p), nil
+}
+
+// send sends a request with a body and returns the response
+func (s *testServer) send(method, path, key, contentType string, body io.Reader) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, body)
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestSubmissionBodyIsLimited(t *testing.T) {
+	const maxPayload = 1000
+	s := newTestServer(t, newTestDB(t), func(cfg *config.Config) {
+		cfg.Limits.MaxPayloadBytes = maxPayload
+	})
+	key := s.key(t, auth.RoleSubmitter, "acme")
+
+	// A JSON submission is read up to the limit only
+	body := &endless{}
+	w := s.send("POST", "/api/jobs", key, "application/json",
+		io.MultiReader(strings.NewReader(`{"payload": "`), body))
+	expect(t, w, http.StatusRequestEntityTooLarge, "endless JSON submission")
+	if body.read > maxSubmissionBytes(maxPayload)+64<<10 {
+		t.Errorf("read %d bytes of an endless JSON submission", body.read)
+	}
+
+	// So is a multipart payload part
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	mw.WriteField("job", `{}`)
+	part, _ := mw.CreateFormFile("payload", "data.bin")
+	part.Write(bytes.Repeat([]byte("a"), maxPayload+1))
+	mw.Close()
+	w = s.send("POST", "/api/jobs", key, mw.FormDataContentType(), &form)
+	expect(t, w, http.StatusRequestEntityTooLarge, "multipart payload over the limit")
+
+	// Payloads within the limit are accepted
+	w = s.do("POST", "/api/jobs", key, `{"payload": "`+strings.Repeat("a", maxPayload)+`"}`)
+	expect(t, w, http.StatusCreated, "payload at the limit")
+}
+
+func TestWorkerRequestBodyIsLimited(t *testing.T) {
+	s := newTestServer(t, newTestDB(t), nil)
+	key := s.key(t, auth.RoleOperator, "")
+
+	body := &endless{}
+	w := s.send("POST", "/api/worker/logs", key, "application/json",
+		io.MultiReader(strings.NewReader(`{"worker_id": "w1", "lines": [{"message": "`), body))
+	expect(t, w, http.StatusRequestEntityTooLarge, "endless log batch")
+	if body.read > maxWorkerRequestBytes+64<<10 {
+		t.Errorf("read %d bytes of an endless log batch", body.read)
+	}
+}
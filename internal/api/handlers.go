@@ -1,14 +1,27 @@
 package api
 
 import (
+	"database/sql"
+	"distributed-task-queue/internal/alerting"
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/config"
 	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/metrics"
 	"distributed-task-queue/internal/models"
 	"distributed-task-queue/internal/ratelimit"
+	"distributed-task-queue/internal/tracing"
 	"distributed-task-queue/internal/websocket"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	ws "github.com/gorilla/websocket"
@@ -17,21 +30,77 @@ import (
 // Server holds all HTTP handlers and dependencies
 type Server struct {
 	db          *database.DB
-	rateLimiter *ratelimit.RateLimiter
+	rateLimiter ratelimit.Limiter
 	wsManager   *websocket.Manager
 	upgrader    ws.Upgrader
+	draining    atomic.Bool
+	pool        PoolController
+	alerts      *alerting.Engine
+	staticDir   string
+	authEnabled bool
+	keyUsage    keyUsage
+	schemas     schemaCache
+	metrics     *metrics.Registry // Gauges of this server's state
+	startedAt   time.Time
+
+	clientSubjects map[string]config.ClientIdentity
+
+	limitsMu sync.RWMutex
+	limits   config.Limits
 }
 
 // NewServer creates a new API server
-func NewServer(db *database.DB, wsManager *websocket.Manager) *Server {
-	return &Server{
+func NewServer(db *database.DB, wsManager *websocket.Manager, cfg *config.Config) *Server {
+	var limiter ratelimit.Limiter = ratelimit.New()
+	if cfg.Server.RateLimitStore == config.StoreDatabase {
+		limiter = ratelimit.NewDBLimiter(db)
+	}
+
+	s := &Server{
 		db:          db,
-		rateLimiter: ratelimit.New(10), // 10 jobs per minute
+		rateLimiter: limiter,
 		wsManager:   wsManager,
 		upgrader: ws.Upgrader{
-			CheckOrigin: func(r *http.Request) bool { return true },
+			CheckOrigin: checkOrigin(cfg.Server.AllowedOrigins),
 		},
+		staticDir:      cfg.Server.StaticDir,
+		authEnabled:    cfg.Auth.Enabled,
+		keyUsage:       keyUsage{touched: make(map[string]time.Time)},
+		clientSubjects: cfg.Server.TLS.ClientSubjects,
+		startedAt:      time.Now(),
 	}
+	s.SetLimits(cfg.Limits)
+	s.registerMetrics()
+	return s
+}
+
+// SetLimits replaces the submission limits and default tenant limits at
+// runtime
+func (s *Server) SetLimits(limits config.Limits) {
+	s.limitsMu.Lock()
+	s.limits = limits
+	s.limitsMu.Unlock()
+
+	s.db.SetTenantDefaults(limits.Tenant())
+}
+
+// Limits returns the current submission limits
+func (s *Server) Limits() config.Limits {
+	s.limitsMu.RLock()
+	defer s.limitsMu.RUnlock()
+	return s.limits
+}
+
+// SetDraining stops the server from accepting new jobs and leasing jobs to
+// remote workers while it shuts down. Status and acknowledgement endpoints
+// keep working so in-flight jobs can finish.
+func (s *Server) SetDraining(draining bool) {
+	s.draining.Store(draining)
+}
+
+// Draining reports whether the server is shutting down
+func (s *Server) Draining() bool {
+	return s.draining.Load()
 }
 
 // SubmitJob handles job submission
@@ -41,64 +110,143 @@ func (s *Server) SubmitJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req models.JobSubmitRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if s.Draining() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	start := time.Now()
+
+	// The body is read with a limit, so that no caller can make the server
+	// buffer more than the largest payload it may submit
+	p := principal(r)
+	maxPayload, err := s.payloadLimit(p)
+	if err != nil {
+		slog.Error("Failed to load tenant limits", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSubmissionBytes(maxPayload))
 
-	if req.TenantID == "" || req.Payload == "" {
+	req, payload, contentType, err := readSubmission(r, maxPayload)
+	if errors.Is(err, errBodyTooLarge) {
+		http.Error(w, fmt.Sprintf("Payload too large (max %d bytes)", maxPayload), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Tenant keys always submit for their own tenant
+	if p.TenantID != "" {
+		if req.TenantID != "" && req.TenantID != p.TenantID {
+			http.Error(w, "API key is not valid for this tenant", http.StatusForbidden)
+			return
+		}
+		req.TenantID = p.TenantID
+	}
+
+	if req.TenantID == "" || len(payload) == 0 {
 		http.Error(w, "tenant_id and payload are required", http.StatusBadRequest)
 		return
 	}
+	recordChange(r, "job.submit", "tenant:"+req.TenantID, nil, nil)
 
-	// Rate limiting check
-	if !s.rateLimiter.Allow(req.TenantID) {
-		log.Printf("[RATE_LIMIT] Tenant %s exceeded rate limit", req.TenantID)
-		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+	limits := s.Limits()
+	tenant, err := s.db.TenantLimits(req.TenantID)
+	if err != nil {
+		slog.Error("Failed to load tenant limits", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(payload) > tenant.MaxPayloadBytes {
+		slog.Warn("Tenant exceeded payload size limit", "tenant_id", req.TenantID, "bytes", len(payload))
+		http.Error(w, fmt.Sprintf("Payload too large (max %d bytes)", tenant.MaxPayloadBytes), http.StatusRequestEntityTooLarge)
 		return
 	}
 
-	// Check concurrent jobs quota
-	runningCount, err := s.db.GetRunningJobsCount(req.TenantID)
+	jobType := req.Type
+	if jobType == "" {
+		jobType = models.DefaultType
+	}
+
+	// Reject payloads that do not match their type's schema now rather than
+	// after the job has failed its retries
+	schemaVersion, payloadErrs, err := s.validatePayload(jobType, req.SchemaVersion, payload)
+	if errors.Is(err, errUnknownSchemaVersion) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		log.Printf("[ERROR] Failed to check concurrent jobs: %v", err)
+		slog.Error("Failed to validate payload", "type", jobType, "err", err)
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
+	if len(payloadErrs) > 0 {
+		writePayloadErrors(w, jobType, schemaVersion, payloadErrs)
+		return
+	}
 
-	if runningCount >= 5 {
-		log.Printf("[QUOTA] Tenant %s exceeded concurrent job limit", req.TenantID)
-		http.Error(w, "Concurrent job limit exceeded (max 5)", http.StatusTooManyRequests)
+	// Repeated submissions return the job already submitted. Neither they
+	// nor submissions over the queue quota take a rate limit token.
+	if req.IdempotencyKey != "" && s.writeSubmittedJob(w, r, req) {
 		return
 	}
 
-	// Check idempotency
-	if req.IdempotencyKey != "" {
-		existingJob, err := s.db.GetJobByIdempotencyKey(req.IdempotencyKey)
-		if err == nil {
-			// Job already exists
-			log.Printf("[IDEMPOTENCY] Job with key %s already exists: %s", req.IdempotencyKey, existingJob.ID)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(existingJob)
-			return
-		}
+	// Check queued jobs quota. Concurrency is enforced when leasing, so a
+	// tenant may queue jobs while at its running cap.
+	queuedCount, err := s.db.GetQueuedJobsCount(req.TenantID)
+	if err != nil {
+		slog.Error("Failed to check queued jobs", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if queuedCount >= tenant.MaxQueued {
+		slog.Warn("Tenant exceeded queued job limit", "tenant_id", req.TenantID, "queued", queuedCount)
+		http.Error(w, fmt.Sprintf("Queued job limit exceeded (max %d)", tenant.MaxQueued), http.StatusTooManyRequests)
+		return
+	}
+
+	// Rate limiting check
+	rate, err := s.rateLimiter.Allow(req.TenantID, tenant.RatePerMinute, tenant.Burst)
+	if err != nil {
+		slog.Error("Failed to check rate limit", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	setRateLimitHeaders(w, rate)
+	if !rate.Allowed {
+		slog.Warn("Tenant exceeded rate limit", "tenant_id", req.TenantID)
+		rateLimited.Inc(req.TenantID)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rate.RetryAfter.Seconds()))))
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		return
 	}
 
 	// Create new job
 	maxRetries := req.MaxRetries
 	if maxRetries == 0 {
-		maxRetries = 3
+		maxRetries = limits.DefaultMaxRetries
+	}
+
+	queue := req.Queue
+	if queue == "" {
+		queue = models.DefaultQueue
 	}
 
-	traceID := fmt.Sprintf("trace-%d", time.Now().UnixNano())
+	// The submission continues the caller's trace, if it sent one, and
+	// spans for the job's later stages are its children
+	parent, _ := tracing.ParseTraceparent(r.Header.Get("traceparent"))
+	span := tracing.Start("job.submit", parent, start)
+	traceID := span.TraceID
 	jobID := fmt.Sprintf("job-%d", time.Now().UnixNano())
 	now := time.Now()
 
 	job := &models.Job{
 		ID:             jobID,
 		TenantID:       req.TenantID,
-		Payload:        req.Payload,
 		Status:         models.StatusPending,
 		IdempotencyKey: req.IdempotencyKey,
 		RetryCount:     0,
@@ -106,15 +254,42 @@ func (s *Server) SubmitJob(w http.ResponseWriter, r *http.Request) {
 		CreatedAt:      now,
 		UpdatedAt:      now,
 		TraceID:        traceID,
+		Traceparent:    span.Context().Traceparent(),
+		Queue:          queue,
+		Type:           jobType,
+		SchemaVersion:  schemaVersion,
 	}
-
-	if err := s.db.InsertJob(job); err != nil {
-		log.Printf("[ERROR] TraceID=%s Failed to insert job: %v", traceID, err)
+	job.SetPayload(payload, contentType)
+	span.SetAttribute("job.id", jobID)
+	span.SetAttribute("job.queue", queue)
+	span.SetAttribute("job.type", jobType)
+	span.SetAttribute("tenant.id", req.TenantID)
+
+	err = s.db.InsertJob(job)
+	span.End(err)
+	if errors.Is(err, database.ErrDuplicateJob) {
+		// A concurrent submission with the same key was inserted first
+		if !s.writeSubmittedJob(w, r, req) {
+			http.Error(w, "Failed to create job", http.StatusInternalServerError)
+		}
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to insert job", "trace_id", traceID, "err", err)
 		http.Error(w, "Failed to create job", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("[SUBMIT] TraceID=%s JobID=%s TenantID=%s Status=pending", traceID, jobID, req.TenantID)
+	slog.Info("Job submitted", "job_id", jobID, "tenant_id", req.TenantID, "trace_id", traceID,
+		"queue", queue, "type", jobType)
+	// The payload is left out of the audit log
+	recordChange(r, "job.submit", "job:"+jobID, nil, map[string]interface{}{
+		"tenant_id":       job.TenantID,
+		"queue":           job.Queue,
+		"type":            job.Type,
+		"max_retries":     job.MaxRetries,
+		"idempotency_key": job.IdempotencyKey,
+	})
 
 	s.wsManager.Broadcast()
 
@@ -123,6 +298,137 @@ func (s *Server) SubmitJob(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(job)
 }
 
+// writeSubmittedJob writes the job the tenant already submitted with the
+// request's idempotency key and reports whether there was one. Keys are
+// scoped to the tenant, which the caller has been checked to act for.
+func (s *Server) writeSubmittedJob(w http.ResponseWriter, r *http.Request, req models.JobSubmitRequest) bool {
+	job, err := s.db.GetJobByIdempotencyKey(req.TenantID, req.IdempotencyKey)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		slog.Error("Failed to look up idempotency key", "tenant_id", req.TenantID, "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return true
+	}
+	if !principal(r).CanAccessTenant(job.TenantID) {
+		http.Error(w, "API key is not valid for this tenant", http.StatusForbidden)
+		return true
+	}
+
+	slog.Info("Job already submitted", "idempotency_key", req.IdempotencyKey, "job_id", job.ID)
+	recordChange(r, "job.submit", "job:"+job.ID, nil, nil)
+	if err := s.db.DecryptJob(job); err != nil {
+		slog.Error("Failed to decrypt job", "job_id", job.ID, "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+	return true
+}
+
+// submissionEnvelopeBytes is the room left in a submission's body for
+// everything but the payload
+const submissionEnvelopeBytes = 64 << 10
+
+// errBodyTooLarge is returned for request bodies past their size limit
+var errBodyTooLarge = errors.New("Request body too large")
+
+// payloadLimit returns the largest payload p may submit: its tenant's limit
+// for tenant keys, and the largest limit of any tenant for the others
+func (s *Server) payloadLimit(p *auth.Principal) (int, error) {
+	if p.TenantID != "" {
+		limits, err := s.db.TenantLimits(p.TenantID)
+		return limits.MaxPayloadBytes, err
+	}
+	return s.db.MaxPayloadBytes()
+}
+
+// maxSubmissionBytes returns the body size limit of a submission whose
+// payload may be maxPayload bytes. Payloads in JSON submissions are escaped
+// or base64-encoded, which takes up to about twice the space.
+func maxSubmissionBytes(maxPayload int) int64 {
+	return 2*int64(maxPayload) + submissionEnvelopeBytes
+}
+
+// readSubmission decodes a job submission and returns it with the data and
+// content type of its payload. The body is the submission's JSON, or a
+// multipart form with the JSON in a "job" part and the payload in a "payload"
+// part. The payload part's content type is the payload's, and a part without
+// one is text. It returns errBodyTooLarge for a payload part larger than
+// maxPayload and for a body past the reader's limit.
+func readSubmission(r *http.Request, maxPayload int) (models.JobSubmitRequest, []byte, string, error) {
+	var req models.JobSubmitRequest
+	invalid := errors.New("Invalid request body")
+	fail := func(err error) (models.JobSubmitRequest, []byte, string, error) {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return req, nil, "", errBodyTooLarge
+		}
+		return req, nil, "", invalid
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return fail(err)
+		}
+		payload, contentType, err := req.PayloadData()
+		return req, payload, contentType, err
+	}
+
+	parts, err := r.MultipartReader()
+	if err != nil {
+		return fail(err)
+	}
+	var payload []byte
+	var contentType string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		switch part.FormName() {
+		case "job":
+			err = json.NewDecoder(part).Decode(&req)
+		case "payload":
+			payload, err = io.ReadAll(io.LimitReader(part, int64(maxPayload)+1))
+			if len(payload) > maxPayload {
+				return req, nil, "", errBodyTooLarge
+			}
+			contentType = part.Header.Get("Content-Type")
+			if contentType == "" && part.FileName() != "" {
+				contentType = models.ContentTypeBinary
+			}
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	if len(req.Payload) > 0 || req.PayloadEncoding != "" || req.ContentType != "" {
+		return req, nil, "", errors.New("the payload of a multipart submission belongs in the payload part")
+	}
+	if models.IsJSONContentType(contentType) && !json.Valid(payload) {
+		return req, nil, "", errors.New("payload is not valid JSON")
+	}
+	return req, payload, contentType, nil
+}
+
+// setRateLimitHeaders reports a tenant's rate limit state. Reset is the Unix
+// time at which the tenant's burst is fully available again.
+func setRateLimitHeaders(w http.ResponseWriter, rate ratelimit.Result) {
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(rate.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(rate.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.FormatInt(int64(math.Ceil(float64(rate.Reset.UnixNano())/1e9)), 10))
+}
+
 // GetJobStatus returns job status
 func (s *Server) GetJobStatus(w http.ResponseWriter, r *http.Request) {
 	jobID := r.URL.Query().Get("id")
@@ -132,10 +438,15 @@ func (s *Server) GetJobStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	job, err := s.db.GetJobByID(jobID)
-	if err != nil {
+	if err != nil || !principal(r).CanAccessTenant(job.TenantID) {
 		http.Error(w, "Job not found", http.StatusNotFound)
 		return
 	}
+	if err := s.db.DecryptJob(job); err != nil {
+		slog.Error("Failed to decrypt job", "job_id", job.ID, "err", err)
+		http.Error(w, "Failed to decrypt job", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(job)
@@ -146,25 +457,64 @@ func (s *Server) ListJobs(w http.ResponseWriter, r *http.Request) {
 	status := r.URL.Query().Get("status")
 	tenantID := r.URL.Query().Get("tenant_id")
 
+	// Tenant keys only see their own jobs
+	if p := principal(r); p.TenantID != "" {
+		if tenantID != "" && tenantID != p.TenantID {
+			http.Error(w, "API key is not valid for this tenant", http.StatusForbidden)
+			return
+		}
+		tenantID = p.TenantID
+	}
+
 	jobs, err := s.db.ListJobs(status, tenantID, 100)
 	if err != nil {
-		log.Printf("[ERROR] Failed to query jobs: %v", err)
+		slog.Error("Failed to query jobs", "err", err)
 		http.Error(w, "Failed to fetch jobs", http.StatusInternalServerError)
 		return
 	}
+	s.decryptJobs(jobs)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(jobs)
 }
 
-// GetMetrics returns system metrics
+// decryptJobs decrypts jobs the caller may read. Jobs that cannot be
+// decrypted are returned without their payload and error message.
+func (s *Server) decryptJobs(jobs []models.Job) {
+	for i := range jobs {
+		if err := s.db.DecryptJob(&jobs[i]); err != nil {
+			slog.Error("Failed to decrypt job", "job_id", jobs[i].ID, "err", err)
+		}
+	}
+}
+
+// GetMetrics returns system metrics, or a tenant key's own job counts
 func (s *Server) GetMetrics(w http.ResponseWriter, r *http.Request) {
-	metrics, err := s.db.GetMetrics()
+	p := principal(r)
+	metrics, err := s.db.GetMetrics(p.TenantID)
+	if err != nil {
+		slog.Error("Failed to get metrics", "err", err)
+		http.Error(w, "Failed to fetch metrics", http.StatusInternalServerError)
+		return
+	}
+
+	if s.pool != nil && p.Can(auth.PermReadWorkers) {
+		stats := s.pool.Stats()
+		metrics.Pool = &stats
+	}
+
+	tenants, err := s.db.GetTenantMetrics()
 	if err != nil {
-		log.Printf("[ERROR] Failed to get metrics: %v", err)
+		slog.Error("Failed to get tenant metrics", "err", err)
 		http.Error(w, "Failed to fetch metrics", http.StatusInternalServerError)
 		return
 	}
+	// Tenant keys only see their own scheduling statistics
+	for _, t := range tenants {
+		if p.CanAccessTenant(t.TenantID) {
+			metrics.Tenants = append(metrics.Tenants, t)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(metrics)
@@ -174,30 +524,71 @@ func (s *Server) GetMetrics(w http.ResponseWriter, r *http.Request) {
 func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("[ERROR] WebSocket upgrade failed: %v", err)
+		slog.Error("WebSocket upgrade failed", "err", err)
 		return
 	}
 
-	s.wsManager.AddClient(conn)
+	p := principal(r)
+	s.wsManager.AddClient(conn, websocket.Scope{
+		TenantID: p.TenantID,
+		Workers:  p.Can(auth.PermReadWorkers),
+	})
 }
 
-// SetupRoutes sets up all HTTP routes
+// SetupRoutes sets up all HTTP routes. API routes require a permission, and an
+// API key granting it when authentication is enabled; static files are
+// public.
 func (s *Server) SetupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/jobs", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == http.MethodPost {
-			s.SubmitJob(w, r)
+			s.require(auth.PermSubmitJobs, s.SubmitJob)(w, r)
 		} else if r.Method == http.MethodGet {
-			s.ListJobs(w, r)
+			s.require(auth.PermReadJobs, s.ListJobs)(w, r)
 		} else {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 	})
 
-	mux.HandleFunc("/api/jobs/status", s.GetJobStatus)
-	mux.HandleFunc("/api/metrics", s.GetMetrics)
-	mux.HandleFunc("/ws", s.HandleWebSocket)
+	mux.HandleFunc("/api/jobs/status", s.require(auth.PermReadJobs, s.GetJobStatus))
+	mux.HandleFunc("/api/jobs/", s.require(auth.PermReadJobs, s.GetJobLogs))
+	mux.HandleFunc("/api/metrics", s.require(auth.PermReadJobs, s.GetMetrics))
+	mux.HandleFunc("/api/metrics/history", s.require(auth.PermReadJobs, s.GetMetricsHistory))
+	mux.HandleFunc("/api/alerts", s.require(auth.PermReadJobs, s.ListAlerts))
+	mux.HandleFunc("/api/schemas", s.require(auth.PermReadJobs, s.ListSchemas))
+	mux.HandleFunc("/api/schemas/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			s.require(auth.PermManageSchemas, s.RegisterSchema)(w, r)
+		} else {
+			s.require(auth.PermReadJobs, s.GetSchema)(w, r)
+		}
+	})
+	mux.HandleFunc("/ws", s.require(auth.PermReadJobs, s.HandleWebSocket))
+
+	// Remote worker protocol
+	mux.HandleFunc("/api/worker/register", s.require(auth.PermRunWorkers, s.RegisterWorker))
+	mux.HandleFunc("/api/worker/lease", s.require(auth.PermRunWorkers, s.LeaseJobForWorker))
+	mux.HandleFunc("/api/worker/ack", s.require(auth.PermRunWorkers, s.AckJob))
+	mux.HandleFunc("/api/worker/nack", s.require(auth.PermRunWorkers, s.NackJob))
+	mux.HandleFunc("/api/worker/release", s.require(auth.PermRunWorkers, s.ReleaseJob))
+	mux.HandleFunc("/api/worker/heartbeat", s.require(auth.PermRunWorkers, s.WorkerHeartbeat))
+	mux.HandleFunc("/api/worker/logs", s.require(auth.PermRunWorkers, s.StoreJobLogs))
+	mux.HandleFunc("/api/worker/deregister", s.require(auth.PermRunWorkers, s.DeregisterWorker))
+	mux.HandleFunc("/api/workers", s.require(auth.PermReadWorkers, s.ListWorkers))
+	mux.HandleFunc("/api/admin/pool", s.require(auth.PermManagePool, s.HandlePool))
+	mux.HandleFunc("/api/admin/alert-rules", s.require(auth.PermManageAlerts, s.ListAlertRules))
+	mux.HandleFunc("/api/admin/alert-rules/", s.require(auth.PermManageAlerts, s.HandleAlertRule))
+	mux.HandleFunc("/api/admin/tenants", s.require(auth.PermManageTenants, s.ListTenants))
+	mux.HandleFunc("/api/admin/tenants/", s.require(auth.PermManageTenants, s.HandleTenant))
+	mux.HandleFunc("/api/admin/keys", s.require(auth.PermManageKeys, s.HandleKeys))
+	mux.HandleFunc("/api/admin/keys/", s.require(auth.PermManageKeys, s.HandleKey))
+	mux.HandleFunc("/api/audit", s.require(auth.PermReadAudit, s.ListAudit))
+	mux.HandleFunc("/metrics", s.require(auth.PermReadMetrics, s.ServeMetrics))
+
+	// Probes for supervisors and load balancers, which carry no API key
+	mux.HandleFunc("/healthz", s.Healthz)
+	mux.HandleFunc("/readyz", s.Readyz)
 
 	// Serve static files
-	mux.Handle("/", http.FileServer(http.Dir("./static")))
+	mux.Handle("/", http.FileServer(http.Dir(s.staticDir)))
 }
 
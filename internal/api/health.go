@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// healthCheckTimeout bounds the database ping of a readiness check
+const healthCheckTimeout = 2 * time.Second
+
+// check is the outcome of one readiness check with its diagnostics
+type check map[string]interface{}
+
+func (c check) ok() bool {
+	ok, _ := c["ok"].(bool)
+	return ok
+}
+
+// Healthz reports that the process is alive and serving requests. It does
+// not depend on the database, so a supervisor only restarts a process that
+// has stopped responding.
+func (s *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeHealth(w, http.StatusOK, map[string]interface{}{
+		"status":         "ok",
+		"started_at":     s.startedAt,
+		"uptime_seconds": int64(time.Since(s.startedAt).Seconds()),
+	})
+}
+
+// Readyz reports whether the server should receive traffic: the database is
+// reachable and at the schema version of this build, workers are running
+// and the server is not draining. It responds 503 with the failed checks
+// otherwise.
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	checks := map[string]check{
+		"database": s.checkDatabase(ctx),
+		"schema":   s.checkSchema(),
+		"workers":  s.checkWorkers(),
+		"draining": {"ok": !s.Draining()},
+	}
+
+	status, code := "ready", http.StatusOK
+	for _, c := range checks {
+		if !c.ok() {
+			status, code = "not ready", http.StatusServiceUnavailable
+		}
+	}
+
+	writeHealth(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// checkDatabase pings the database
+func (s *Server) checkDatabase(ctx context.Context) check {
+	start := time.Now()
+	if err := s.db.PingContext(ctx); err != nil {
+		return check{"ok": false, "error": err.Error()}
+	}
+	return check{"ok": true, "latency_ms": time.Since(start).Milliseconds()}
+}
+
+// checkSchema compares the recorded schema version with the one this build
+// migrates to
+func (s *Server) checkSchema() check {
+	version, err := s.db.CurrentSchemaVersion()
+	if err != nil {
+		return check{"ok": false, "error": err.Error()}
+	}
+	expected := database.SchemaVersion()
+	c := check{"ok": version == expected, "version": version, "expected": expected}
+	if version != expected {
+		c["error"] = "schema version does not match this build"
+	}
+	return c
+}
+
+// checkWorkers requires a live worker, in-process or remote, unless the
+// in-process pool may scale down to none and jobs are left to remote
+// workers
+func (s *Server) checkWorkers() check {
+	workers, err := s.db.ListWorkers(models.WorkerAlive)
+	if err != nil {
+		return check{"ok": false, "error": err.Error()}
+	}
+
+	c := check{"ok": len(workers) > 0, "alive": len(workers)}
+	if s.pool != nil {
+		stats := s.pool.Stats()
+		c["pool_size"] = stats.Size
+		if stats.MinSize == 0 {
+			c["ok"] = true
+		}
+	}
+	if !c.ok() {
+		c["error"] = "no workers alive"
+	}
+	return c
+}
+
+func writeHealth(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
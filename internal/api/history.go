@@ -0,0 +1,99 @@
+package api
+
+import (
+	"distributed-task-queue/internal/database"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultHistoryWindow = time.Hour
+	defaultHistoryPoints = 60   // Points aimed for when no step is given
+	maxHistoryPoints     = 2000 // Points per series at most
+)
+
+// historySteps are the steps picked when the request has none
+var historySteps = []time.Duration{
+	time.Minute, 5 * time.Minute, 15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour,
+}
+
+// GetMetricsHistory returns job statistics over time from
+// GET /api/metrics/history. from and to are RFC 3339 and default to the last
+// hour; step is a duration such as 5m, 1h or 1d and defaults to about 60
+// points. Steps finer than the resolution still kept for from are raised to
+// it.
+func (s *Server) GetMetricsHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	now := time.Now()
+	to, from := now, now.Add(-defaultHistoryWindow)
+	var step time.Duration
+
+	var err error
+	if v := q.Get("to"); v != "" {
+		to, err = time.Parse(time.RFC3339, v)
+		from = to.Add(-defaultHistoryWindow)
+	}
+	if v := q.Get("from"); v != "" && err == nil {
+		from, err = time.Parse(time.RFC3339, v)
+	}
+	if v := q.Get("step"); v != "" && err == nil {
+		step, err = parseStep(v)
+	}
+	if err != nil || !from.Before(to) || step < 0 {
+		http.Error(w, "Invalid query parameter", http.StatusBadRequest)
+		return
+	}
+
+	if step == 0 {
+		step = historySteps[len(historySteps)-1]
+		for _, candidate := range historySteps {
+			if to.Sub(from)/candidate <= defaultHistoryPoints {
+				step = candidate
+				break
+			}
+		}
+	}
+	step = max(step, database.HistoryResolution(from, now))
+	if to.Sub(from)/step > maxHistoryPoints {
+		http.Error(w, "Too many points, use a larger step", http.StatusBadRequest)
+		return
+	}
+
+	// Tenant keys only see their own tenant
+	tenantID := q.Get("tenant_id")
+	if p := principal(r); p.TenantID != "" {
+		if tenantID != "" && tenantID != p.TenantID {
+			http.Error(w, "API key is not valid for this tenant", http.StatusForbidden)
+			return
+		}
+		tenantID = p.TenantID
+	}
+
+	history, err := s.db.GetMetricsHistory(from, to, step, tenantID)
+	if err != nil {
+		slog.Error("Failed to query metrics history", "err", err)
+		http.Error(w, "Failed to fetch metrics history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+// parseStep parses a duration, also accepting a number of days such as 1d
+func parseStep(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		return time.Duration(n) * 24 * time.Hour, err
+	}
+	return time.ParseDuration(v)
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/models"
+	"net/http"
+	"testing"
+)
+
+func TestIdempotencyKeysAreScopedToTenants(t *testing.T) {
+	s := newTestServer(t, newTestDB(t), nil)
+	acme := s.key(t, auth.RoleSubmitter, "acme")
+	globex := s.key(t, auth.RoleSubmitter, "globex")
+	admin := s.key(t, auth.RoleAdmin, "")
+	const body = `{"payload": "secret", "idempotency_key": "order-1"}`
+
+	w := s.do("POST", "/api/jobs", acme, body)
+	expect(t, w, http.StatusCreated, "first submission")
+	var first models.Job
+	decode(t, w, &first)
+
+	// The same key from the same tenant returns the job already submitted
+	w = s.do("POST", "/api/jobs", acme, body)
+	expect(t, w, http.StatusOK, "repeated submission")
+	var again models.Job
+	decode(t, w, &again)
+	if again.ID != first.ID {
+		t.Fatalf("repeated submission created %s, want %s", again.ID, first.ID)
+	}
+
+	// Another tenant with the same key gets a job of its own and never sees
+	// the first tenant's
+	w = s.do("POST", "/api/jobs", globex, body)
+	expect(t, w, http.StatusCreated, "other tenant's submission")
+	var other models.Job
+	decode(t, w, &other)
+	if other.ID == first.ID || other.TenantID != "globex" {
+		t.Fatalf("other tenant got job %s of %s", other.ID, other.TenantID)
+	}
+
+	// An admin submitting for a tenant uses that tenant's keys
+	w = s.do("POST", "/api/jobs", admin, `{"tenant_id": "acme", "payload": "secret", "idempotency_key": "order-1"}`)
+	expect(t, w, http.StatusOK, "admin submission for acme")
+	decode(t, w, &again)
+	if again.ID != first.ID {
+		t.Fatalf("admin submission for acme returned %s, want %s", again.ID, first.ID)
+	}
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLogLimit is the default and maximum number of log lines returned at once
+const maxLogLimit = 1000
+
+// logFollowInterval is how often a followed job is checked for having
+// finished when no lines arrive
+const logFollowInterval = time.Second
+
+// StoreJobLogs appends lines a remote worker captured from the handler of a
+// job it holds the lease on
+func (s *Server) StoreJobLogs(w http.ResponseWriter, r *http.Request) {
+	var req models.WorkerLogRequest
+	if !decodeWorkerRequest(w, r, &req) {
+		return
+	}
+	if _, ok := s.lookupWorker(w, r, req.WorkerID); !ok {
+		return
+	}
+
+	if !writeLeaseError(w, s.db.AppendJobLogs(req.JobID, req.WorkerID, req.Lines)) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetJobLogs returns the lines a job's handler logged, oldest first, from
+// GET /api/jobs/{id}/logs. Pages hold limit lines after the line ID after.
+// With follow=true the lines are streamed as server-sent events until the job
+// is done or in the DLQ.
+func (s *Server) GetJobLogs(w http.ResponseWriter, r *http.Request) {
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/logs")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, err := s.db.GetJobByID(id)
+	if err != nil || !principal(r).CanAccessTenant(job.TenantID) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	q := r.URL.Query()
+	var after, limit int64
+	if v := q.Get("after"); v != "" {
+		after, err = strconv.ParseInt(v, 10, 64)
+	}
+	if v := q.Get("limit"); v != "" && err == nil {
+		limit, err = strconv.ParseInt(v, 10, 64)
+	}
+	if err != nil || after < 0 || limit < 0 {
+		http.Error(w, "Invalid query parameter", http.StatusBadRequest)
+		return
+	}
+
+	if q.Get("follow") == "true" {
+		s.followJobLogs(w, r, id, after)
+		return
+	}
+
+	if limit == 0 || limit > maxLogLimit {
+		limit = maxLogLimit
+	}
+	lines, err := s.db.ListJobLogs(id, after, int(limit))
+	if err != nil {
+		slog.Error("Failed to query job logs", "job_id", id, "err", err)
+		http.Error(w, "Failed to fetch job logs", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{"lines": lines}
+	if len(lines) == int(limit) {
+		resp["next_after"] = lines[len(lines)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// followJobLogs streams a job's log lines after the line ID after as
+// server-sent "log" events, ending with an "end" event once the job is done
+// or in the DLQ. Reconnecting clients resume from Last-Event-ID.
+func (s *Server) followJobLogs(w http.ResponseWriter, r *http.Request, jobID string, after int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+			after = id
+		}
+	}
+
+	// The stream outlives the server's read timeout, which would end it
+	http.NewResponseController(w).SetReadDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(logFollowInterval)
+	defer ticker.Stop()
+
+	for {
+		// Check the job before reading lines: workers store all of an
+		// attempt's lines before reporting its outcome, so none can arrive
+		// after a finished job has been read
+		appended := s.db.JobLogsAppended()
+		job, err := s.db.GetJobByID(jobID)
+		if err != nil {
+			slog.Error("Failed to get job", "job_id", jobID, "err", err)
+			return
+		}
+
+		lines, err := s.db.ListJobLogs(jobID, after, maxLogLimit)
+		if err != nil {
+			slog.Error("Failed to query job logs", "job_id", jobID, "err", err)
+			return
+		}
+		for _, l := range lines {
+			data, _ := json.Marshal(l)
+			fmt.Fprintf(w, "id: %d\nevent: log\ndata: %s\n\n", l.ID, data)
+			after = l.ID
+		}
+		if len(lines) == maxLogLimit {
+			flusher.Flush()
+			continue
+		}
+
+		if jobFinished(job) {
+			data, _ := json.Marshal(map[string]string{"status": job.Status})
+			fmt.Fprintf(w, "event: end\ndata: %s\n\n", data)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-appended:
+		case <-ticker.C:
+			// Let the HTTP server shut down
+			if s.Draining() {
+				return
+			}
+		}
+	}
+}
+
+// jobFinished reports whether a job will not run again
+func jobFinished(job *models.Job) bool {
+	return job.Status == models.StatusDone ||
+		(job.Status == models.StatusFailed && job.RetryCount >= job.MaxRetries)
+}
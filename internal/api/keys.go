@@ -0,0 +1,121 @@
+package api
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// HandleKeys lists API keys on GET and creates one on POST. The secret key is
+// only returned on creation.
+func (s *Server) HandleKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		keys, err := s.db.ListAPIKeys()
+		if err != nil {
+			slog.Error("Failed to list API keys", "err", err)
+			http.Error(w, "Failed to fetch API keys", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	case http.MethodPost:
+		var req models.APIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		k, key, hash, err := auth.NewKey(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.db.InsertAPIKey(k, hash); err != nil {
+			slog.Error("Failed to create API key", "err", err)
+			http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("API key created", "key_id", k.ID, "by", principal(r).Name, "role", k.Role, "tenant_id", k.TenantID)
+		recordChange(r, "key.create", "key:"+k.ID, nil, k)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(models.APIKeySecret{APIKey: *k, Key: key})
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleKey revokes a key on DELETE /api/admin/keys/{id} and replaces its
+// secret on POST /api/admin/keys/{id}/rotate
+func (s *Server) HandleKey(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/admin/keys/"), "/")
+	if id == "" {
+		http.Error(w, "key id is required", http.StatusBadRequest)
+		return
+	}
+
+	// Only the key's metadata is recorded, never its hash
+	target := "key:" + id
+	before, _, _ := s.db.GetAPIKey(id)
+
+	switch {
+	case r.Method == http.MethodDelete && action == "":
+		recordChange(r, "key.revoke", target, nil, nil)
+		err := s.db.RevokeAPIKey(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("Failed to revoke key", "key_id", id, "err", err)
+			http.Error(w, "Failed to revoke key", http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("API key revoked", "key_id", id, "by", principal(r).Name)
+		after, _, _ := s.db.GetAPIKey(id)
+		recordChange(r, "key.revoke", target, before, after)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && action == "rotate":
+		recordChange(r, "key.rotate", target, nil, nil)
+		key, hash, err := auth.NewSecret(id)
+		if err != nil {
+			slog.Error("Failed to generate key", "err", err)
+			http.Error(w, "Failed to rotate key", http.StatusInternalServerError)
+			return
+		}
+		err = s.db.RotateAPIKey(id, hash)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Key not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("Failed to rotate key", "key_id", id, "err", err)
+			http.Error(w, "Failed to rotate key", http.StatusInternalServerError)
+			return
+		}
+
+		k, _, err := s.db.GetAPIKey(id)
+		if err != nil {
+			slog.Error("Failed to get key", "key_id", id, "err", err)
+			http.Error(w, "Failed to rotate key", http.StatusInternalServerError)
+			return
+		}
+
+		slog.Info("API key rotated", "key_id", id, "by", principal(r).Name)
+		recordChange(r, "key.rotate", target, before, k)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.APIKeySecret{APIKey: *k, Key: key})
+	case action != "" && action != "rotate":
+		http.Error(w, "Not found", http.StatusNotFound)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"bytes"
+	"distributed-task-queue/internal/metrics"
+	"log/slog"
+	"net/http"
+)
+
+var rateLimited = metrics.Default.Counter("taskq_rate_limited_total",
+	"Job submissions rejected by the tenant rate limit.", "tenant")
+
+// registerMetrics adds the gauges read from the server's state on each
+// scrape. They go in the server's own registry, so that servers sharing a
+// process do not register them twice.
+func (s *Server) registerMetrics() {
+	s.metrics = metrics.NewRegistry()
+	s.metrics.GaugeFunc("taskq_jobs", "Jobs that are not done, by status.",
+		[]string{"status", "queue", "tenant"}, s.db.GetQueueDepth)
+
+	s.metrics.GaugeFunc("taskq_websocket_clients", "Connected WebSocket clients.", nil,
+		func() ([]metrics.Sample, error) {
+			return []metrics.Sample{{Value: float64(s.wsManager.ClientCount())}}, nil
+		})
+
+	s.metrics.GaugeFunc("taskq_pool_workers", "Workers in the in-process pool.", nil,
+		func() ([]metrics.Sample, error) {
+			if s.pool == nil {
+				return nil, nil
+			}
+			return []metrics.Sample{{Value: float64(s.pool.Stats().Size)}}, nil
+		})
+}
+
+// ServeMetrics writes the process's metrics and the server's gauges in the
+// Prometheus text format
+func (s *Server) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var buf bytes.Buffer
+	err := metrics.Default.Write(&buf)
+	if err == nil {
+		err = s.metrics.Write(&buf)
+	}
+	if err != nil {
+		slog.Error("Failed to collect metrics", "err", err)
+		http.Error(w, "Failed to collect metrics", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/models"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricsWithTwoServers(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestServer(t, db, nil)
+	newTestServer(t, db, nil)
+	admin := s.key(t, auth.RoleAdmin, "")
+
+	w := s.do("GET", "/metrics", admin, "")
+	expect(t, w, http.StatusOK, "metrics")
+
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		name := strings.Fields(line)[2]
+		if seen[name] {
+			t.Errorf("%s is written twice", name)
+		}
+		seen[name] = true
+	}
+	for _, name := range []string{"taskq_jobs", "taskq_websocket_clients", "taskq_pool_workers"} {
+		if !seen[name] {
+			t.Errorf("%s is missing", name)
+		}
+	}
+}
+
+func TestTenantKeysOnlyCountTheirOwnJobs(t *testing.T) {
+	s := newTestServer(t, newTestDB(t), nil)
+	acme := s.key(t, auth.RoleSubmitter, "acme")
+	globex := s.key(t, auth.RoleSubmitter, "globex")
+	admin := s.key(t, auth.RoleAdmin, "")
+
+	for i, key := range []string{acme, globex, globex} {
+		w := s.do("POST", "/api/jobs", key, `{"payload": "x"}`)
+		expect(t, w, http.StatusCreated, fmt.Sprintf("submission %d", i))
+	}
+
+	for _, tt := range []struct {
+		name, key string
+		want      int64
+	}{
+		{"acme", acme, 1},
+		{"globex", globex, 2},
+		{"admin", admin, 3},
+	} {
+		w := s.do("GET", "/api/metrics", tt.key, "")
+		expect(t, w, http.StatusOK, "metrics for "+tt.name)
+		var metrics models.Metrics
+		decode(t, w, &metrics)
+		if metrics.TotalJobs != tt.want || metrics.PendingJobs != tt.want {
+			t.Errorf("%s sees %d jobs, %d pending, want %d", tt.name, metrics.TotalJobs, metrics.PendingJobs, tt.want)
+		}
+	}
+}
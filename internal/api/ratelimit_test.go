@@ -0,0 +1,86 @@
+package api
+
+import (
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/config"
+	"distributed-task-queue/internal/models"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRateLimitSharedByServers(t *testing.T) {
+	// Two servers with their own connections to one database, as two
+	// processes would have
+	path := filepath.Join(t.TempDir(), "tasks.db")
+	configure := func(cfg *config.Config) {
+		cfg.Server.RateLimitStore = config.StoreDatabase
+		cfg.Limits.RatePerMinute = 1
+		cfg.Limits.Burst = 5
+	}
+	servers := []*testServer{
+		newTestServer(t, openTestDB(t, path), configure),
+		newTestServer(t, openTestDB(t, path), configure),
+	}
+	keys := []string{
+		servers[0].key(t, auth.RoleSubmitter, "acme"),
+		servers[1].key(t, auth.RoleSubmitter, "acme"),
+	}
+
+	const perServer = 10
+	var mu sync.Mutex
+	codes := make(map[int]int)
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		for j := 0; j < perServer; j++ {
+			wg.Add(1)
+			go func(s *testServer, key string) {
+				defer wg.Done()
+				w := s.do("POST", "/api/jobs", key, `{"payload": "x"}`)
+				mu.Lock()
+				codes[w.Code]++
+				mu.Unlock()
+			}(s, keys[i])
+		}
+	}
+	wg.Wait()
+
+	// The burst is shared, so the two servers together accept 5
+	if codes[http.StatusCreated] != 5 || codes[http.StatusTooManyRequests] != 2*perServer-5 {
+		t.Fatalf("got %v, want 5 accepted and %d rate limited", codes, 2*perServer-5)
+	}
+}
+
+func TestRateLimitOnlyCountsNewJobs(t *testing.T) {
+	s := newTestServer(t, newTestDB(t), func(cfg *config.Config) {
+		cfg.Limits.RatePerMinute = 1
+		cfg.Limits.Burst = 2
+		cfg.Limits.MaxQueued = 1
+	})
+	key := s.key(t, auth.RoleSubmitter, "acme")
+	const body = `{"payload": "x", "idempotency_key": "order-1"}`
+
+	expect(t, s.do("POST", "/api/jobs", key, body), http.StatusCreated, "first submission")
+	for i := 0; i < 3; i++ {
+		expect(t, s.do("POST", "/api/jobs", key, body), http.StatusOK, "repeated submission")
+		w := s.do("POST", "/api/jobs", key, `{"payload": "x"}`)
+		expect(t, w, http.StatusTooManyRequests, "submission over the queue quota")
+		if !strings.HasPrefix(w.Body.String(), "Queued job limit exceeded") {
+			t.Fatalf("submission over the queue quota got %q", w.Body)
+		}
+	}
+
+	// Neither repeats nor rejected submissions took the second token
+	tenant := &models.Tenant{ID: "acme", TenantLimits: models.TenantLimits{MaxQueued: 10}}
+	if err := s.db.UpsertTenant(tenant); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, s.do("POST", "/api/jobs", key, `{"payload": "x"}`), http.StatusCreated, "second new job")
+	w := s.do("POST", "/api/jobs", key, `{"payload": "x"}`)
+	expect(t, w, http.StatusTooManyRequests, "third new job")
+	if !strings.HasPrefix(w.Body.String(), "Rate limit exceeded") {
+		t.Fatalf("third new job got %q", w.Body)
+	}
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+)
+
+// stubPool is a worker pool that accepts every resize
+type stubPool struct{}
+
+func (stubPool) Stats() models.PoolStats                  { return models.PoolStats{} }
+func (stubPool) Apply(req models.PoolResizeRequest) error { return nil }
+
+// route is a request to one endpoint and the status it gets from a caller
+// granted perm. Routes without a permission are public. {key} in the path is
+// replaced by the ID of a key the caller did not use.
+type route struct {
+	method, path, body string
+	perm               auth.Permission
+	ok                 int
+}
+
+var routes = []route{
+	{"POST", "/api/jobs", `{"tenant_id": "acme", "payload": "x"}`, auth.PermSubmitJobs, http.StatusCreated},
+	{"GET", "/api/jobs", "", auth.PermReadJobs, http.StatusOK},
+	{"GET", "/api/jobs/status?id=job-1", "", auth.PermReadJobs, http.StatusOK},
+	{"GET", "/api/jobs/job-1/logs", "", auth.PermReadJobs, http.StatusOK},
+	{"GET", "/api/metrics", "", auth.PermReadJobs, http.StatusOK},
+	{"GET", "/api/metrics/history", "", auth.PermReadJobs, http.StatusOK},
+	{"GET", "/api/alerts", "", auth.PermReadJobs, http.StatusOK},
+	{"GET", "/api/schemas", "", auth.PermReadJobs, http.StatusOK},
+	{"GET", "/api/schemas/greet", "", auth.PermReadJobs, http.StatusOK},
+	{"POST", "/api/schemas/greet", `{"type": "object", "required": ["name"]}`, auth.PermManageSchemas, http.StatusCreated},
+	{"GET", "/ws", "", auth.PermReadJobs, http.StatusSwitchingProtocols},
+
+	{"POST", "/api/worker/register", `{"id": "worker-2"}`, auth.PermRunWorkers, http.StatusCreated},
+	{"POST", "/api/worker/lease", `{"worker_id": "worker-1"}`, auth.PermRunWorkers, http.StatusNoContent},
+	{"POST", "/api/worker/ack", `{"worker_id": "worker-1", "job_id": "job-1"}`, auth.PermRunWorkers, http.StatusOK},
+	{"POST", "/api/worker/nack", `{"worker_id": "worker-1", "job_id": "job-1"}`, auth.PermRunWorkers, http.StatusOK},
+	{"POST", "/api/worker/release", `{"worker_id": "worker-1", "job_id": "job-1"}`, auth.PermRunWorkers, http.StatusNoContent},
+	{"POST", "/api/worker/heartbeat", `{"worker_id": "worker-1", "job_id": "job-1"}`, auth.PermRunWorkers, http.StatusNoContent},
+	{"POST", "/api/worker/logs", `{"worker_id": "worker-1", "job_id": "job-1", "lines": [{"message": "hi"}]}`, auth.PermRunWorkers, http.StatusNoContent},
+	{"POST", "/api/worker/deregister", `{"worker_id": "worker-1"}`, auth.PermRunWorkers, http.StatusNoContent},
+	{"GET", "/api/workers", "", auth.PermReadWorkers, http.StatusOK},
+
+	{"GET", "/api/admin/pool", "", auth.PermManagePool, http.StatusOK},
+	{"POST", "/api/admin/pool", `{"size": 2}`, auth.PermManagePool, http.StatusOK},
+	{"GET", "/api/admin/alert-rules", "", auth.PermManageAlerts, http.StatusOK},
+	{"GET", "/api/admin/alert-rules/backlog", "", auth.PermManageAlerts, http.StatusOK},
+	{"PUT", "/api/admin/alert-rules/dlq", `{"metric": "dlq", "op": ">", "threshold": 0}`, auth.PermManageAlerts, http.StatusOK},
+	{"DELETE", "/api/admin/alert-rules/backlog", "", auth.PermManageAlerts, http.StatusNoContent},
+	{"GET", "/api/admin/tenants", "", auth.PermManageTenants, http.StatusOK},
+	{"GET", "/api/admin/tenants/acme", "", auth.PermManageTenants, http.StatusOK},
+	{"PUT", "/api/admin/tenants/acme", `{"max_queued": 10}`, auth.PermManageTenants, http.StatusOK},
+	{"DELETE", "/api/admin/tenants/acme", "", auth.PermManageTenants, http.StatusNoContent},
+	{"GET", "/api/admin/keys", "", auth.PermManageKeys, http.StatusOK},
+	{"POST", "/api/admin/keys", `{"name": "ci", "role": "viewer"}`, auth.PermManageKeys, http.StatusCreated},
+	{"DELETE", "/api/admin/keys/{key}", "", auth.PermManageKeys, http.StatusNoContent},
+	{"POST", "/api/admin/keys/{key}/rotate", "", auth.PermManageKeys, http.StatusOK},
+	{"GET", "/api/audit", "", auth.PermReadAudit, http.StatusOK},
+	{"GET", "/metrics", "", auth.PermReadMetrics, http.StatusOK},
+
+	{"GET", "/healthz", "", "", http.StatusOK},
+	{"GET", "/readyz", "", "", http.StatusOK},
+}
+
+// newRouteServer creates a server with a key for role, unless role is empty,
+// and the state every route acts on: job-1 leased by worker-1, which the key
+// registered, a schema, an alert rule, a tenant and a second key. It returns
+// the server, the key and the ID of the second key.
+func newRouteServer(t *testing.T, role string) (*testServer, string, string) {
+	t.Helper()
+	s := newTestServer(t, newTestDB(t), nil)
+	s.SetPool(stubPool{})
+
+	key, owner := "", "nobody"
+	if role != "" {
+		key = s.key(t, role, "")
+		id, _ := auth.ParseKey(key)
+		owner = "key:" + id
+	}
+	other, _ := auth.ParseKey(s.key(t, auth.RoleViewer, ""))
+
+	now := time.Now()
+	job := &models.Job{ID: "job-1", TenantID: "acme", Status: models.StatusPending, MaxRetries: 3,
+		CreatedAt: now, UpdatedAt: now, Queue: models.DefaultQueue, Type: models.DefaultType}
+	job.SetPayload([]byte("x"), "")
+	worker := &models.WorkerInfo{ID: "worker-1", Queues: []string{}, Types: []string{},
+		RegisteredAt: now, LastHeartbeat: now, Owner: owner}
+	rule := &models.AlertRule{Name: "backlog", Metric: "backlog", Op: ">", Threshold: 100}
+	tenant := &models.Tenant{ID: "acme", TenantLimits: models.TenantLimits{MaxQueued: 100}}
+	for _, err := range []error{
+		s.db.InsertJob(job),
+		s.db.UpsertWorker(worker),
+		s.db.UpsertAlertRule(rule),
+		s.db.UpsertTenant(tenant),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.db.LeaseJob(database.LeaseFilter{WorkerID: worker.ID}, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.db.InsertJobSchema("greet", []byte(`{"type": "object"}`)); err != nil {
+		t.Fatal(err)
+	}
+	return s, key, other
+}
+
+// call sends a route's request with key through a listening server, so that
+// WebSocket upgrades complete, and returns the status
+func (s *testServer) call(t *testing.T, rt route, key, other string) int {
+	t.Helper()
+	srv := httptest.NewServer(s.mux)
+	defer srv.Close()
+
+	header := http.Header{}
+	if key != "" {
+		header.Set("Authorization", "Bearer "+key)
+	}
+	path := strings.ReplaceAll(rt.path, "{key}", other)
+
+	if rt.path == "/ws" {
+		conn, resp, err := ws.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, header)
+		if err == nil {
+			conn.Close()
+		}
+		if resp == nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	req, err := http.NewRequest(rt.method, srv.URL+path, strings.NewReader(rt.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	if rt.body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestRoutesByRole(t *testing.T) {
+	roles := []string{"", auth.RoleViewer, auth.RoleSubmitter, auth.RoleOperator, auth.RoleAdmin}
+	for _, rt := range routes {
+		for _, role := range roles {
+			name := role
+			if name == "" {
+				name = "no key"
+			}
+			t.Run(rt.method+" "+rt.path+" as "+name, func(t *testing.T) {
+				want := rt.ok
+				switch {
+				case rt.perm == "":
+				case role == "":
+					want = http.StatusUnauthorized
+				case !(&auth.Principal{Role: role}).Can(rt.perm):
+					want = http.StatusForbidden
+				}
+
+				s, key, other := newRouteServer(t, role)
+				if got := s.call(t, rt, key, other); got != want {
+					t.Errorf("got %d, want %d", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestPrincipalWithoutRequirePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("principal returned a caller for a request that was not authenticated")
+		}
+	}()
+	principal(httptest.NewRequest("GET", "/api/jobs", nil))
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/jsonschema"
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxSchemaBytes bounds the size of a registered schema
+const maxSchemaBytes = 1 << 20
+
+// errUnknownSchemaVersion is returned when a submission names a schema
+// version its job type does not have
+var errUnknownSchemaVersion = errors.New("unknown schema_version for this job type")
+
+// schemaKey identifies a version of a job type's schema
+type schemaKey struct {
+	jobType string
+	version int
+}
+
+// schemaCache holds compiled schemas. Versions never change once registered,
+// so entries never go stale.
+type schemaCache struct {
+	mu       sync.Mutex
+	compiled map[schemaKey]*jsonschema.Schema
+}
+
+func (c *schemaCache) compile(js *models.JobSchema) (*jsonschema.Schema, error) {
+	key := schemaKey{js.Type, js.Version}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if schema, ok := c.compiled[key]; ok {
+		return schema, nil
+	}
+
+	schema, err := jsonschema.Compile(js.Schema)
+	if err != nil {
+		return nil, err
+	}
+	if c.compiled == nil {
+		c.compiled = make(map[schemaKey]*jsonschema.Schema)
+	}
+	c.compiled[key] = schema
+	return schema, nil
+}
+
+// validatePayload checks a payload against a version of its job type's
+// schema, the latest if version is 0. It returns the version checked, 0 if
+// the type has no schema, and the fields that do not match.
+func (s *Server) validatePayload(jobType string, version int, payload []byte) (int, []jsonschema.FieldError, error) {
+	js, err := s.db.GetJobSchema(jobType, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		if version != 0 {
+			return 0, nil, errUnknownSchemaVersion
+		}
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	schema, err := s.schemas.compile(js)
+	if err != nil {
+		return 0, nil, err
+	}
+	return js.Version, schema.Validate(payload), nil
+}
+
+// writePayloadErrors responds 422 with the fields of a payload that do not
+// match its job type's schema
+func writePayloadErrors(w http.ResponseWriter, jobType string, version int, errs []jsonschema.FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":          fmt.Sprintf("payload does not match version %d of the schema of job type %q", version, jobType),
+		"type":           jobType,
+		"schema_version": version,
+		"errors":         errs,
+	})
+}
+
+// ListSchemas returns the latest schema of every job type that has one
+func (s *Server) ListSchemas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	schemas, err := s.db.ListJobSchemas("")
+	if err != nil {
+		slog.Error("Failed to list job schemas", "err", err)
+		http.Error(w, "Failed to fetch schemas", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schemas)
+}
+
+// GetSchema returns every version of a job type's schema from
+// GET /api/schemas/{type}, or one version from GET /api/schemas/{type}/{version}
+func (s *Server) GetSchema(w http.ResponseWriter, r *http.Request) {
+	jobType, v, hasVersion := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/schemas/"), "/")
+	if jobType == "" {
+		http.Error(w, "job type is required", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body interface{}
+	if hasVersion {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 1 {
+			http.Error(w, "Invalid schema version", http.StatusBadRequest)
+			return
+		}
+		js, err := s.db.GetJobSchema(jobType, version)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Schema not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.Error("Failed to get job schema", "type", jobType, "version", version, "err", err)
+			http.Error(w, "Failed to fetch schema", http.StatusInternalServerError)
+			return
+		}
+		body = js
+	} else {
+		schemas, err := s.db.ListJobSchemas(jobType)
+		if err != nil {
+			slog.Error("Failed to list job schemas", "type", jobType, "err", err)
+			http.Error(w, "Failed to fetch schemas", http.StatusInternalServerError)
+			return
+		}
+		if len(schemas) == 0 {
+			http.Error(w, "Schema not found", http.StatusNotFound)
+			return
+		}
+		body = schemas
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// RegisterSchema registers the JSON Schema in the request body as the next
+// version of a job type's schema on POST /api/schemas/{type}. Submissions of
+// the type are validated against the latest version unless they name
+// another. Registering the latest version again returns it with 200.
+func (s *Server) RegisterSchema(w http.ResponseWriter, r *http.Request) {
+	jobType := strings.TrimPrefix(r.URL.Path, "/api/schemas/")
+	if jobType == "" || strings.Contains(jobType, "/") {
+		http.Error(w, "job type is required", http.StatusBadRequest)
+		return
+	}
+
+	target := "schema:" + jobType
+	recordChange(r, "schema.register", target, nil, nil)
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSchemaBytes))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, err := jsonschema.Compile(data); err != nil {
+		http.Error(w, "Invalid schema: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	js, created, err := s.db.InsertJobSchema(jobType, data)
+	if err != nil {
+		slog.Error("Failed to save job schema", "type", jobType, "err", err)
+		http.Error(w, "Failed to save schema", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		slog.Info("Job schema registered", "type", jobType, "version", js.Version, "by", principal(r).Name)
+		recordChange(r, "schema.register", target, nil, js)
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(js)
+}
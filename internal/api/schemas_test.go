@@ -0,0 +1,53 @@
+package api
+
+import (
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/jsonschema"
+	"distributed-task-queue/internal/models"
+	"net/http"
+	"testing"
+)
+
+func TestSubmissionsAreValidatedAgainstSchemaVersions(t *testing.T) {
+	s := newTestServer(t, newTestDB(t), nil)
+	admin := s.key(t, auth.RoleAdmin, "")
+	submitter := s.key(t, auth.RoleSubmitter, "acme")
+
+	w := s.do("POST", "/api/schemas/greet", admin,
+		`{"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}, "tags": {"items": {"type": "string"}}}}`)
+	expect(t, w, http.StatusCreated, "registering version 1")
+
+	w = s.do("POST", "/api/jobs", submitter, `{"type": "greet", "payload": {"tags": ["a", 2]}}`)
+	expect(t, w, http.StatusUnprocessableEntity, "invalid submission")
+	var invalid struct {
+		SchemaVersion int                     `json:"schema_version"`
+		Errors        []jsonschema.FieldError `json:"errors"`
+	}
+	decode(t, w, &invalid)
+	paths := map[string]bool{}
+	for _, e := range invalid.Errors {
+		paths[e.Path] = true
+	}
+	if invalid.SchemaVersion != 1 || len(paths) != 2 || !paths["/name"] || !paths["/tags/1"] {
+		t.Fatalf("got errors %+v against version %d, want /name and /tags/1 against 1", invalid.Errors, invalid.SchemaVersion)
+	}
+
+	w = s.do("POST", "/api/schemas/greet", admin,
+		`{"type": "object", "required": ["name", "lang"]}`)
+	expect(t, w, http.StatusCreated, "registering version 2")
+
+	// The latest version applies unless a submission names another
+	w = s.do("POST", "/api/jobs", submitter, `{"type": "greet", "payload": {"name": "x"}}`)
+	expect(t, w, http.StatusUnprocessableEntity, "submission missing a field of version 2")
+
+	w = s.do("POST", "/api/jobs", submitter, `{"type": "greet", "schema_version": 1, "payload": {"name": "x"}}`)
+	expect(t, w, http.StatusCreated, "submission against version 1")
+	var job models.Job
+	decode(t, w, &job)
+	if job.SchemaVersion != 1 {
+		t.Fatalf("job matched version %d, want 1", job.SchemaVersion)
+	}
+
+	w = s.do("POST", "/api/jobs", submitter, `{"type": "greet", "schema_version": 3, "payload": {"name": "x"}}`)
+	expect(t, w, http.StatusBadRequest, "submission against an unknown version")
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// ListTenants returns all tenants with their own limits
+func (s *Server) ListTenants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenants, err := s.db.ListTenants()
+	if err != nil {
+		slog.Error("Failed to list tenants", "err", err)
+		http.Error(w, "Failed to fetch tenants", http.StatusInternalServerError)
+		return
+	}
+
+	defaults := s.db.TenantDefaults()
+	for i := range tenants {
+		effective := tenants[i].TenantLimits.Or(defaults)
+		tenants[i].Effective = &effective
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"defaults": defaults,
+		"tenants":  tenants,
+	})
+}
+
+// HandleTenant returns a tenant's limits on GET, replaces them on PUT and
+// reverts the tenant to the defaults on DELETE
+func (s *Server) HandleTenant(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/admin/tenants/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "tenant id is required", http.StatusBadRequest)
+		return
+	}
+
+	target := "tenant:" + id
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		recordChange(r, "tenant.update", target, nil, nil)
+		var limits models.TenantLimits
+		if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if limits.RatePerMinute < 0 || limits.Burst < 0 || limits.MaxConcurrent < 0 ||
+			limits.MaxQueued < 0 || limits.MaxPayloadBytes < 0 || limits.Weight < 0 {
+			http.Error(w, "limits must not be negative", http.StatusBadRequest)
+			return
+		}
+
+		before, err := s.tenantLimits(id)
+		if err != nil {
+			slog.Error("Failed to get tenant", "tenant_id", id, "err", err)
+			http.Error(w, "Failed to save tenant", http.StatusInternalServerError)
+			return
+		}
+
+		tenant := &models.Tenant{ID: id, TenantLimits: limits}
+		if err := s.db.UpsertTenant(tenant); err != nil {
+			slog.Error("Failed to save tenant", "tenant_id", id, "err", err)
+			http.Error(w, "Failed to save tenant", http.StatusInternalServerError)
+			return
+		}
+		slog.Info("Tenant limits updated", "tenant_id", id, "limits", limits)
+		recordChange(r, "tenant.update", target, before, limits)
+		// Lower concurrency limits may free slots for other tenants' jobs
+		s.db.NotifyJobReady()
+	case http.MethodDelete:
+		recordChange(r, "tenant.delete", target, nil, nil)
+		before, err := s.tenantLimits(id)
+		if err != nil {
+			slog.Error("Failed to get tenant", "tenant_id", id, "err", err)
+			http.Error(w, "Failed to delete tenant", http.StatusInternalServerError)
+			return
+		}
+
+		found, err := s.db.DeleteTenant(id)
+		if err != nil {
+			slog.Error("Failed to delete tenant", "tenant_id", id, "err", err)
+			http.Error(w, "Failed to delete tenant", http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, "Tenant not found", http.StatusNotFound)
+			return
+		}
+		slog.Info("Tenant reverted to default limits", "tenant_id", id)
+		recordChange(r, "tenant.delete", target, before, nil)
+		s.db.NotifyJobReady()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tenant, err := s.db.GetTenant(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Tenant not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to get tenant", "tenant_id", id, "err", err)
+		http.Error(w, "Failed to fetch tenant", http.StatusInternalServerError)
+		return
+	}
+
+	effective := tenant.TenantLimits.Or(s.db.TenantDefaults())
+	tenant.Effective = &effective
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tenant)
+}
+
+// tenantLimits returns the limits stored for a tenant, or nil if it has none
+func (s *Server) tenantLimits(id string) (*models.TenantLimits, error) {
+	tenant, err := s.db.GetTenant(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tenant.TenantLimits, nil
+}
@@ -0,0 +1,357 @@
+package api
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultLeaseSeconds = 30
+	maxLeaseSeconds     = 300
+)
+
+// maxWorkerRequestBytes caps the body of worker protocol requests. The
+// largest are batches of job log lines, which a worker sends every second.
+const maxWorkerRequestBytes = 8 << 20
+
+// errWorkerOwned is the response to using a worker ID registered by another
+// caller
+const errWorkerOwned = "Worker is registered by another key"
+
+// RegisterWorker registers a remote worker and its capabilities. The worker
+// ID is bound to the caller, and only the caller may use it afterwards.
+func (s *Server) RegisterWorker(w http.ResponseWriter, r *http.Request) {
+	var req models.WorkerRegisterRequest
+	if !decodeWorkerRequest(w, r, &req) {
+		return
+	}
+
+	if req.ID == "" {
+		req.ID = fmt.Sprintf("worker-%d", time.Now().UnixNano())
+	}
+
+	now := time.Now()
+	info := &models.WorkerInfo{
+		ID:            req.ID,
+		Queues:        nonNil(req.Queues),
+		Types:         nonNil(req.Types),
+		RegisteredAt:  now,
+		LastHeartbeat: now,
+		Owner:         principal(r).ID(),
+	}
+
+	err := s.db.UpsertWorker(info)
+	if errors.Is(err, database.ErrWorkerOwned) {
+		http.Error(w, errWorkerOwned, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to register worker", "worker_id", req.ID, "err", err)
+		http.Error(w, "Failed to register worker", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Worker registered", "worker_id", info.ID, "queues", info.Queues, "types", info.Types)
+	s.wsManager.Broadcast()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(info)
+}
+
+// LeaseJobForWorker leases the next job matching a registered worker's
+// capabilities. It responds 204 No Content when no job is available.
+func (s *Server) LeaseJobForWorker(w http.ResponseWriter, r *http.Request) {
+	var req models.WorkerLeaseRequest
+	if !decodeWorkerRequest(w, r, &req) {
+		return
+	}
+
+	if s.Draining() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	info, ok := s.lookupWorker(w, r, req.WorkerID)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if err := s.db.TouchWorker(info.ID, now); err != nil {
+		slog.Error("Failed to record heartbeat", "worker_id", info.ID, "err", err)
+	}
+
+	filter := database.LeaseFilter{WorkerID: info.ID, Queues: info.Queues, Types: info.Types}
+	job, err := s.db.LeaseJob(filter, now.Add(leaseDuration(req.LeaseSeconds)))
+	if err == sql.ErrNoRows {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to lease job", "worker_id", info.ID, "err", err)
+		http.Error(w, "Failed to lease job", http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.OpenLeasedJob(job, info.ID); err != nil {
+		slog.Error("Failed to decrypt leased job", "job_id", job.ID, "worker_id", info.ID, "err", err)
+		s.wsManager.Broadcast()
+		http.Error(w, "Failed to decrypt job", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Job leased", "job_id", job.ID, "tenant_id", job.TenantID, "trace_id", job.TraceID,
+		"attempt", job.RetryCount+1, "worker_id", info.ID)
+	s.wsManager.Broadcast()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// AckJob marks a job leased by a remote worker as done
+func (s *Server) AckJob(w http.ResponseWriter, r *http.Request) {
+	var req models.WorkerAckRequest
+	if !decodeWorkerRequest(w, r, &req) {
+		return
+	}
+	if _, ok := s.lookupWorker(w, r, req.WorkerID); !ok {
+		return
+	}
+
+	if !writeLeaseError(w, s.db.AckJob(req.JobID, req.WorkerID)) {
+		return
+	}
+
+	slog.Info("Job done", "job_id", req.JobID, "worker_id", req.WorkerID)
+	s.wsManager.Broadcast()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": models.StatusDone})
+}
+
+// NackJob records a failed attempt by a remote worker
+func (s *Server) NackJob(w http.ResponseWriter, r *http.Request) {
+	var req models.WorkerAckRequest
+	if !decodeWorkerRequest(w, r, &req) {
+		return
+	}
+	if _, ok := s.lookupWorker(w, r, req.WorkerID); !ok {
+		return
+	}
+
+	if req.Error == "" {
+		req.Error = "Job failed"
+	}
+
+	dlq, err := s.db.NackJob(req.JobID, req.WorkerID, req.Error)
+	if !writeLeaseError(w, err) {
+		return
+	}
+
+	if dlq {
+		slog.Warn("Job moved to DLQ", "job_id", req.JobID, "worker_id", req.WorkerID, "error", req.Error)
+	} else {
+		slog.Warn("Job failed, will retry", "job_id", req.JobID, "worker_id", req.WorkerID, "error", req.Error)
+	}
+	s.wsManager.Broadcast()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"dlq": dlq})
+}
+
+// ReleaseJob returns a job a remote worker could not finish to the queue
+// without counting an attempt
+func (s *Server) ReleaseJob(w http.ResponseWriter, r *http.Request) {
+	var req models.WorkerAckRequest
+	if !decodeWorkerRequest(w, r, &req) {
+		return
+	}
+	if _, ok := s.lookupWorker(w, r, req.WorkerID); !ok {
+		return
+	}
+
+	if !writeLeaseError(w, s.db.ReleaseJob(req.JobID, req.WorkerID)) {
+		return
+	}
+
+	slog.Info("Job released", "job_id", req.JobID, "worker_id", req.WorkerID)
+	s.wsManager.Broadcast()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WorkerHeartbeat keeps a worker registration alive and extends the lease on
+// the job it is running, if any
+func (s *Server) WorkerHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var req models.WorkerHeartbeatRequest
+	if !decodeWorkerRequest(w, r, &req) {
+		return
+	}
+
+	info, ok := s.lookupWorker(w, r, req.WorkerID)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if err := s.db.TouchWorker(info.ID, now); err != nil {
+		slog.Error("Failed to record heartbeat", "worker_id", info.ID, "err", err)
+		http.Error(w, "Failed to record heartbeat", http.StatusInternalServerError)
+		return
+	}
+
+	if req.JobID != "" {
+		err := s.db.ExtendLease(req.JobID, info.ID, now.Add(leaseDuration(req.LeaseSeconds)))
+		if !writeLeaseError(w, err) {
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeregisterWorker marks a remote worker as cleanly stopped
+func (s *Server) DeregisterWorker(w http.ResponseWriter, r *http.Request) {
+	var req models.WorkerHeartbeatRequest
+	if !decodeWorkerRequest(w, r, &req) {
+		return
+	}
+	if _, ok := s.lookupWorker(w, r, req.WorkerID); !ok {
+		return
+	}
+
+	if err := s.db.StopWorker(req.WorkerID); err != nil {
+		slog.Error("Failed to deregister worker", "worker_id", req.WorkerID, "err", err)
+		http.Error(w, "Failed to deregister worker", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Worker deregistered", "worker_id", req.WorkerID)
+	s.wsManager.Broadcast()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListWorkers returns registered workers with their status and statistics
+func (s *Server) ListWorkers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	workers, err := s.db.ListWorkers(r.URL.Query().Get("status"))
+	if err != nil {
+		slog.Error("Failed to query workers", "err", err)
+		http.Error(w, "Failed to fetch workers", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workers)
+}
+
+// lookupWorker fetches a remote worker registered by the caller of r,
+// writing 410 if it is unknown so that the worker re-registers, and 403 if
+// it belongs to another caller or is an in-process worker. 410 rather than
+// 404 keeps a wrong server URL from looking like a lost registration.
+func (s *Server) lookupWorker(w http.ResponseWriter, r *http.Request, workerID string) (*models.WorkerInfo, bool) {
+	info, err := s.db.GetWorker(workerID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Worker not registered", http.StatusGone)
+		return nil, false
+	}
+	if err != nil {
+		slog.Error("Failed to look up worker", "worker_id", workerID, "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if info.Owner == "" || info.Owner != principal(r).ID() {
+		http.Error(w, errWorkerOwned, http.StatusForbidden)
+		return nil, false
+	}
+	return info, true
+}
+
+// decodeWorkerRequest decodes a worker protocol request, writing an error
+// response and returning false if it is invalid
+func decodeWorkerRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWorkerRequestBytes)).Decode(req)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+
+	switch v := req.(type) {
+	case *models.WorkerLeaseRequest:
+		if v.WorkerID == "" {
+			http.Error(w, "worker_id is required", http.StatusBadRequest)
+			return false
+		}
+	case *models.WorkerHeartbeatRequest:
+		if v.WorkerID == "" {
+			http.Error(w, "worker_id is required", http.StatusBadRequest)
+			return false
+		}
+	case *models.WorkerAckRequest:
+		if v.WorkerID == "" || v.JobID == "" {
+			http.Error(w, "worker_id and job_id are required", http.StatusBadRequest)
+			return false
+		}
+	case *models.WorkerLogRequest:
+		if v.WorkerID == "" || v.JobID == "" {
+			http.Error(w, "worker_id and job_id are required", http.StatusBadRequest)
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeLeaseError writes the response for a failed lease operation and
+// reports whether err was nil
+func writeLeaseError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, database.ErrLeaseLost):
+		http.Error(w, "Job is not leased by this worker", http.StatusConflict)
+	default:
+		slog.Error("Failed to update leased job", "err", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+	return false
+}
+
+func leaseDuration(seconds int) time.Duration {
+	if seconds <= 0 {
+		seconds = defaultLeaseSeconds
+	}
+	if seconds > maxLeaseSeconds {
+		seconds = maxLeaseSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/models"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWorkerIDsBelongToTheirKey(t *testing.T) {
+	s := newTestServer(t, newTestDB(t), nil)
+	admin := s.key(t, auth.RoleAdmin, "")
+	owner := s.key(t, auth.RoleOperator, "")
+	other := s.key(t, auth.RoleOperator, "")
+
+	expect(t, s.do("POST", "/api/worker/register", owner, `{"id": "w1"}`), http.StatusCreated, "register")
+	expect(t, s.do("POST", "/api/worker/register", owner, `{"id": "w1"}`), http.StatusCreated, "re-register by its key")
+	expect(t, s.do("POST", "/api/worker/register", other, `{"id": "w1"}`), http.StatusForbidden, "register by another key")
+
+	expect(t, s.do("POST", "/api/jobs", admin, `{"tenant_id": "acme", "payload": "x"}`), http.StatusCreated, "submit")
+	expect(t, s.do("POST", "/api/worker/lease", other, `{"worker_id": "w1"}`), http.StatusForbidden, "lease by another key")
+	w := s.do("POST", "/api/worker/lease", owner, `{"worker_id": "w1"}`)
+	expect(t, w, http.StatusOK, "lease")
+	var job models.Job
+	decode(t, w, &job)
+
+	calls := []struct{ path, body string }{
+		{"/api/worker/heartbeat", `{"worker_id": "w1", "job_id": "` + job.ID + `"}`},
+		{"/api/worker/logs", `{"worker_id": "w1", "job_id": "` + job.ID + `", "lines": []}`},
+		{"/api/worker/nack", `{"worker_id": "w1", "job_id": "` + job.ID + `"}`},
+		{"/api/worker/release", `{"worker_id": "w1", "job_id": "` + job.ID + `"}`},
+		{"/api/worker/ack", `{"worker_id": "w1", "job_id": "` + job.ID + `"}`},
+		{"/api/worker/deregister", `{"worker_id": "w1"}`},
+	}
+	for _, c := range calls {
+		expect(t, s.do("POST", c.path, other, c.body), http.StatusForbidden, c.path+" by another key")
+	}
+	expect(t, s.do("POST", "/api/worker/ack", owner, calls[4].body), http.StatusOK, "ack")
+	expect(t, s.do("POST", "/api/worker/deregister", owner, calls[5].body), http.StatusNoContent, "deregister")
+}
+
+func TestInProcessWorkersCannotBeUsedRemotely(t *testing.T) {
+	db := newTestDB(t)
+	s := newTestServer(t, db, nil)
+	admin := s.key(t, auth.RoleAdmin, "")
+
+	now := time.Now()
+	if err := db.UpsertWorker(&models.WorkerInfo{ID: "local-1", RegisteredAt: now, LastHeartbeat: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, s.do("POST", "/api/worker/register", admin, `{"id": "local-1"}`), http.StatusForbidden, "register")
+	expect(t, s.do("POST", "/api/worker/heartbeat", admin, `{"worker_id": "local-1"}`), http.StatusForbidden, "heartbeat")
+	expect(t, s.do("POST", "/api/worker/lease", admin, `{"worker_id": "local-1"}`), http.StatusForbidden, "lease")
+}
+
+func TestUnknownWorkersAreGone(t *testing.T) {
+	s := newTestServer(t, newTestDB(t), nil)
+	key := s.key(t, auth.RoleOperator, "")
+
+	for _, path := range []string{"/api/worker/lease", "/api/worker/heartbeat", "/api/worker/deregister"} {
+		expect(t, s.do("POST", path, key, `{"worker_id": "w1"}`), http.StatusGone, path+" by an unknown worker")
+	}
+	expect(t, s.do("POST", "/api/worker/register", key, `{"id": "w1"}`), http.StatusCreated, "register")
+	expect(t, s.do("POST", "/api/worker/lease", key, `{"worker_id": "w1"}`), http.StatusNoContent, "lease")
+}
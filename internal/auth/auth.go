@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"distributed-task-queue/internal/models"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Roles a key can have, from least to most privileged
+const (
+	RoleViewer    = "viewer"    // Reads jobs and metrics
+	RoleSubmitter = "submitter" // Also submits jobs
+	RoleOperator  = "operator"  // Also runs workers and manages the worker pool, alert rules and job schemas
+	RoleAdmin     = "admin"     // Also manages tenants and API keys and reads the audit log
+)
+
+// Permission is an action guarded by a role
+type Permission string
+
+// Permissions checked by the API
+const (
+	PermReadJobs      Permission = "jobs:read"
+	PermSubmitJobs    Permission = "jobs:submit"
+	PermReadWorkers   Permission = "workers:read"
+	PermRunWorkers    Permission = "workers:run" // Remote worker protocol
+	PermManagePool    Permission = "pool:manage"
+	PermManageAlerts  Permission = "alerts:manage"
+	PermManageSchemas Permission = "schemas:manage"
+	PermManageTenants Permission = "tenants:manage"
+	PermManageKeys    Permission = "keys:manage"
+	PermReadAudit     Permission = "audit:read"
+	PermReadMetrics   Permission = "metrics:read" // Prometheus metrics of every tenant
+)
+
+// Audited reports whether changes made with perm are written to the audit
+// log. The worker protocol is left out: it is frequent machine traffic whose
+// effects are recorded on the jobs themselves.
+func (perm Permission) Audited() bool {
+	return perm != PermRunWorkers
+}
+
+// permissions is the permission matrix
+var permissions = map[string][]Permission{
+	RoleViewer:    {PermReadJobs},
+	RoleSubmitter: {PermReadJobs, PermSubmitJobs},
+	RoleOperator: {PermReadJobs, PermSubmitJobs, PermReadWorkers, PermRunWorkers, PermManagePool,
+		PermManageAlerts, PermManageSchemas, PermReadMetrics},
+	RoleAdmin: {PermReadJobs, PermSubmitJobs, PermReadWorkers, PermRunWorkers, PermManagePool,
+		PermManageAlerts, PermManageSchemas, PermReadMetrics, PermManageTenants, PermManageKeys, PermReadAudit},
+}
+
+// keyPrefix starts every API key. A key is keyPrefix + ID + "_" + secret; the
+// ID identifies the key in the database and the secret is only stored hashed.
+const keyPrefix = "tq_"
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	KeyID    string
+	Name     string
+	TenantID string // Empty for keys that act across tenants
+	Role     string
+}
+
+// Anonymous is the principal of every request when authentication is
+// disabled
+var Anonymous = &Principal{Name: "anonymous", Role: RoleAdmin}
+
+// ID identifies p across requests: its key ID, or else its name
+func (p *Principal) ID() string {
+	if p.KeyID != "" {
+		return "key:" + p.KeyID
+	}
+	return p.Name
+}
+
+// Can reports whether p's role grants perm
+func (p *Principal) Can(perm Permission) bool {
+	for _, granted := range permissions[p.Role] {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessTenant reports whether p may act on behalf of tenantID
+func (p *Principal) CanAccessTenant(tenantID string) bool {
+	return p.TenantID == "" || p.TenantID == tenantID
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	_, ok := permissions[role]
+	return ok
+}
+
+// crossTenant reports whether keys with role must act across tenants. Workers
+// and the pool serve every tenant, so operators cannot be bound to one.
+func crossTenant(role string) bool {
+	return role == RoleOperator || role == RoleAdmin
+}
+
+// CheckIdentity reports whether a key or certificate may have role and
+// tenantID
+func CheckIdentity(role, tenantID string) error {
+	if !ValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	if crossTenant(role) && tenantID != "" {
+		return fmt.Errorf("the %s role acts across tenants and cannot have a tenant_id", role)
+	}
+	return nil
+}
+
+// NewKey validates a key request and generates the key. It returns the key
+// record, the secret key to hand to the caller once and the hash to store.
+func NewKey(req models.APIKeyRequest) (*models.APIKey, string, string, error) {
+	if err := CheckIdentity(req.Role, req.TenantID); err != nil {
+		return nil, "", "", err
+	}
+
+	id, err := randomString(6, hex.EncodeToString)
+	if err != nil {
+		return nil, "", "", err
+	}
+	key, hash, err := NewSecret(id)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	k := &models.APIKey{
+		ID:        id,
+		Name:      req.Name,
+		TenantID:  req.TenantID,
+		Role:      req.Role,
+		CreatedAt: time.Now(),
+	}
+	return k, key, hash, nil
+}
+
+// NewSecret generates a new secret key for the key ID and returns it with its
+// hash
+func NewSecret(id string) (string, string, error) {
+	secret, err := randomString(32, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return "", "", err
+	}
+	key := keyPrefix + id + "_" + secret
+	return key, HashKey(key), nil
+}
+
+// ParseKey returns the ID embedded in an API key
+func ParseKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, keyPrefix)
+	if !ok {
+		return "", false
+	}
+	id, _, ok := strings.Cut(rest, "_")
+	return id, ok && id != ""
+}
+
+// HashKey returns the hash of an API key as stored in the database. Keys are
+// random, so a fast unsalted hash is enough to make a leaked table useless.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// MatchKey reports whether key hashes to hash, in constant time
+func MatchKey(key, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashKey(key)), []byte(hash)) == 1
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal stored in ctx, or nil
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
@@ -0,0 +1,416 @@
+package config
+
+import (
+	"distributed-task-queue/internal/auth"
+	"distributed-task-queue/internal/logging"
+	"distributed-task-queue/internal/models"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes the environment variable of every setting
+const EnvPrefix = "TASKQ_"
+
+// Config holds all server settings
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	Database   DatabaseConfig   `yaml:"database"`
+	Workers    WorkerConfig     `yaml:"workers"`
+	Limits     Limits           `yaml:"limits"`
+	Auth       AuthConfig       `yaml:"auth"`
+	Audit      AuditConfig      `yaml:"audit"`
+	JobLogs    JobLogConfig     `yaml:"job_logs"`
+	Alerting   AlertingConfig   `yaml:"alerting"`
+	Encryption EncryptionConfig `yaml:"encryption"`
+	Tracing    TracingConfig    `yaml:"tracing"`
+	Log        LogConfig        `yaml:"log"`
+}
+
+// ServerConfig holds HTTP server settings
+type ServerConfig struct {
+	Addr           string        `yaml:"addr"`
+	StaticDir      string        `yaml:"static_dir"`
+	DrainTimeout   time.Duration `yaml:"drain_timeout"`
+	RateLimitStore string        `yaml:"rate_limit_store"` // memory or database
+	AllowedOrigins []string      `yaml:"allowed_origins"`  // Origins allowed to open WebSockets; empty means same origin only
+	TLS            TLSConfig     `yaml:"tls"`
+}
+
+// TLSConfig holds HTTPS settings. The server uses TLS when CertFile is set.
+type TLSConfig struct {
+	CertFile          string `yaml:"cert_file"`
+	KeyFile           string `yaml:"key_file"`
+	ClientCAFile      string `yaml:"client_ca_file"` // Verify client certificates signed by this CA
+	RequireClientCert bool   `yaml:"require_client_cert"`
+
+	// ClientSubjects maps the subject common name of client certificates to
+	// the identity they authenticate as
+	ClientSubjects map[string]ClientIdentity `yaml:"client_subjects"`
+}
+
+// ClientIdentity is the role and tenant of a client certificate
+type ClientIdentity struct {
+	Role     string `yaml:"role"`
+	TenantID string `yaml:"tenant_id"`
+}
+
+// Rate limit stores
+const (
+	StoreMemory   = "memory"
+	StoreDatabase = "database"
+)
+
+// AuthConfig holds API authentication settings
+type AuthConfig struct {
+	Enabled bool `yaml:"enabled"` // Require an API key on every API request
+}
+
+// AuditConfig holds audit log settings
+type AuditConfig struct {
+	Retention time.Duration `yaml:"retention"` // How long entries are kept; 0 keeps them forever
+}
+
+// JobLogConfig holds settings for the lines job handlers log
+type JobLogConfig struct {
+	MaxLines  int           `yaml:"max_lines"` // Lines kept per job; later lines are dropped
+	Retention time.Duration `yaml:"retention"` // How long lines are kept; 0 keeps them forever
+}
+
+// AlertingConfig holds alert rule evaluation settings
+type AlertingConfig struct {
+	Interval time.Duration `yaml:"interval"` // How often rules are evaluated; 0 turns alerting off
+}
+
+// EncryptionConfig holds settings for encrypting job data at rest
+type EncryptionConfig struct {
+	Keyring           string        `yaml:"keyring"`            // Master key file; empty stores job data in plaintext
+	ReencryptInterval time.Duration `yaml:"reencrypt_interval"` // How often jobs are checked for re-encryption after a rotation
+}
+
+// LogConfig holds logging settings
+type LogConfig struct {
+	Level  string `yaml:"level"`  // debug, info, warn or error
+	Format string `yaml:"format"` // text or json
+}
+
+// TracingConfig holds tracing settings
+type TracingConfig struct {
+	Output string `yaml:"output"` // Where spans are written: empty (off), stdout or a file path
+}
+
+// DatabaseConfig holds storage settings
+type DatabaseConfig struct {
+	Path string `yaml:"path"`
+}
+
+// WorkerConfig holds in-process worker pool settings
+type WorkerConfig struct {
+	Count         int           `yaml:"count"`
+	MinCount      int           `yaml:"min_count"`
+	MaxCount      int           `yaml:"max_count"`
+	Autoscale     bool          `yaml:"autoscale"`
+	PollInterval  time.Duration `yaml:"poll_interval"`
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+}
+
+// Limits holds submission limits. They are safe to change at runtime and are
+// reloaded on SIGHUP. Tenant limits apply to tenants without their own.
+type Limits struct {
+	RatePerMinute     int `yaml:"rate_per_minute"`
+	Burst             int `yaml:"burst"`
+	MaxConcurrent     int `yaml:"max_concurrent"`
+	MaxQueued         int `yaml:"max_queued"`
+	MaxPayloadBytes   int `yaml:"max_payload_bytes"`
+	DefaultMaxRetries int `yaml:"default_max_retries"`
+}
+
+// Tenant returns the default tenant limits
+func (l Limits) Tenant() models.TenantLimits {
+	return models.TenantLimits{
+		RatePerMinute:   l.RatePerMinute,
+		Burst:           l.Burst,
+		MaxConcurrent:   l.MaxConcurrent,
+		MaxQueued:       l.MaxQueued,
+		MaxPayloadBytes: l.MaxPayloadBytes,
+		Weight:          1, // Tenants share workers equally unless weighted
+	}
+}
+
+// Default returns the built-in configuration
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:           ":8080",
+			StaticDir:      "./static",
+			DrainTimeout:   30 * time.Second,
+			RateLimitStore: StoreMemory,
+		},
+		Database: DatabaseConfig{
+			Path: "./jobs.db",
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: logging.FormatText,
+		},
+		Audit: AuditConfig{
+			Retention: 90 * 24 * time.Hour,
+		},
+		JobLogs: JobLogConfig{
+			MaxLines:  1000,
+			Retention: 7 * 24 * time.Hour,
+		},
+		Alerting: AlertingConfig{
+			Interval: 30 * time.Second,
+		},
+		Encryption: EncryptionConfig{
+			ReencryptInterval: time.Minute,
+		},
+		Workers: WorkerConfig{
+			Count:         3,
+			MinCount:      1,
+			MaxCount:      10,
+			Autoscale:     true,
+			PollInterval:  2 * time.Second,
+			LeaseDuration: 30 * time.Second,
+		},
+		Limits: Limits{
+			RatePerMinute:     10,
+			Burst:             10,
+			MaxConcurrent:     5,
+			MaxQueued:         1000,
+			MaxPayloadBytes:   1 << 20,
+			DefaultMaxRetries: 3,
+		},
+	}
+}
+
+// setting binds one field to a flag and an environment variable
+type setting struct {
+	flag  string
+	usage string
+	value func(c *Config) interface{} // Pointer to the field
+}
+
+var settings = []setting{
+	{"addr", "HTTP listen address", func(c *Config) interface{} { return &c.Server.Addr }},
+	{"static-dir", "directory served at /", func(c *Config) interface{} { return &c.Server.StaticDir }},
+	{"drain-timeout", "how long to wait for in-flight jobs on shutdown before releasing them", func(c *Config) interface{} { return &c.Server.DrainTimeout }},
+	{"rate-limit-store", "where rate limit state is kept: memory, or database to share it between servers", func(c *Config) interface{} { return &c.Server.RateLimitStore }},
+	{"allowed-origins", "comma-separated origins allowed to open WebSockets, * for any (same origin if empty)", func(c *Config) interface{} { return &c.Server.AllowedOrigins }},
+	{"tls-cert", "TLS certificate file; serves HTTPS when set", func(c *Config) interface{} { return &c.Server.TLS.CertFile }},
+	{"tls-key", "TLS private key file", func(c *Config) interface{} { return &c.Server.TLS.KeyFile }},
+	{"tls-client-ca", "CA file to verify client certificates against", func(c *Config) interface{} { return &c.Server.TLS.ClientCAFile }},
+	{"tls-require-client-cert", "reject connections without a valid client certificate", func(c *Config) interface{} { return &c.Server.TLS.RequireClientCert }},
+	{"auth", "require an API key on every API request", func(c *Config) interface{} { return &c.Auth.Enabled }},
+	{"audit-retention", "how long audit log entries are kept, 0 to keep them forever", func(c *Config) interface{} { return &c.Audit.Retention }},
+	{"job-log-max-lines", "log lines kept per job", func(c *Config) interface{} { return &c.JobLogs.MaxLines }},
+	{"job-log-retention", "how long job log lines are kept, 0 to keep them forever", func(c *Config) interface{} { return &c.JobLogs.Retention }},
+	{"alert-interval", "how often alert rules are evaluated, 0 to turn alerting off", func(c *Config) interface{} { return &c.Alerting.Interval }},
+	{"keyring", "keyring file of master keys; encrypts job payloads and error messages at rest when set", func(c *Config) interface{} { return &c.Encryption.Keyring }},
+	{"reencrypt-interval", "how often jobs are checked for re-encryption after a key rotation", func(c *Config) interface{} { return &c.Encryption.ReencryptInterval }},
+	{"log-level", "minimum level of log messages: debug, info, warn or error", func(c *Config) interface{} { return &c.Log.Level }},
+	{"log-format", "log output format: text or json", func(c *Config) interface{} { return &c.Log.Format }},
+	{"trace-output", "where to write trace spans as JSON lines: stdout or a file path (off if empty)", func(c *Config) interface{} { return &c.Tracing.Output }},
+	{"db", "SQLite database path", func(c *Config) interface{} { return &c.Database.Path }},
+	{"workers", "initial number of in-process workers", func(c *Config) interface{} { return &c.Workers.Count }},
+	{"min-workers", "minimum number of in-process workers when autoscaling", func(c *Config) interface{} { return &c.Workers.MinCount }},
+	{"max-workers", "maximum number of in-process workers when autoscaling", func(c *Config) interface{} { return &c.Workers.MaxCount }},
+	{"autoscale", "scale the worker pool with backlog", func(c *Config) interface{} { return &c.Workers.Autoscale }},
+	{"poll-interval", "maximum idle poll interval of workers", func(c *Config) interface{} { return &c.Workers.PollInterval }},
+	{"lease-duration", "how long a job is leased to a worker between renewals", func(c *Config) interface{} { return &c.Workers.LeaseDuration }},
+	{"rate-limit", "job submissions per tenant per minute", func(c *Config) interface{} { return &c.Limits.RatePerMinute }},
+	{"burst", "submissions a tenant may make at once after being idle", func(c *Config) interface{} { return &c.Limits.Burst }},
+	{"max-concurrent", "running jobs per tenant", func(c *Config) interface{} { return &c.Limits.MaxConcurrent }},
+	{"max-queued", "jobs waiting to run per tenant", func(c *Config) interface{} { return &c.Limits.MaxQueued }},
+	{"max-payload", "largest job payload in bytes", func(c *Config) interface{} { return &c.Limits.MaxPayloadBytes }},
+	{"max-retries", "default max retries of a job", func(c *Config) interface{} { return &c.Limits.DefaultMaxRetries }},
+}
+
+// EnvName returns the environment variable for a flag name, e.g. TASKQ_MAX_WORKERS
+func EnvName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load builds the configuration from, in increasing order of precedence, the
+// defaults, the YAML file named by -config or TASKQ_CONFIG, environment
+// variables and command-line flags, and validates the result
+func Load(args []string) (*Config, error) {
+	defaults := Default()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv(EnvPrefix+"CONFIG"), "YAML config file")
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		name := s.flag
+		usage := fmt.Sprintf("%s (env %s, default %v)", s.usage, EnvName(name), deref(s.value(defaults)))
+		record := func(v string) error {
+			flagValues[name] = v
+			return nil
+		}
+		if _, ok := s.value(defaults).(*bool); ok {
+			fs.BoolFunc(name, usage, record)
+		} else {
+			fs.Func(name, usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+
+	if *path != "" {
+		if err := loadFile(cfg, *path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(EnvName(s.flag)); ok {
+			if err := set(s.value(cfg), v); err != nil {
+				return nil, fmt.Errorf("%s: %w", EnvName(s.flag), err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := flagValues[s.flag]; ok {
+			if err := set(s.value(cfg), v); err != nil {
+				return nil, fmt.Errorf("-%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile overlays the settings present in a YAML file onto cfg. Unknown
+// keys are rejected so that typos do not go unnoticed.
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks that settings are within their allowed ranges
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.DrainTimeout >= 0, "server.drain_timeout must not be negative")
+	check(c.Server.RateLimitStore == StoreMemory || c.Server.RateLimitStore == StoreDatabase,
+		"server.rate_limit_store must be %q or %q", StoreMemory, StoreDatabase)
+	t := c.Server.TLS
+	check((t.CertFile == "") == (t.KeyFile == ""), "server.tls.cert_file and server.tls.key_file must be set together")
+	check(t.ClientCAFile == "" || t.CertFile != "", "server.tls.client_ca_file requires server.tls.cert_file")
+	check(!t.RequireClientCert || t.ClientCAFile != "", "server.tls.require_client_cert requires server.tls.client_ca_file")
+	for subject, id := range t.ClientSubjects {
+		if err := auth.CheckIdentity(id.Role, id.TenantID); err != nil {
+			errs = append(errs, fmt.Errorf("server.tls.client_subjects[%s]: %w", subject, err))
+		}
+	}
+
+	check(c.Database.Path != "", "database.path is required")
+	check(c.Audit.Retention >= 0, "audit.retention must not be negative")
+	check(c.JobLogs.MaxLines > 0, "job_logs.max_lines must be positive")
+	check(c.JobLogs.Retention >= 0, "job_logs.retention must not be negative")
+	check(c.Alerting.Interval >= 0, "alerting.interval must not be negative")
+	check(c.Encryption.ReencryptInterval > 0, "encryption.reencrypt_interval must be positive")
+	if _, err := logging.New(io.Discard, c.Log.Level, c.Log.Format); err != nil {
+		errs = append(errs, fmt.Errorf("log: %w", err))
+	}
+
+	w := c.Workers
+	check(w.MinCount >= 0, "workers.min_count must not be negative")
+	check(w.MaxCount >= w.MinCount, "workers.max_count must be at least workers.min_count")
+	check(w.Count >= w.MinCount && w.Count <= w.MaxCount,
+		"workers.count must be between workers.min_count and workers.max_count")
+	check(w.PollInterval > 0, "workers.poll_interval must be positive")
+	check(w.LeaseDuration >= 3*time.Second, "workers.lease_duration must be at least 3s")
+
+	check(c.Limits.RatePerMinute > 0, "limits.rate_per_minute must be positive")
+	check(c.Limits.Burst > 0, "limits.burst must be positive")
+	check(c.Limits.MaxConcurrent > 0, "limits.max_concurrent must be positive")
+	check(c.Limits.MaxQueued > 0, "limits.max_queued must be positive")
+	check(c.Limits.MaxPayloadBytes > 0, "limits.max_payload_bytes must be positive")
+	check(c.Limits.DefaultMaxRetries > 0, "limits.default_max_retries must be positive")
+
+	return errors.Join(errs...)
+}
+
+// set parses v into the field p points to
+func set(p interface{}, v string) error {
+	switch p := p.(type) {
+	case *string:
+		*p = v
+	case *int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*p = b
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*p = d
+	case *[]string:
+		*p = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				*p = append(*p, s)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported setting type %T", p)
+	}
+	return nil
+}
+
+func deref(p interface{}) interface{} {
+	switch p := p.(type) {
+	case *string:
+		return *p
+	case *int:
+		return *p
+	case *bool:
+		return *p
+	case *time.Duration:
+		return *p
+	case *[]string:
+		return strings.Join(*p, ",")
+	}
+	return p
+}
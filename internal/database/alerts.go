@@ -0,0 +1,99 @@
+package database
+
+import (
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"time"
+)
+
+// alertRuleColumns is the column list understood by scanAlertRule
+const alertRuleColumns = `name, description, metric, change, op, threshold, window_seconds, for_seconds,
+	group_by, queue, tenant_id, notify, disabled, created_at, updated_at`
+
+// UpsertAlertRule creates or replaces an alert rule
+func (db *DB) UpsertAlertRule(rule *models.AlertRule) error {
+	notify, err := json.Marshal(rule.Notify)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err = db.QueryRow(`
+		INSERT INTO alert_rules (name, description, metric, change, op, threshold, window_seconds,
+			for_seconds, group_by, queue, tenant_id, notify, disabled, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			description = excluded.description,
+			metric = excluded.metric,
+			change = excluded.change,
+			op = excluded.op,
+			threshold = excluded.threshold,
+			window_seconds = excluded.window_seconds,
+			for_seconds = excluded.for_seconds,
+			group_by = excluded.group_by,
+			queue = excluded.queue,
+			tenant_id = excluded.tenant_id,
+			notify = excluded.notify,
+			disabled = excluded.disabled,
+			updated_at = excluded.updated_at
+		RETURNING created_at
+	`, rule.Name, rule.Description, rule.Metric, rule.Change, rule.Op, rule.Threshold, rule.WindowSeconds,
+		rule.ForSeconds, rule.GroupBy, rule.Queue, rule.TenantID, string(notify), rule.Disabled,
+		now, now).Scan(&rule.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	rule.UpdatedAt = now
+	return nil
+}
+
+// GetAlertRule retrieves an alert rule by name
+func (db *DB) GetAlertRule(name string) (*models.AlertRule, error) {
+	return scanAlertRule(db.QueryRow("SELECT "+alertRuleColumns+" FROM alert_rules WHERE name = ?", name))
+}
+
+// ListAlertRules retrieves all alert rules
+func (db *DB) ListAlertRules() ([]models.AlertRule, error) {
+	rows, err := db.Query("SELECT " + alertRuleColumns + " FROM alert_rules ORDER BY name ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []models.AlertRule{}
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+	return rules, rows.Err()
+}
+
+// DeleteAlertRule removes an alert rule. It reports whether the rule existed.
+func (db *DB) DeleteAlertRule(name string) (bool, error) {
+	res, err := db.Exec("DELETE FROM alert_rules WHERE name = ?", name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// scanAlertRule scans a row selected with alertRuleColumns
+func scanAlertRule(row rowScanner) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	var notify string
+	err := row.Scan(&rule.Name, &rule.Description, &rule.Metric, &rule.Change, &rule.Op, &rule.Threshold,
+		&rule.WindowSeconds, &rule.ForSeconds, &rule.GroupBy, &rule.Queue, &rule.TenantID, &notify,
+		&rule.Disabled, &rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(notify), &rule.Notify); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
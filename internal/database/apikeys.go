@@ -0,0 +1,110 @@
+package database
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/models"
+	"time"
+)
+
+// apiKeyColumns is the column list understood by scanAPIKey
+const apiKeyColumns = `id, name, tenant_id, role, created_at, rotated_at, last_used_at, revoked_at`
+
+// InsertAPIKey stores a new API key with the hash of its secret
+func (db *DB) InsertAPIKey(k *models.APIKey, hash string) error {
+	_, err := db.Exec(`
+		INSERT INTO api_keys (id, name, tenant_id, role, key_hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, k.ID, k.Name, k.TenantID, k.Role, hash, k.CreatedAt)
+	return err
+}
+
+// GetAPIKey retrieves an API key and the hash of its secret
+func (db *DB) GetAPIKey(id string) (*models.APIKey, string, error) {
+	var hash string
+	k, err := scanAPIKey(db.QueryRow("SELECT "+apiKeyColumns+", key_hash FROM api_keys WHERE id = ?", id), &hash)
+	return k, hash, err
+}
+
+// ListAPIKeys retrieves all API keys, including revoked ones
+func (db *DB) ListAPIKeys() ([]models.APIKey, error) {
+	rows, err := db.Query("SELECT " + apiKeyColumns + " FROM api_keys ORDER BY created_at ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// RotateAPIKey replaces the secret of an active key; the old secret stops
+// working immediately. It returns sql.ErrNoRows if the key does not exist or
+// was revoked.
+func (db *DB) RotateAPIKey(id, hash string) error {
+	res, err := db.Exec("UPDATE api_keys SET key_hash = ?, rotated_at = ? WHERE id = ? AND revoked_at IS NULL",
+		hash, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// RevokeAPIKey permanently disables a key. It returns sql.ErrNoRows if the
+// key does not exist or was already revoked.
+func (db *DB) RevokeAPIKey(id string) error {
+	res, err := db.Exec("UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL",
+		time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// TouchAPIKey records that a key was used
+func (db *DB) TouchAPIKey(id string, at time.Time) error {
+	_, err := db.Exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", at, id)
+	return err
+}
+
+// scanAPIKey scans a row selected with apiKeyColumns followed by extra
+func scanAPIKey(row rowScanner, extra ...interface{}) (*models.APIKey, error) {
+	var k models.APIKey
+	var rotatedAt, lastUsedAt, revokedAt sql.NullTime
+
+	dest := append([]interface{}{&k.ID, &k.Name, &k.TenantID, &k.Role, &k.CreatedAt,
+		&rotatedAt, &lastUsedAt, &revokedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	k.RotatedAt = nullTime(rotatedAt)
+	k.LastUsedAt = nullTime(lastUsedAt)
+	k.RevokedAt = nullTime(revokedAt)
+	return &k, nil
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// expectRow returns sql.ErrNoRows if an update matched no row
+func expectRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/models"
+	"time"
+)
+
+// InsertAuditEntry appends an entry to the audit log and sets its ID
+func (db *DB) InsertAuditEntry(e *models.AuditEntry) error {
+	res, err := db.Exec(`
+		INSERT INTO audit_log (created_at, actor, key_id, role, source_ip, request_id,
+			permission, action, target, outcome, status, before, after)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.CreatedAt, e.Actor, e.KeyID, e.Role, e.SourceIP, e.RequestID,
+		e.Permission, e.Action, e.Target, e.Outcome, e.Status, nullJSON(e.Before), nullJSON(e.After))
+	if err != nil {
+		return err
+	}
+	e.ID, err = res.LastInsertId()
+	return err
+}
+
+// ListAuditEntries retrieves the audit entries matching f, newest first
+func (db *DB) ListAuditEntries(f models.AuditFilter) ([]models.AuditEntry, error) {
+	query := `SELECT id, created_at, actor, key_id, role, source_ip, request_id,
+		permission, action, target, outcome, status, before, after
+		FROM audit_log WHERE 1=1`
+	args := []interface{}{}
+
+	filter := func(clause string, v interface{}) {
+		query += " AND " + clause
+		args = append(args, v)
+	}
+	if f.KeyID != "" {
+		filter("key_id = ?", f.KeyID)
+	}
+	if f.Action != "" {
+		filter("action = ?", f.Action)
+	}
+	if f.Target != "" {
+		filter("target = ?", f.Target)
+	}
+	if f.Outcome != "" {
+		filter("outcome = ?", f.Outcome)
+	}
+	if !f.Since.IsZero() {
+		filter("created_at >= ?", f.Since)
+	}
+	if !f.Until.IsZero() {
+		filter("created_at < ?", f.Until)
+	}
+	if f.BeforeID > 0 {
+		filter("id < ?", f.BeforeID)
+	}
+
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, f.Limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		var before, after sql.NullString
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.KeyID, &e.Role, &e.SourceIP, &e.RequestID,
+			&e.Permission, &e.Action, &e.Target, &e.Outcome, &e.Status, &before, &after); err != nil {
+			return nil, err
+		}
+		if before.Valid {
+			e.Before = []byte(before.String)
+		}
+		if after.Valid {
+			e.After = []byte(after.String)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// DeleteAuditEntriesBefore removes audit entries older than cutoff
+func (db *DB) DeleteAuditEntriesBefore(cutoff time.Time) (int64, error) {
+	res, err := db.Exec("DELETE FROM audit_log WHERE created_at < ?", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func nullJSON(raw []byte) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
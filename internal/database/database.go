@@ -2,22 +2,79 @@ package database
 
 import (
 	"database/sql"
+	"distributed-task-queue/internal/keyring"
 	"distributed-task-queue/internal/models"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/mattn/go-sqlite3"
 )
 
+// ErrLeaseLost is returned when a worker reports on a job it no longer holds
+// the lease for, typically because the lease expired and the job was handed
+// to another worker
+var ErrLeaseLost = errors.New("job lease lost")
+
+// ErrDuplicateJob is returned when the tenant already has a job with the
+// submitted idempotency key
+var ErrDuplicateJob = errors.New("job with this idempotency key already exists")
+
+// jobColumns is the column list understood by scanJob
+const jobColumns = `id, tenant_id, payload, status, idempotency_key, retry_count, max_retries,
+	created_at, updated_at, leased_until, error_message, trace_id, queue, job_type, leased_by, traceparent,
+	schema_version, content_type, data_key`
+
 // DB wraps the SQL database with helper methods
 type DB struct {
 	*sql.DB
+	jobReady chan struct{}
+
+	defaultsMu sync.RWMutex
+	defaults   models.TenantLimits
+
+	history *history // Statistics not yet written to metrics_history
+
+	enc *encryption // Nil when job data is stored in plaintext
+
+	logsMu       sync.Mutex
+	logsAppended chan struct{} // Closed and replaced when job log lines are appended
+	maxLogLines  int
 }
 
-// New creates a new database connection
+// New creates a new database connection. Transactions take the write lock
+// when they begin, so that concurrent writers wait for each other instead of
+// failing with "database is locked" when both upgrade a read lock.
 func New(dataSourceName string) (*DB, error) {
-	db, err := sql.Open("sqlite3", dataSourceName)
+	db, err := sql.Open("sqlite3", withParam(dataSourceName, "_txlock=immediate"))
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db}, nil
+	return &DB{
+		DB:           db,
+		jobReady:     make(chan struct{}, 1),
+		history:      newHistory(),
+		logsAppended: make(chan struct{}),
+		maxLogLines:  DefaultMaxLogLines,
+	}, nil
+}
+
+// JobReady returns a channel that is signalled when a job may have become
+// available for leasing
+func (db *DB) JobReady() <-chan struct{} {
+	return db.jobReady
+}
+
+// NotifyJobReady wakes an idle worker without blocking. Signals are coalesced,
+// so a burst of inserts results in a single pending wakeup.
+func (db *DB) NotifyJobReady() {
+	select {
+	case db.jobReady <- struct{}{}:
+	default:
+	}
 }
 
 // InitSchema initializes the database schema
@@ -40,61 +97,68 @@ func (db *DB) InitSchema() error {
 	
 	CREATE INDEX IF NOT EXISTS idx_status ON jobs(status);
 	CREATE INDEX IF NOT EXISTS idx_tenant ON jobs(tenant_id);
-	CREATE INDEX IF NOT EXISTS idx_idempotency ON jobs(idempotency_key) WHERE idempotency_key IS NOT NULL;
 	CREATE INDEX IF NOT EXISTS idx_leased ON jobs(leased_until) WHERE leased_until IS NOT NULL;
 	`
 
-	_, err := db.Exec(schema)
-	return err
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+
+	return db.migrate()
 }
 
 // InsertJob inserts a new job into the database
 func (db *DB) InsertJob(job *models.Job) error {
-	_, err := db.Exec(`
-		INSERT INTO jobs (id, tenant_id, payload, status, idempotency_key, retry_count, max_retries, created_at, updated_at, trace_id)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, job.ID, job.TenantID, job.Payload, job.Status, nullString(job.IdempotencyKey),
-		job.RetryCount, job.MaxRetries, job.CreatedAt, job.UpdatedAt, job.TraceID)
-	return err
-}
-
-// GetJobByID retrieves a job by its ID
-func (db *DB) GetJobByID(id string) (*models.Job, error) {
-	var job models.Job
-	var leasedUntil sql.NullTime
-	var idempotencyKey sql.NullString
-	var errorMessage sql.NullString
-
-	err := db.QueryRow(`
-		SELECT id, tenant_id, payload, status, idempotency_key, retry_count, max_retries, 
-		       created_at, updated_at, leased_until, error_message, trace_id
-		FROM jobs WHERE id = ?
-	`, id).Scan(&job.ID, &job.TenantID, &job.Payload, &job.Status,
-		&idempotencyKey, &job.RetryCount, &job.MaxRetries,
-		&job.CreatedAt, &job.UpdatedAt, &leasedUntil, &errorMessage, &job.TraceID)
-
+	data, err := job.PayloadData()
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	if idempotencyKey.Valid {
-		job.IdempotencyKey = idempotencyKey.String
+	// Binary payloads are stored as BLOBs, text and JSON as TEXT, and
+	// encrypted ones as BLOBs
+	var payload interface{} = string(data)
+	if job.PayloadEncoding != "" {
+		payload = data
 	}
-	if leasedUntil.Valid {
-		t := leasedUntil.Time
-		job.LeasedUntil = &t
+	var dataKey int64
+	if db.enc != nil {
+		var key []byte
+		if dataKey, key, err = db.currentDataKey(job.TenantID); err != nil {
+			return err
+		}
+		if payload, err = keyring.Seal(key, data, jobAAD(job.ID, "payload")); err != nil {
+			return err
+		}
 	}
-	if errorMessage.Valid {
-		job.ErrorMessage = errorMessage.String
+
+	_, err = db.Exec(`
+		INSERT INTO jobs (id, tenant_id, payload, status, idempotency_key, retry_count, max_retries, created_at, updated_at, trace_id, queue, job_type, traceparent, schema_version, content_type, data_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, job.ID, job.TenantID, payload, job.Status, nullString(job.IdempotencyKey),
+		job.RetryCount, job.MaxRetries, job.CreatedAt, job.UpdatedAt, job.TraceID, job.Queue, job.Type, job.Traceparent,
+		job.SchemaVersion, job.ContentType, dataKey)
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique && job.IdempotencyKey != "" {
+		return ErrDuplicateJob
+	}
+	if err != nil {
+		return err
 	}
 
-	return &job, nil
+	jobsEnqueued.Inc(job.Queue, job.TenantID)
+	db.historyEnqueued(job.Queue, job.TenantID)
+	db.NotifyJobReady()
+	return nil
 }
 
-// GetJobByIdempotencyKey retrieves a job by its idempotency key
-func (db *DB) GetJobByIdempotencyKey(key string) (*models.Job, error) {
+// GetJobByID retrieves a job by its ID
+func (db *DB) GetJobByID(id string) (*models.Job, error) {
+	return scanJob(db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = ?", id))
+}
+
+// GetJobByIdempotencyKey retrieves a tenant's job by its idempotency key
+func (db *DB) GetJobByIdempotencyKey(tenantID, key string) (*models.Job, error) {
 	var id string
-	err := db.QueryRow("SELECT id FROM jobs WHERE idempotency_key = ?", key).Scan(&id)
+	err := db.QueryRow("SELECT id FROM jobs WHERE tenant_id = ? AND idempotency_key = ?", tenantID, key).Scan(&id)
 	if err != nil {
 		return nil, err
 	}
@@ -103,9 +167,7 @@ func (db *DB) GetJobByIdempotencyKey(key string) (*models.Job, error) {
 
 // ListJobs retrieves jobs with optional filtering
 func (db *DB) ListJobs(status, tenantID string, limit int) ([]models.Job, error) {
-	query := `SELECT id, tenant_id, payload, status, idempotency_key, retry_count, max_retries,
-	          created_at, updated_at, leased_until, error_message, trace_id
-	          FROM jobs WHERE 1=1`
+	query := "SELECT " + jobColumns + " FROM jobs WHERE 1=1"
 	args := []interface{}{}
 
 	if status != "" {
@@ -130,53 +192,15 @@ func (db *DB) ListJobs(status, tenantID string, limit int) ([]models.Job, error)
 	return scanJobs(rows)
 }
 
-// GetAllJobs retrieves all jobs
-func (db *DB) GetAllJobs() ([]models.Job, error) {
-	rows, err := db.Query(`
-		SELECT id, tenant_id, payload, status, idempotency_key, retry_count, max_retries,
-		       created_at, updated_at, leased_until, error_message, trace_id
-		FROM jobs ORDER BY created_at DESC
-	`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	return scanJobs(rows)
-}
-
-// GetRunningJobsCount returns the count of running jobs for a tenant
-func (db *DB) GetRunningJobsCount(tenantID string) (int, error) {
-	var count int
-	err := db.QueryRow(
-		"SELECT COUNT(*) FROM jobs WHERE tenant_id = ? AND status = ?",
-		tenantID, models.StatusRunning,
-	).Scan(&count)
-	return count, err
-}
-
-// UpdateJobStatus updates a job's status
-func (db *DB) UpdateJobStatus(jobID, status string, errorMsg string) error {
-	_, err := db.Exec(`
-		UPDATE jobs 
-		SET status = ?, updated_at = ?, leased_until = NULL, error_message = ?
-		WHERE id = ?
-	`, status, time.Now(), nullString(errorMsg), jobID)
-	return err
-}
-
-// UpdateJobForRetry updates a job for retry
-func (db *DB) UpdateJobForRetry(jobID string, retryCount int, errorMsg string) error {
-	_, err := db.Exec(`
-		UPDATE jobs 
-		SET status = ?, retry_count = ?, updated_at = ?, leased_until = NULL, error_message = ?
-		WHERE id = ?
-	`, models.StatusFailed, retryCount, time.Now(), errorMsg, jobID)
-	return err
+// LeaseFilter restricts which jobs a worker may lease
+type LeaseFilter struct {
+	WorkerID string
+	Queues   []string // Empty means any queue
+	Types    []string // Empty means any job type
 }
 
 // LeaseJob atomically leases a job for processing
-func (db *DB) LeaseJob(leaseUntil time.Time) (*models.Job, error) {
+func (db *DB) LeaseJob(filter LeaseFilter, leaseUntil time.Time) (*models.Job, error) {
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
@@ -184,100 +208,448 @@ func (db *DB) LeaseJob(leaseUntil time.Time) (*models.Job, error) {
 	defer tx.Rollback()
 
 	now := time.Now()
-	var jobID, tenantID, payload, status, traceID string
-	var retryCount, maxRetries int
+	maxConcurrent := db.TenantDefaults().MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = math.MaxInt32 // No default set
+	}
 
-	// Try to get a job that needs processing
-	err = tx.QueryRow(`
-		SELECT id, tenant_id, payload, status, retry_count, max_retries, trace_id
-		FROM jobs
-		WHERE (status = ? OR 
+	// Jobs of tenants already running as many jobs as they may are skipped.
+	// Running jobs whose lease expired do not count, as they are up for grabs.
+	busy := `WITH busy AS (
+			SELECT r.tenant_id FROM jobs r LEFT JOIN tenants t ON t.id = r.tenant_id
+			WHERE r.status = ? AND r.leased_until >= ?
+			GROUP BY r.tenant_id
+			HAVING COUNT(*) >= COALESCE(NULLIF(MAX(t.max_concurrent), 0), ?)
+		) `
+	where := ` WHERE (status = ? OR
 		       (status = ? AND leased_until < ?) OR
 		       (status = ? AND retry_count < max_retries))
-		ORDER BY created_at ASC
-		LIMIT 1
-	`, models.StatusPending, models.StatusRunning, now, models.StatusFailed).Scan(
-		&jobID, &tenantID, &payload, &status, &retryCount, &maxRetries, &traceID)
+		AND tenant_id NOT IN (SELECT tenant_id FROM busy)`
+	args := []interface{}{models.StatusRunning, now, maxConcurrent,
+		models.StatusPending, models.StatusRunning, now, models.StatusFailed}
+
+	if len(filter.Queues) > 0 {
+		where += " AND queue IN (" + placeholders(len(filter.Queues)) + ")"
+		for _, q := range filter.Queues {
+			args = append(args, q)
+		}
+	}
+	if len(filter.Types) > 0 {
+		where += " AND job_type IN (" + placeholders(len(filter.Types)) + ")"
+		for _, t := range filter.Types {
+			args = append(args, t)
+		}
+	}
 
+	// Pick the tenant whose turn it is, then its oldest job
+	share, err := db.pickTenant(tx, busy, where, args)
 	if err != nil {
 		return nil, err
 	}
 
+	query := busy + "SELECT " + jobColumns + " FROM jobs" + where +
+		" AND tenant_id = ? ORDER BY created_at ASC LIMIT 1"
+	job, err := scanJob(tx.QueryRow(query, append(args, share.tenantID)...))
+	if err != nil {
+		return nil, err
+	}
+
+	// Jobs reclaimed from an expired lease have not been waiting since their
+	// last update, so only pending and failed jobs count towards wait time
+	var wait time.Duration
+	if job.Status != models.StatusRunning {
+		wait = now.Sub(job.UpdatedAt)
+	}
+	if err := share.charge(tx, wait); err != nil {
+		return nil, err
+	}
+
 	// Lease the job
 	_, err = tx.Exec(`
 		UPDATE jobs 
-		SET status = ?, leased_until = ?, updated_at = ?
+		SET status = ?, leased_until = ?, leased_by = ?, updated_at = ?, started_at = ?
 		WHERE id = ?
-	`, models.StatusRunning, leaseUntil, now, jobID)
+	`, models.StatusRunning, leaseUntil, nullString(filter.WorkerID), now, now, job.ID)
 
 	if err != nil {
 		return nil, err
 	}
 
+	// Leasing counts as a heartbeat
+	_, err = tx.Exec("UPDATE workers SET current_job = ?, last_heartbeat = ?, status = ? WHERE id = ?",
+		job.ID, now, models.WorkerAlive, filter.WorkerID)
+	if err != nil {
+		return nil, err
+	}
+
 	if err = tx.Commit(); err != nil {
 		return nil, err
 	}
 
-	// Return the leased job
-	return &models.Job{
-		ID:          jobID,
-		TenantID:    tenantID,
-		Payload:     payload,
-		Status:      models.StatusRunning,
-		RetryCount:  retryCount,
-		MaxRetries:  maxRetries,
-		TraceID:     traceID,
-		LeasedUntil: &leaseUntil,
-	}, nil
+	switch job.Status {
+	case models.StatusRunning:
+		leaseExpirations.Inc("expired")
+	case models.StatusFailed:
+		jobsRetried.Inc(job.Queue, job.TenantID)
+		jobQueueWait.Observe(wait.Seconds(), job.Queue)
+		db.historyWaited(job.Queue, job.TenantID, wait)
+	default:
+		jobQueueWait.Observe(wait.Seconds(), job.Queue)
+		db.historyWaited(job.Queue, job.TenantID, wait)
+	}
+	traceLease(job, filter.WorkerID, wait, now)
+
+	job.Status = models.StatusRunning
+	job.LeasedUntil = &leaseUntil
+	job.LeasedBy = filter.WorkerID
+	job.UpdatedAt = now
+	return job, nil
 }
 
-// GetMetrics retrieves system metrics
-func (db *DB) GetMetrics() (*models.Metrics, error) {
+// AckJob marks a leased job as done. It fails with ErrLeaseLost if workerID
+// no longer holds the lease.
+func (db *DB) AckJob(jobID, workerID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var queue, tenantID string
+	var startedAt sql.NullTime
+	err = tx.QueryRow(
+		"SELECT queue, tenant_id, started_at FROM jobs WHERE id = ? AND status = ? AND leased_by = ?",
+		jobID, models.StatusRunning, workerID,
+	).Scan(&queue, &tenantID, &startedAt)
+	if err == sql.ErrNoRows {
+		return ErrLeaseLost
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE jobs
+		SET status = ?, updated_at = ?, leased_until = NULL, leased_by = NULL, error_message = NULL
+		WHERE id = ?
+	`, models.StatusDone, time.Now(), jobID)
+	if err != nil {
+		return err
+	}
+
+	if err := recordWorkerResult(tx, workerID, false); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	jobsCompleted.Inc(queue, tenantID)
+	observeExecution(queue, "completed", startedAt)
+	db.historyFinished(queue, tenantID, false, startedAt)
+	return nil
+}
+
+// NackJob records a failed attempt on a leased job. The job is scheduled for
+// retry, or moved to the DLQ once it has used up its retries, which is
+// reported by the returned bool.
+func (db *DB) NackJob(jobID, workerID, errorMsg string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var retryCount, maxRetries int
+	var queue, tenantID string
+	var startedAt sql.NullTime
+	var dataKey int64
+	err = tx.QueryRow(
+		"SELECT retry_count, max_retries, queue, tenant_id, started_at, data_key FROM jobs WHERE id = ? AND status = ? AND leased_by = ?",
+		jobID, models.StatusRunning, workerID,
+	).Scan(&retryCount, &maxRetries, &queue, &tenantID, &startedAt, &dataKey)
+	if err == sql.ErrNoRows {
+		return false, ErrLeaseLost
+	}
+	if err != nil {
+		return false, err
+	}
+
+	retryCount++
+	dlq := retryCount >= maxRetries
+	if dlq {
+		errorMsg = "Max retries exceeded - moved to DLQ: " + errorMsg
+	}
+	errorMessage, err := db.sealErrorMessage(jobID, dataKey, errorMsg)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE jobs
+		SET status = ?, retry_count = ?, updated_at = ?, leased_until = NULL, leased_by = NULL, error_message = ?
+		WHERE id = ?
+	`, models.StatusFailed, retryCount, time.Now(), errorMessage, jobID)
+	if err != nil {
+		return false, err
+	}
+
+	if err := recordWorkerResult(tx, workerID, true); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	jobsFailed.Inc(queue, tenantID)
+	observeExecution(queue, "failed", startedAt)
+	db.historyFinished(queue, tenantID, true, startedAt)
+	if dlq {
+		jobsDeadLettered.Inc(queue, tenantID)
+	} else {
+		db.NotifyJobReady()
+	}
+	return dlq, nil
+}
+
+// ReleaseJob returns a job leased by workerID to the queue without counting an
+// attempt, used when a worker shuts down before the job finishes
+func (db *DB) ReleaseJob(jobID, workerID string) error {
+	released, err := db.releaseLeases("id = ? AND leased_by = ?", jobID, workerID)
+	if err == nil && released == 0 {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// ReleaseWorkerLeases returns every job still leased by the given workers to
+// the queue
+func (db *DB) ReleaseWorkerLeases(workerIDs []string) error {
+	if len(workerIDs) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(workerIDs))
+	for i, id := range workerIDs {
+		args[i] = id
+	}
+	_, err := db.releaseLeases("leased_by IN ("+placeholders(len(workerIDs))+")", args...)
+	return err
+}
+
+// releaseLeases returns running jobs matching where to pending and reports
+// how many were released
+func (db *DB) releaseLeases(where string, args ...interface{}) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	args = append([]interface{}{models.StatusPending, time.Now(), models.StatusRunning}, args...)
+	res, err := tx.Exec(`
+		UPDATE jobs
+		SET status = ?, updated_at = ?, leased_until = NULL, leased_by = NULL
+		WHERE status = ? AND `+where, args...)
+	if err != nil {
+		return 0, err
+	}
+	released, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec("UPDATE workers SET current_job = NULL WHERE current_job IS NOT NULL AND "+
+		"current_job NOT IN (SELECT id FROM jobs WHERE status = ?)", models.StatusRunning)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	if released > 0 {
+		db.NotifyJobReady()
+	}
+	return released, nil
+}
+
+// ExtendLease pushes out the lease on a running job held by workerID
+func (db *DB) ExtendLease(jobID, workerID string, leaseUntil time.Time) error {
+	res, err := db.Exec(`
+		UPDATE jobs SET leased_until = ?, updated_at = ?
+		WHERE id = ? AND status = ? AND leased_by = ?
+	`, leaseUntil, time.Now(), jobID, models.StatusRunning, workerID)
+	if err != nil {
+		return err
+	}
+	return expectLeaseUpdate(res)
+}
+
+// GetBacklog returns the number of jobs waiting to be leased and when the
+// oldest of them was created. oldest is the zero time if there is no backlog.
+func (db *DB) GetBacklog() (int64, time.Time, error) {
+	var count int64
+	var oldest sql.NullString
+
+	err := db.QueryRow(`
+		SELECT COUNT(*), MIN(created_at) FROM jobs
+		WHERE status = ? OR (status = ? AND retry_count < max_retries)
+	`, models.StatusPending, models.StatusFailed).Scan(&count, &oldest)
+	if err != nil || !oldest.Valid {
+		return count, time.Time{}, err
+	}
+
+	t, err := parseTime(oldest.String)
+	return count, t, err
+}
+
+// GetMetrics retrieves system metrics in a single pass over the jobs, only
+// counting a tenant's jobs unless tenantID is empty
+func (db *DB) GetMetrics(tenantID string) (*models.Metrics, error) {
 	var metrics models.Metrics
 
-	db.QueryRow("SELECT COUNT(*) FROM jobs").Scan(&metrics.TotalJobs)
-	db.QueryRow("SELECT COUNT(*) FROM jobs WHERE status = ?", models.StatusPending).Scan(&metrics.PendingJobs)
-	db.QueryRow("SELECT COUNT(*) FROM jobs WHERE status = ?", models.StatusRunning).Scan(&metrics.RunningJobs)
-	db.QueryRow("SELECT COUNT(*) FROM jobs WHERE status = ?", models.StatusDone).Scan(&metrics.CompletedJobs)
-	db.QueryRow("SELECT COUNT(*) FROM jobs WHERE status = ? AND retry_count < max_retries", models.StatusFailed).Scan(&metrics.FailedJobs)
-	db.QueryRow("SELECT COUNT(*) FROM jobs WHERE status = ? AND retry_count >= max_retries", models.StatusFailed).Scan(&metrics.DLQJobs)
-	db.QueryRow("SELECT COALESCE(SUM(retry_count), 0) FROM jobs").Scan(&metrics.TotalRetries)
+	query := `
+		SELECT COUNT(*),
+			COALESCE(SUM(status = ?), 0),
+			COALESCE(SUM(status = ?), 0),
+			COALESCE(SUM(status = ?), 0),
+			COALESCE(SUM(status = ? AND retry_count < max_retries), 0),
+			COALESCE(SUM(status = ? AND retry_count >= max_retries), 0),
+			COALESCE(SUM(retry_count), 0)
+		FROM jobs`
+	args := []interface{}{models.StatusPending, models.StatusRunning, models.StatusDone, models.StatusFailed, models.StatusFailed}
+	if tenantID != "" {
+		query += " WHERE tenant_id = ?"
+		args = append(args, tenantID)
+	}
+
+	err := db.QueryRow(query, args...).Scan(
+		&metrics.TotalJobs, &metrics.PendingJobs, &metrics.RunningJobs, &metrics.CompletedJobs,
+		&metrics.FailedJobs, &metrics.DLQJobs, &metrics.TotalRetries)
+	if err != nil {
+		return nil, err
+	}
 
 	return &metrics, nil
 }
 
 // Helper functions
 
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanJob scans a row selected with jobColumns
+func scanJob(row rowScanner) (*models.Job, error) {
+	var job models.Job
+	var leasedUntil sql.NullTime
+	var idempotencyKey sql.NullString
+	var errorMessage sql.NullString
+	var leasedBy sql.NullString
+	var payload []byte
+	var contentType string
+	var dataKey int64
+	var errorBytes []byte
+
+	err := row.Scan(&job.ID, &job.TenantID, &payload, &job.Status,
+		&idempotencyKey, &job.RetryCount, &job.MaxRetries,
+		&job.CreatedAt, &job.UpdatedAt, &leasedUntil, &errorBytes, &job.TraceID,
+		&job.Queue, &job.Type, &leasedBy, &job.Traceparent, &job.SchemaVersion, &contentType, &dataKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Encrypted jobs are decrypted by DecryptJob where they are needed
+	if dataKey != 0 {
+		job.ContentType = contentType
+		job.Sealed = &models.SealedJobData{DataKey: dataKey, Payload: payload, ErrorMessage: errorBytes}
+	} else {
+		job.SetPayload(payload, contentType)
+		if errorBytes != nil {
+			errorMessage = sql.NullString{String: string(errorBytes), Valid: true}
+		}
+	}
+
+	if idempotencyKey.Valid {
+		job.IdempotencyKey = idempotencyKey.String
+	}
+	if leasedUntil.Valid {
+		t := leasedUntil.Time
+		job.LeasedUntil = &t
+	}
+	if errorMessage.Valid {
+		job.ErrorMessage = errorMessage.String
+	}
+	if leasedBy.Valid {
+		job.LeasedBy = leasedBy.String
+	}
+
+	return &job, nil
+}
+
 func scanJobs(rows *sql.Rows) ([]models.Job, error) {
 	jobs := []models.Job{}
 	for rows.Next() {
-		var job models.Job
-		var leasedUntil sql.NullTime
-		var idempotencyKey sql.NullString
-		var errorMessage sql.NullString
-
-		err := rows.Scan(&job.ID, &job.TenantID, &job.Payload, &job.Status,
-			&idempotencyKey, &job.RetryCount, &job.MaxRetries,
-			&job.CreatedAt, &job.UpdatedAt, &leasedUntil, &errorMessage, &job.TraceID)
-
+		job, err := scanJob(rows)
 		if err != nil {
 			continue
 		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, nil
+}
 
-		if idempotencyKey.Valid {
-			job.IdempotencyKey = idempotencyKey.String
-		}
-		if leasedUntil.Valid {
-			t := leasedUntil.Time
-			job.LeasedUntil = &t
-		}
-		if errorMessage.Valid {
-			job.ErrorMessage = errorMessage.String
+// recordWorkerResult updates a worker's statistics after it finishes a job
+func recordWorkerResult(tx *sql.Tx, workerID string, failed bool) error {
+	failedInc := 0
+	if failed {
+		failedInc = 1
+	}
+	_, err := tx.Exec(`
+		UPDATE workers
+		SET current_job = NULL, jobs_processed = jobs_processed + 1, jobs_failed = jobs_failed + ?
+		WHERE id = ?
+	`, failedInc, workerID)
+	return err
+}
+
+func expectLeaseUpdate(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// parseTime parses a DATETIME returned by an aggregate, which the driver
+// cannot convert to time.Time because the column type is lost
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(layout, s, time.UTC); err == nil {
+			return t, nil
 		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised timestamp %q", s)
+}
 
-		jobs = append(jobs, job)
+// withParam adds a query parameter to a data source name
+func withParam(dataSourceName, param string) string {
+	if strings.Contains(dataSourceName, "?") {
+		return dataSourceName + "&" + param
 	}
-	return jobs, nil
+	return dataSourceName + "?" + param
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
 }
 
 func nullString(s string) sql.NullString {
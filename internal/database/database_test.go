@@ -0,0 +1,85 @@
+package database
+
+import (
+	"distributed-task-queue/internal/models"
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// newTestDB opens an empty database in a temporary directory
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := New(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+var jobSeq atomic.Int64
+
+// insertJob inserts a pending text job for a tenant, created at created
+func insertJob(t *testing.T, db *DB, tenantID string, created time.Time) *models.Job {
+	t.Helper()
+	job := &models.Job{
+		ID:         fmt.Sprintf("job-%d", jobSeq.Add(1)),
+		TenantID:   tenantID,
+		Status:     models.StatusPending,
+		MaxRetries: 3,
+		CreatedAt:  created,
+		UpdatedAt:  created,
+		Queue:      models.DefaultQueue,
+		Type:       models.DefaultType,
+	}
+	job.SetPayload([]byte("payload"), "")
+	if err := db.InsertJob(job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestInsertJobRejectsDuplicateIdempotencyKey(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	newJob := func(tenantID string) *models.Job {
+		job := &models.Job{
+			ID:             fmt.Sprintf("job-%d", jobSeq.Add(1)),
+			TenantID:       tenantID,
+			Status:         models.StatusPending,
+			IdempotencyKey: "order-1",
+			CreatedAt:      now,
+			UpdatedAt:      now,
+			Queue:          models.DefaultQueue,
+			Type:           models.DefaultType,
+		}
+		job.SetPayload([]byte("payload"), "")
+		return job
+	}
+
+	if err := db.InsertJob(newJob("acme")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertJob(newJob("acme")); err != ErrDuplicateJob {
+		t.Fatalf("inserting a duplicate key: got %v, want ErrDuplicateJob", err)
+	}
+	if err := db.InsertJob(newJob("globex")); err != nil {
+		t.Fatalf("inserting the key for another tenant: %v", err)
+	}
+
+	job, err := db.GetJobByIdempotencyKey("globex", "order-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.TenantID != "globex" {
+		t.Fatalf("looked up a job of %s for globex", job.TenantID)
+	}
+}
@@ -0,0 +1,423 @@
+package database
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/keyring"
+	"distributed-task-queue/internal/models"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SecureDelete returns a data source name that makes SQLite overwrite deleted
+// and updated rows with zeros, so that plaintext replaced by ReencryptJobs
+// does not linger in free pages
+func SecureDelete(dataSourceName string) string {
+	return withParam(dataSourceName, "_secure_delete=on")
+}
+
+// encryption holds the keyring and the data keys unwrapped so far. A data
+// key never changes once created; rotating the master key only re-wraps it.
+type encryption struct {
+	keyring *keyring.Keyring
+
+	mu   sync.Mutex
+	keys map[int64][]byte
+}
+
+// SetKeyring turns on encryption at rest: the payloads and error messages of
+// jobs are encrypted with a data key of their tenant, wrapped by the
+// keyring's master keys. Jobs stored in plaintext stay readable until
+// ReencryptJobs encrypts them.
+func (db *DB) SetKeyring(kr *keyring.Keyring) {
+	db.enc = &encryption{keyring: kr, keys: make(map[int64][]byte)}
+}
+
+// HasEncryptedJobs reports whether any job is encrypted, so that a server
+// without a keyring can refuse to start rather than fail every job
+func (db *DB) HasEncryptedJobs() (bool, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM (SELECT 1 FROM jobs WHERE data_key != 0 LIMIT 1)").Scan(&n)
+	return n > 0, err
+}
+
+// dataKeyAAD binds a wrapped data key to its tenant
+func dataKeyAAD(tenantID string) []byte {
+	return []byte("data_key:" + tenantID)
+}
+
+// jobAAD binds an encrypted column value to its job and column
+func jobAAD(jobID, column string) []byte {
+	return []byte("job:" + jobID + ":" + column)
+}
+
+// currentDataKey returns the newest data key of a tenant, creating the
+// tenant's first one
+func (db *DB) currentDataKey(tenantID string) (int64, []byte, error) {
+	var id int64
+	var masterKey string
+	var wrapped []byte
+	err := db.QueryRow(
+		"SELECT id, master_key, wrapped FROM data_keys WHERE tenant_id = ? ORDER BY id DESC LIMIT 1",
+		tenantID,
+	).Scan(&id, &masterKey, &wrapped)
+	if err == sql.ErrNoRows {
+		return db.createDataKey(tenantID)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return db.enc.unwrap(id, tenantID, masterKey, wrapped)
+}
+
+// createDataKey adds a data key for a tenant, wrapped with the primary master
+// key
+func (db *DB) createDataKey(tenantID string) (int64, []byte, error) {
+	key, err := keyring.NewKey()
+	if err != nil {
+		return 0, nil, err
+	}
+	masterKey, wrapped, err := db.enc.keyring.Wrap(key, dataKeyAAD(tenantID))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	res, err := db.Exec(
+		"INSERT INTO data_keys (tenant_id, master_key, wrapped, created_at) VALUES (?, ?, ?, ?)",
+		tenantID, masterKey, wrapped, time.Now(),
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	db.enc.mu.Lock()
+	db.enc.keys[id] = key
+	db.enc.mu.Unlock()
+	return id, key, nil
+}
+
+// dataKey returns a data key by ID
+func (db *DB) dataKey(id int64) ([]byte, error) {
+	if db.enc == nil {
+		return nil, errors.New("job is encrypted but no keyring is configured")
+	}
+
+	db.enc.mu.Lock()
+	key, ok := db.enc.keys[id]
+	db.enc.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var tenantID, masterKey string
+	var wrapped []byte
+	err := db.QueryRow("SELECT tenant_id, master_key, wrapped FROM data_keys WHERE id = ?", id).
+		Scan(&tenantID, &masterKey, &wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("data key %d: %w", id, err)
+	}
+	_, key, err = db.enc.unwrap(id, tenantID, masterKey, wrapped)
+	return key, err
+}
+
+// unwrap returns a data key, unwrapping it with its master key the first
+// time it is used
+func (e *encryption) unwrap(id int64, tenantID, masterKey string, wrapped []byte) (int64, []byte, error) {
+	e.mu.Lock()
+	key, ok := e.keys[id]
+	e.mu.Unlock()
+	if ok {
+		return id, key, nil
+	}
+
+	key, err := e.keyring.Unwrap(masterKey, wrapped, dataKeyAAD(tenantID))
+	if err != nil {
+		return 0, nil, fmt.Errorf("data key %d: %w", id, err)
+	}
+
+	e.mu.Lock()
+	e.keys[id] = key
+	e.mu.Unlock()
+	return id, key, nil
+}
+
+// DecryptJob decrypts the payload and error message of a job read from the
+// database. Jobs stored in plaintext are left as they are. Only workers and
+// API reads that checked the caller's access to the job's tenant decrypt.
+func (db *DB) DecryptJob(job *models.Job) error {
+	if job.Sealed == nil {
+		return nil
+	}
+	key, err := db.dataKey(job.Sealed.DataKey)
+	if err != nil {
+		return err
+	}
+
+	payload, err := keyring.Open(key, job.Sealed.Payload, jobAAD(job.ID, "payload"))
+	if err != nil {
+		return fmt.Errorf("payload: %w", err)
+	}
+	var errorMessage []byte
+	if job.Sealed.ErrorMessage != nil {
+		errorMessage, err = keyring.Open(key, job.Sealed.ErrorMessage, jobAAD(job.ID, "error_message"))
+		if err != nil {
+			return fmt.Errorf("error message: %w", err)
+		}
+	}
+
+	job.SetPayload(payload, job.ContentType)
+	job.ErrorMessage = string(errorMessage)
+	job.Sealed = nil
+	return nil
+}
+
+// OpenLeasedJob decrypts a job leased by workerID. A job that cannot be
+// decrypted is failed, so that it is retried or moved to the DLQ rather than
+// leased over and over.
+func (db *DB) OpenLeasedJob(job *models.Job, workerID string) error {
+	err := db.DecryptJob(job)
+	if err == nil {
+		return nil
+	}
+	if _, nackErr := db.NackJob(job.ID, workerID, "Failed to decrypt job: "+err.Error()); nackErr != nil {
+		return errors.Join(err, nackErr)
+	}
+	return err
+}
+
+// sealErrorMessage returns the value stored as the error message of a job
+// whose data is encrypted with dataKey, 0 for plaintext
+func (db *DB) sealErrorMessage(jobID string, dataKey int64, errorMsg string) (interface{}, error) {
+	if dataKey == 0 {
+		return errorMsg, nil
+	}
+	key, err := db.dataKey(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return keyring.Seal(key, []byte(errorMsg), jobAAD(jobID, "error_message"))
+}
+
+// RotateDataKeys adds a data key for every tenant that has one, wrapped with
+// the primary master key, and re-wraps the older data keys with it, so that
+// no data key needs an older master key any more. New jobs are encrypted with
+// the new data keys at once; ReencryptJobs moves the others over. It returns
+// the number of data keys added.
+func (db *DB) RotateDataKeys() (int, error) {
+	keys, err := db.ListDataKeys()
+	if err != nil {
+		return 0, err
+	}
+
+	primary := db.enc.keyring.Primary()
+	rotated := 0
+	for _, k := range keys {
+		if k.Current {
+			if _, _, err := db.createDataKey(k.TenantID); err != nil {
+				return rotated, err
+			}
+			rotated++
+		}
+		if k.MasterKey == primary {
+			continue
+		}
+
+		key, err := db.dataKey(k.ID)
+		if err != nil {
+			return rotated, err
+		}
+		masterKey, wrapped, err := db.enc.keyring.Wrap(key, dataKeyAAD(k.TenantID))
+		if err != nil {
+			return rotated, err
+		}
+		if _, err := db.Exec("UPDATE data_keys SET master_key = ?, wrapped = ? WHERE id = ?", masterKey, wrapped, k.ID); err != nil {
+			return rotated, err
+		}
+	}
+	return rotated, nil
+}
+
+// ListDataKeys returns all data keys with the number of jobs encrypted with
+// each, oldest first
+func (db *DB) ListDataKeys() ([]models.DataKey, error) {
+	rows, err := db.Query(`
+		SELECT d.id, d.tenant_id, d.master_key, d.created_at,
+			d.id = (SELECT MAX(id) FROM data_keys c WHERE c.tenant_id = d.tenant_id),
+			(SELECT COUNT(*) FROM jobs j WHERE j.data_key = d.id)
+		FROM data_keys d
+		ORDER BY d.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.DataKey{}
+	for rows.Next() {
+		var k models.DataKey
+		if err := rows.Scan(&k.ID, &k.TenantID, &k.MasterKey, &k.CreatedAt, &k.Current, &k.Jobs); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// NewestDataKey returns the ID of the most recently created data key, 0 if
+// there is none
+func (db *DB) NewestDataKey() (int64, error) {
+	var id sql.NullInt64
+	err := db.QueryRow("SELECT MAX(id) FROM data_keys").Scan(&id)
+	return id.Int64, err
+}
+
+// CountPlaintextJobs returns the number of jobs stored unencrypted
+func (db *DB) CountPlaintextJobs() (int64, error) {
+	var n int64
+	err := db.QueryRow("SELECT COUNT(*) FROM jobs WHERE data_key = 0").Scan(&n)
+	return n, err
+}
+
+// staleJob is a job that is not encrypted with its tenant's newest data key
+type staleJob struct {
+	rowID        int64
+	id           string
+	tenantID     string
+	dataKey      int64
+	payload      []byte
+	errorMessage []byte // Nil when NULL
+}
+
+// ReencryptJobs encrypts every job that is not encrypted with its tenant's
+// newest data key with that key, batch jobs at a time. That includes jobs
+// stored in plaintext before encryption was turned on. A job whose error
+// message changes while it is re-encrypted is left for the next call. It
+// returns the number of jobs re-encrypted and of jobs that could not be
+// decrypted.
+func (db *DB) ReencryptJobs(batch int) (int, int, error) {
+	if db.enc == nil {
+		return 0, 0, errors.New("no keyring is configured")
+	}
+
+	done, failed := 0, 0
+	var after int64
+	for {
+		jobs, err := db.staleJobs(after, batch)
+		if err != nil {
+			return done, failed, err
+		}
+
+		for _, j := range jobs {
+			after = j.rowID
+			ok, err := db.reencrypt(j)
+			if err != nil {
+				var unreadable decryptError
+				if !errors.As(err, &unreadable) {
+					return done, failed, err
+				}
+				failed++
+			} else if ok {
+				done++
+			}
+		}
+
+		if len(jobs) < batch {
+			return done, failed, nil
+		}
+	}
+}
+
+// staleJobs returns up to limit jobs after rowid after that are not
+// encrypted with their tenant's newest data key
+func (db *DB) staleJobs(after int64, limit int) ([]staleJob, error) {
+	rows, err := db.Query(`
+		SELECT j.rowid, j.id, j.tenant_id, j.data_key, j.payload, j.error_message
+		FROM jobs j
+		WHERE j.rowid > ?
+			AND j.data_key != COALESCE((SELECT MAX(d.id) FROM data_keys d WHERE d.tenant_id = j.tenant_id), -1)
+		ORDER BY j.rowid
+		LIMIT ?
+	`, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []staleJob
+	for rows.Next() {
+		var j staleJob
+		if err := rows.Scan(&j.rowID, &j.id, &j.tenantID, &j.dataKey, &j.payload, &j.errorMessage); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// decryptError is a job that could not be decrypted for re-encryption
+type decryptError struct {
+	err error
+}
+
+func (e decryptError) Error() string { return e.err.Error() }
+func (e decryptError) Unwrap() error { return e.err }
+
+// reencrypt encrypts a job with its tenant's newest data key. It reports
+// false if the job changed since it was read.
+func (db *DB) reencrypt(j staleJob) (bool, error) {
+	// Values are compared as they are stored: TEXT in plaintext, BLOB
+	// encrypted
+	var oldError interface{}
+	payload, errorMessage := j.payload, j.errorMessage
+	if j.dataKey == 0 {
+		if errorMessage != nil {
+			oldError = string(errorMessage)
+		}
+	} else {
+		if errorMessage != nil {
+			oldError = errorMessage
+		}
+		key, err := db.dataKey(j.dataKey)
+		if err != nil {
+			return false, decryptError{fmt.Errorf("job %s: %w", j.id, err)}
+		}
+		if payload, err = keyring.Open(key, payload, jobAAD(j.id, "payload")); err != nil {
+			return false, decryptError{fmt.Errorf("job %s payload: %w", j.id, err)}
+		}
+		if errorMessage != nil {
+			if errorMessage, err = keyring.Open(key, errorMessage, jobAAD(j.id, "error_message")); err != nil {
+				return false, decryptError{fmt.Errorf("job %s error message: %w", j.id, err)}
+			}
+		}
+	}
+
+	dataKey, key, err := db.currentDataKey(j.tenantID)
+	if err != nil {
+		return false, err
+	}
+	sealedPayload, err := keyring.Seal(key, payload, jobAAD(j.id, "payload"))
+	if err != nil {
+		return false, err
+	}
+	var sealedError interface{}
+	if errorMessage != nil {
+		if sealedError, err = keyring.Seal(key, errorMessage, jobAAD(j.id, "error_message")); err != nil {
+			return false, err
+		}
+	}
+
+	res, err := db.Exec(`
+		UPDATE jobs SET payload = ?, error_message = ?, data_key = ?
+		WHERE id = ? AND data_key = ? AND error_message IS ?
+	`, sealedPayload, sealedError, dataKey, j.id, j.dataKey, oldError)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
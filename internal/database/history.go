@@ -0,0 +1,507 @@
+package database
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/metrics"
+	"distributed-task-queue/internal/models"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolutions of the metrics history. Rows start at minute resolution and
+// are merged into hours, then days, as they age.
+const (
+	HistoryMinute = time.Minute
+	HistoryHour   = time.Hour
+	HistoryDay    = 24 * time.Hour
+)
+
+// How long rows are kept at each resolution before being downsampled to the
+// next one, or deleted for daily rows
+const (
+	minuteHistoryRetention = 48 * time.Hour
+	hourHistoryRetention   = 30 * 24 * time.Hour
+	dayHistoryRetention    = 365 * 24 * time.Hour
+)
+
+// historyBuckets are the upper bounds of the wait and run time histograms
+// stored with each row. Stored rows depend on them, so they must not change.
+var historyBuckets = metrics.DurationBuckets
+
+// historyKey identifies a row of the metrics history
+type historyKey struct {
+	Start    time.Time
+	Queue    string
+	TenantID string
+}
+
+// rollup holds the statistics of one row
+type rollup struct {
+	enqueued, completed, failed int64
+	wait, run                   []int64 // Histogram counts over historyBuckets plus overflow
+}
+
+func newRollup() *rollup {
+	return &rollup{
+		wait: make([]int64, len(historyBuckets)+1),
+		run:  make([]int64, len(historyBuckets)+1),
+	}
+}
+
+func (r *rollup) merge(o *rollup) {
+	r.enqueued += o.enqueued
+	r.completed += o.completed
+	r.failed += o.failed
+	for i := range r.wait {
+		r.wait[i] += o.wait[i]
+		r.run[i] += o.run[i]
+	}
+}
+
+// history collects the statistics of the current minutes in memory until
+// they are flushed to the metrics_history table
+type history struct {
+	mu      sync.Mutex
+	pending map[historyKey]*rollup
+}
+
+func newHistory() *history {
+	return &history{pending: make(map[historyKey]*rollup)}
+}
+
+// record updates the row of queue and tenantID for the current minute
+func (h *history) record(queue, tenantID string, update func(r *rollup)) {
+	key := historyKey{Start: time.Now().UTC().Truncate(HistoryMinute), Queue: queue, TenantID: tenantID}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.pending[key]
+	if !ok {
+		r = newRollup()
+		h.pending[key] = r
+	}
+	update(r)
+}
+
+// take removes and returns the rows that started before cutoff
+func (h *history) take(cutoff time.Time) map[historyKey]*rollup {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	taken := make(map[historyKey]*rollup)
+	for key, r := range h.pending {
+		if key.Start.Before(cutoff) {
+			taken[key] = r
+			delete(h.pending, key)
+		}
+	}
+	return taken
+}
+
+func observeBucket(counts []int64, seconds float64) {
+	i := sort.SearchFloat64s(historyBuckets, seconds)
+	counts[i]++
+}
+
+func (db *DB) historyEnqueued(queue, tenantID string) {
+	db.history.record(queue, tenantID, func(r *rollup) { r.enqueued++ })
+}
+
+func (db *DB) historyWaited(queue, tenantID string, wait time.Duration) {
+	db.history.record(queue, tenantID, func(r *rollup) { observeBucket(r.wait, wait.Seconds()) })
+}
+
+func (db *DB) historyFinished(queue, tenantID string, failed bool, startedAt sql.NullTime) {
+	db.history.record(queue, tenantID, func(r *rollup) {
+		if failed {
+			r.failed++
+		} else {
+			r.completed++
+		}
+		if startedAt.Valid {
+			observeBucket(r.run, time.Since(startedAt.Time).Seconds())
+		}
+	})
+}
+
+// FlushMetricsHistory writes the minutes that ended before now to the
+// metrics history, adding to rows other processes sharing the database wrote.
+// Pass a time in the future to also flush the current minute, e.g. on
+// shutdown.
+func (db *DB) FlushMetricsHistory(now time.Time) error {
+	rows := db.history.take(now.UTC().Truncate(HistoryMinute))
+	if len(rows) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for key, r := range rows {
+		if err := mergeHistoryRow(tx, HistoryMinute, key, r); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// DownsampleMetricsHistory merges minute rows older than
+// minuteHistoryRetention into hourly rows and hourly rows older than
+// hourHistoryRetention into daily rows, and deletes daily rows older than
+// dayHistoryRetention
+func (db *DB) DownsampleMetricsHistory(now time.Time) error {
+	now = now.UTC()
+	if err := db.downsampleHistory(HistoryMinute, HistoryHour, now.Add(-minuteHistoryRetention)); err != nil {
+		return err
+	}
+	if err := db.downsampleHistory(HistoryHour, HistoryDay, now.Add(-hourHistoryRetention)); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM metrics_history WHERE resolution = ? AND bucket_start < ?",
+		int64(HistoryDay.Seconds()), now.Add(-dayHistoryRetention))
+	return err
+}
+
+// downsampleHistory merges the rows at resolution from that start before
+// cutoff into rows at resolution to
+func (db *DB) downsampleHistory(from, to time.Duration, cutoff time.Time) error {
+	cutoff = cutoff.Truncate(to)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT bucket_start, queue, tenant_id, enqueued, completed, failed, wait_hist, run_hist
+		FROM metrics_history WHERE resolution = ? AND bucket_start < ?
+	`, int64(from.Seconds()), cutoff)
+	if err != nil {
+		return err
+	}
+	merged, err := scanHistoryRows(rows, to)
+	if err != nil {
+		return err
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+
+	for key, r := range merged {
+		if err := mergeHistoryRow(tx, to, key, r); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM metrics_history WHERE resolution = ? AND bucket_start < ?",
+		int64(from.Seconds()), cutoff); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// mergeHistoryRow adds r to the row of key at resolution
+func mergeHistoryRow(tx *sql.Tx, resolution time.Duration, key historyKey, r *rollup) error {
+	res := int64(resolution.Seconds())
+
+	existing := newRollup()
+	var waitHist, runHist string
+	err := tx.QueryRow(`
+		SELECT enqueued, completed, failed, wait_hist, run_hist FROM metrics_history
+		WHERE resolution = ? AND bucket_start = ? AND queue = ? AND tenant_id = ?
+	`, res, key.Start, key.Queue, key.TenantID).Scan(
+		&existing.enqueued, &existing.completed, &existing.failed, &waitHist, &runHist)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return err
+	default:
+		if err := parseHistogram(waitHist, existing.wait); err != nil {
+			return err
+		}
+		if err := parseHistogram(runHist, existing.run); err != nil {
+			return err
+		}
+	}
+	existing.merge(r)
+
+	_, err = tx.Exec(`
+		INSERT INTO metrics_history (resolution, bucket_start, queue, tenant_id,
+			enqueued, completed, failed, wait_hist, run_hist)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (resolution, bucket_start, queue, tenant_id) DO UPDATE SET
+			enqueued = excluded.enqueued, completed = excluded.completed, failed = excluded.failed,
+			wait_hist = excluded.wait_hist, run_hist = excluded.run_hist
+	`, res, key.Start, key.Queue, key.TenantID, existing.enqueued, existing.completed, existing.failed,
+		formatHistogram(existing.wait), formatHistogram(existing.run))
+	return err
+}
+
+// scanHistoryRows reads rows of metrics_history and merges them into rows
+// starting at multiples of step
+func scanHistoryRows(rows *sql.Rows, step time.Duration) (map[historyKey]*rollup, error) {
+	defer rows.Close()
+
+	merged := make(map[historyKey]*rollup)
+	for rows.Next() {
+		var key historyKey
+		var waitHist, runHist string
+		r := newRollup()
+		if err := rows.Scan(&key.Start, &key.Queue, &key.TenantID,
+			&r.enqueued, &r.completed, &r.failed, &waitHist, &runHist); err != nil {
+			return nil, err
+		}
+		if err := parseHistogram(waitHist, r.wait); err != nil {
+			return nil, err
+		}
+		if err := parseHistogram(runHist, r.run); err != nil {
+			return nil, err
+		}
+
+		key.Start = key.Start.UTC().Truncate(step)
+		if m, ok := merged[key]; ok {
+			m.merge(r)
+		} else {
+			merged[key] = r
+		}
+	}
+	return merged, rows.Err()
+}
+
+// GetMetricsHistory returns the statistics between from and to in points of
+// step, one series per queue and tenant plus their total. Rows are merged
+// into the point they start in, so step should not be finer than the
+// resolution of the rows in range; see HistoryResolution.
+func (db *DB) GetMetricsHistory(from, to time.Time, step time.Duration, tenantID string) (*models.MetricsHistory, error) {
+	from, to = from.UTC().Truncate(step), to.UTC()
+
+	merged, err := db.historyRows(from, to, step, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	type seriesKey struct{ queue, tenantID string }
+	bySeries := make(map[seriesKey]map[time.Time]*rollup)
+	total := make(map[time.Time]*rollup)
+	for key, r := range merged {
+		sk := seriesKey{key.Queue, key.TenantID}
+		if bySeries[sk] == nil {
+			bySeries[sk] = make(map[time.Time]*rollup)
+		}
+		bySeries[sk][key.Start] = r
+
+		if t, ok := total[key.Start]; ok {
+			t.merge(r)
+		} else {
+			t := newRollup()
+			t.merge(r)
+			total[key.Start] = t
+		}
+	}
+
+	h := &models.MetricsHistory{
+		From:        from,
+		To:          to,
+		StepSeconds: int64(step.Seconds()),
+		Total:       historyPoints(total),
+		Series:      []models.MetricsSeries{},
+	}
+	for sk, points := range bySeries {
+		h.Series = append(h.Series, models.MetricsSeries{
+			Queue:    sk.queue,
+			TenantID: sk.tenantID,
+			Points:   historyPoints(points),
+		})
+	}
+	sort.Slice(h.Series, func(i, j int) bool {
+		a, b := h.Series[i], h.Series[j]
+		return a.Queue < b.Queue || (a.Queue == b.Queue && a.TenantID < b.TenantID)
+	})
+	return h, nil
+}
+
+// SummarizeMetricsHistory returns the statistics between from and to as one
+// point per queue and tenant. Series are merged across queues unless byQueue
+// and across tenants unless byTenant, leaving Queue or TenantID empty. from
+// should be a multiple of the resolution of the rows in range.
+func (db *DB) SummarizeMetricsHistory(from, to time.Time, byQueue, byTenant bool) ([]models.MetricsSeries, error) {
+	from, to = from.UTC(), to.UTC()
+
+	rows, err := db.historyRows(from, to, HistoryMinute, "")
+	if err != nil {
+		return nil, err
+	}
+
+	merged := make(map[historyKey]*rollup)
+	for key, r := range rows {
+		key.Start = from
+		if !byQueue {
+			key.Queue = ""
+		}
+		if !byTenant {
+			key.TenantID = ""
+		}
+		if m, ok := merged[key]; ok {
+			m.merge(r)
+		} else {
+			merged[key] = r
+		}
+	}
+
+	series := make([]models.MetricsSeries, 0, len(merged))
+	for key, r := range merged {
+		series = append(series, models.MetricsSeries{
+			Queue:    key.Queue,
+			TenantID: key.TenantID,
+			Points:   historyPoints(map[time.Time]*rollup{from: r}),
+		})
+	}
+	return series, nil
+}
+
+// historyRows returns the rows between from and to, including those not
+// flushed yet, merged into rows starting at multiples of step
+func (db *DB) historyRows(from, to time.Time, step time.Duration, tenantID string) (map[historyKey]*rollup, error) {
+	query := `SELECT bucket_start, queue, tenant_id, enqueued, completed, failed, wait_hist, run_hist
+		FROM metrics_history WHERE bucket_start >= ? AND bucket_start < ?`
+	args := []interface{}{from, to}
+	if tenantID != "" {
+		query += " AND tenant_id = ?"
+		args = append(args, tenantID)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := scanHistoryRows(rows, step)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, r := range db.history.snapshot() {
+		if key.Start.Before(from) || !key.Start.Before(to) || (tenantID != "" && key.TenantID != tenantID) {
+			continue
+		}
+		key.Start = key.Start.Truncate(step)
+		if m, ok := merged[key]; ok {
+			m.merge(r)
+		} else {
+			merged[key] = r
+		}
+	}
+	return merged, nil
+}
+
+// HistoryResolution returns the finest resolution at which statistics
+// starting at from are still kept
+func HistoryResolution(from, now time.Time) time.Duration {
+	switch {
+	case from.Before(now.Add(-hourHistoryRetention)):
+		return HistoryDay
+	case from.Before(now.Add(-minuteHistoryRetention)):
+		return HistoryHour
+	}
+	return HistoryMinute
+}
+
+// snapshot returns a copy of the rows not flushed yet
+func (h *history) snapshot() map[historyKey]*rollup {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rows := make(map[historyKey]*rollup, len(h.pending))
+	for key, r := range h.pending {
+		c := newRollup()
+		c.merge(r)
+		rows[key] = c
+	}
+	return rows
+}
+
+// historyPoints converts rows keyed by start time to points in time order
+func historyPoints(rows map[time.Time]*rollup) []models.MetricsPoint {
+	points := make([]models.MetricsPoint, 0, len(rows))
+	for start, r := range rows {
+		points = append(points, models.MetricsPoint{
+			Time:      start,
+			Enqueued:  r.enqueued,
+			Completed: r.completed,
+			Failed:    r.failed,
+			WaitP50:   quantile(r.wait, 0.50),
+			WaitP95:   quantile(r.wait, 0.95),
+			WaitP99:   quantile(r.wait, 0.99),
+			RunP50:    quantile(r.run, 0.50),
+			RunP95:    quantile(r.run, 0.95),
+			RunP99:    quantile(r.run, 0.99),
+		})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+	return points
+}
+
+// quantile estimates the q-quantile in seconds of a histogram over
+// historyBuckets by interpolating within the bucket it falls in, like
+// Prometheus' histogram_quantile. It returns nil for an empty histogram.
+func quantile(counts []int64, q float64) *float64 {
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+	if total == 0 {
+		return nil
+	}
+
+	rank := q * float64(total)
+	var cumulative int64
+	for i, c := range counts {
+		if float64(cumulative+c) < rank {
+			cumulative += c
+			continue
+		}
+		if i == len(historyBuckets) {
+			// Beyond the last bound; the bound is the best estimate
+			v := historyBuckets[i-1]
+			return &v
+		}
+		lower := 0.0
+		if i > 0 {
+			lower = historyBuckets[i-1]
+		}
+		v := lower + (historyBuckets[i]-lower)*(rank-float64(cumulative))/float64(c)
+		v = math.Round(v*1e4) / 1e4
+		return &v
+	}
+	return nil
+}
+
+func formatHistogram(counts []int64) string {
+	parts := make([]string, len(counts))
+	for i, c := range counts {
+		parts[i] = strconv.FormatInt(c, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseHistogram(s string, counts []int64) error {
+	parts := strings.Split(s, ",")
+	if len(parts) != len(counts) {
+		return fmt.Errorf("histogram has %d buckets, want %d", len(parts), len(counts))
+	}
+	for i, p := range parts {
+		c, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return err
+		}
+		counts[i] = c
+	}
+	return nil
+}
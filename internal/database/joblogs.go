@@ -0,0 +1,184 @@
+package database
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/keyring"
+	"distributed-task-queue/internal/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultMaxLogLines is how many log lines are kept per job unless
+// SetMaxLogLines is called
+const DefaultMaxLogLines = 1000
+
+// maxLogMessageBytes caps the message of a single log line
+const maxLogMessageBytes = 8 << 10
+
+// SetMaxLogLines sets how many log lines are kept per job. Lines appended
+// past the cap are dropped.
+func (db *DB) SetMaxLogLines(n int) {
+	db.logsMu.Lock()
+	defer db.logsMu.Unlock()
+	db.maxLogLines = n
+}
+
+// JobLogsAppended returns a channel that is closed the next time lines are
+// appended to the log of any job
+func (db *DB) JobLogsAppended() <-chan struct{} {
+	db.logsMu.Lock()
+	defer db.logsMu.Unlock()
+	return db.logsAppended
+}
+
+// AppendJobLogs appends lines to the log of a job leased by workerID. Once
+// the job has its maximum number of lines, the last one is replaced with a
+// note that the log was truncated and later lines are dropped. The lines of
+// an encrypted job are encrypted with its data key.
+func (db *DB) AppendJobLogs(jobID, workerID string, lines []models.JobLogLine) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	db.logsMu.Lock()
+	maxLines := db.maxLogLines
+	db.logsMu.Unlock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	var dataKey int64
+	err = tx.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM job_logs WHERE job_id = jobs.id), data_key FROM jobs
+		WHERE id = ? AND status = ? AND leased_by = ?
+	`, jobID, models.StatusRunning, workerID).Scan(&count, &dataKey)
+	if err == sql.ErrNoRows {
+		return ErrLeaseLost
+	}
+	if err != nil {
+		return err
+	}
+	var key []byte
+	if dataKey != 0 {
+		if key, err = db.dataKey(dataKey); err != nil {
+			return err
+		}
+	}
+
+	room := maxLines - count
+	if room <= 0 {
+		return nil
+	}
+	if len(lines) > room {
+		last := lines[room-1]
+		lines = append(lines[:room-1:room-1], models.JobLogLine{
+			Attempt: last.Attempt,
+			Time:    last.Time,
+			Level:   "WARN",
+			Message: fmt.Sprintf("Log truncated at %d lines", maxLines),
+		})
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO job_logs (job_id, attempt, logged_at, level, message, attrs, data_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, l := range lines {
+		msg := l.Message
+		if len(msg) > maxLogMessageBytes {
+			msg = strings.ToValidUTF8(msg[:maxLogMessageBytes], "")
+		}
+		var message, attrs interface{} = msg, nullJSON(l.Attrs)
+		if key != nil {
+			if message, err = keyring.Seal(key, []byte(msg), jobAAD(jobID, "log_message")); err != nil {
+				return err
+			}
+			if len(l.Attrs) > 0 {
+				if attrs, err = keyring.Seal(key, l.Attrs, jobAAD(jobID, "log_attrs")); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := stmt.Exec(jobID, l.Attempt, l.Time, l.Level, message, attrs, dataKey); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	db.logsMu.Lock()
+	close(db.logsAppended)
+	db.logsAppended = make(chan struct{})
+	db.logsMu.Unlock()
+	return nil
+}
+
+// ListJobLogs retrieves up to limit log lines of a job with IDs greater than
+// afterID, oldest first, decrypting encrypted lines
+func (db *DB) ListJobLogs(jobID string, afterID int64, limit int) ([]models.JobLogLine, error) {
+	rows, err := db.Query(`
+		SELECT id, attempt, logged_at, level, message, attrs, data_key FROM job_logs
+		WHERE job_id = ? AND id > ?
+		ORDER BY id LIMIT ?
+	`, jobID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []models.JobLogLine{}
+	for rows.Next() {
+		var l models.JobLogLine
+		var message, attrs []byte
+		var dataKey int64
+		if err := rows.Scan(&l.ID, &l.Attempt, &l.Time, &l.Level, &message, &attrs, &dataKey); err != nil {
+			return nil, err
+		}
+		if dataKey != 0 {
+			if message, attrs, err = db.openLogLine(jobID, dataKey, message, attrs); err != nil {
+				return nil, fmt.Errorf("log line %d: %w", l.ID, err)
+			}
+		}
+		l.Message, l.Attrs = string(message), attrs
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// openLogLine decrypts the message and attributes of a log line of a job
+func (db *DB) openLogLine(jobID string, dataKey int64, message, attrs []byte) ([]byte, []byte, error) {
+	key, err := db.dataKey(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if message, err = keyring.Open(key, message, jobAAD(jobID, "log_message")); err != nil {
+		return nil, nil, err
+	}
+	if attrs != nil {
+		if attrs, err = keyring.Open(key, attrs, jobAAD(jobID, "log_attrs")); err != nil {
+			return nil, nil, err
+		}
+	}
+	return message, attrs, nil
+}
+
+// DeleteJobLogsBefore removes log lines logged before cutoff
+func (db *DB) DeleteJobLogsBefore(cutoff time.Time) (int64, error) {
+	res, err := db.Exec("DELETE FROM job_logs WHERE logged_at < ?", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
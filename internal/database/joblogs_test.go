@@ -0,0 +1,47 @@
+package database
+
+import (
+	"bytes"
+	"distributed-task-queue/internal/keyring"
+	"distributed-task-queue/internal/models"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJobLogsOfEncryptedJobs(t *testing.T) {
+	db := newTestDB(t)
+	kr, err := keyring.Create(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetKeyring(kr)
+
+	job := insertJob(t, db, "acme", time.Now())
+	if job := lease(t, db); job == nil {
+		t.Fatal("no job to lease")
+	}
+	line := models.JobLogLine{Attempt: 1, Time: time.Now(), Level: "INFO",
+		Message: "charged card 4242", Attrs: []byte(`{"card":"4242"}`)}
+	if err := db.AppendJobLogs(job.ID, testWorker, []models.JobLogLine{line}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Stored encrypted
+	var message, attrs []byte
+	if err := db.QueryRow("SELECT message, attrs FROM job_logs WHERE job_id = ?", job.ID).Scan(&message, &attrs); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(message, []byte("4242")) || bytes.Contains(attrs, []byte("4242")) {
+		t.Fatalf("log line stored in plaintext: %q %q", message, attrs)
+	}
+
+	// Read decrypted
+	lines, err := db.ListJobLogs(job.ID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || lines[0].Message != line.Message || string(lines[0].Attrs) != string(line.Attrs) {
+		t.Fatalf("read %+v, want %+v", lines, line)
+	}
+}
@@ -0,0 +1,67 @@
+package database
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/metrics"
+	"distributed-task-queue/internal/models"
+	"time"
+)
+
+// Job lifecycle metrics, counted as jobs change state in this process
+var (
+	jobsEnqueued = metrics.Default.Counter("taskq_jobs_enqueued_total",
+		"Jobs submitted.", "queue", "tenant")
+	jobsCompleted = metrics.Default.Counter("taskq_jobs_completed_total",
+		"Jobs that finished successfully.", "queue", "tenant")
+	jobsFailed = metrics.Default.Counter("taskq_jobs_failed_total",
+		"Failed job attempts, including those moved to the DLQ.", "queue", "tenant")
+	jobsDeadLettered = metrics.Default.Counter("taskq_jobs_dead_lettered_total",
+		"Jobs moved to the DLQ after using up their retries.", "queue", "tenant")
+	jobsRetried = metrics.Default.Counter("taskq_jobs_retried_total",
+		"Failed jobs leased again for another attempt.", "queue", "tenant")
+	leaseExpirations = metrics.Default.Counter("taskq_lease_expirations_total",
+		"Running jobs taken back from their worker, because the lease expired or the worker stopped heartbeating.", "reason")
+
+	jobExecution = metrics.Default.Histogram("taskq_job_execution_seconds",
+		"Time from leasing a job to its ack or nack.", metrics.DurationBuckets, "queue", "outcome")
+	jobQueueWait = metrics.Default.Histogram("taskq_job_queue_wait_seconds",
+		"Time a job waited to be leased since it was queued or last failed.", metrics.DurationBuckets, "queue")
+)
+
+// observeExecution records how long an attempt ran, if it is known when it
+// started
+func observeExecution(queue, outcome string, startedAt sql.NullTime) {
+	if startedAt.Valid {
+		jobExecution.Observe(time.Since(startedAt.Time).Seconds(), queue, outcome)
+	}
+}
+
+// GetQueueDepth counts the jobs that are not done by status, queue and
+// tenant. Failed jobs awaiting a retry have status failed and those that used
+// up their retries dlq.
+func (db *DB) GetQueueDepth() ([]metrics.Sample, error) {
+	rows, err := db.Query(`
+		SELECT CASE WHEN status = ? AND retry_count >= max_retries THEN 'dlq' ELSE status END,
+			queue, tenant_id, COUNT(*)
+		FROM jobs WHERE status != ?
+		GROUP BY 1, queue, tenant_id
+	`, models.StatusFailed, models.StatusDone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := []metrics.Sample{}
+	for rows.Next() {
+		var status, queue, tenant string
+		var count int64
+		if err := rows.Scan(&status, &queue, &tenant, &count); err != nil {
+			return nil, err
+		}
+		samples = append(samples, metrics.Sample{
+			LabelValues: []string{status, queue, tenant},
+			Value:       float64(count),
+		})
+	}
+	return samples, rows.Err()
+}
@@ -0,0 +1,281 @@
+package database
+
+import "fmt"
+
+// migrations are applied in order on top of the base schema. Entry i brings
+// the schema to version i+1, which is recorded in PRAGMA user_version.
+var migrations = []string{
+	// 1: job routing, lease ownership and remote worker registration
+	`
+	ALTER TABLE jobs ADD COLUMN queue TEXT NOT NULL DEFAULT 'default';
+	ALTER TABLE jobs ADD COLUMN job_type TEXT NOT NULL DEFAULT 'default';
+	ALTER TABLE jobs ADD COLUMN leased_by TEXT;
+
+	CREATE INDEX IF NOT EXISTS idx_queue_type ON jobs(queue, job_type);
+
+	CREATE TABLE IF NOT EXISTS workers (
+		id TEXT PRIMARY KEY,
+		queues TEXT NOT NULL DEFAULT '',
+		job_types TEXT NOT NULL DEFAULT '',
+		registered_at DATETIME NOT NULL,
+		last_heartbeat DATETIME NOT NULL
+	);
+	`,
+	// 2: worker status and statistics
+	`
+	ALTER TABLE workers ADD COLUMN status TEXT NOT NULL DEFAULT 'alive';
+	ALTER TABLE workers ADD COLUMN current_job TEXT;
+	ALTER TABLE workers ADD COLUMN jobs_processed INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE workers ADD COLUMN jobs_failed INTEGER NOT NULL DEFAULT 0;
+
+	CREATE INDEX IF NOT EXISTS idx_leased_by ON jobs(leased_by) WHERE leased_by IS NOT NULL;
+	`,
+	// 3: per-tenant limits
+	`
+	CREATE TABLE IF NOT EXISTS tenants (
+		id TEXT PRIMARY KEY,
+		rate_per_minute INTEGER NOT NULL DEFAULT 0,
+		burst INTEGER NOT NULL DEFAULT 0,
+		max_concurrent INTEGER NOT NULL DEFAULT 0,
+		max_queued INTEGER NOT NULL DEFAULT 0,
+		max_payload_bytes INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_tenant_status ON jobs(tenant_id, status);
+	`,
+	// 4: shared rate limiter buckets; times are Unix seconds to keep the
+	// refill arithmetic in SQL
+	`
+	CREATE TABLE IF NOT EXISTS rate_limits (
+		key TEXT PRIMARY KEY,
+		tokens REAL NOT NULL,
+		capacity REAL NOT NULL,
+		per_sec REAL NOT NULL,
+		updated_at REAL NOT NULL,
+		last_allowed INTEGER NOT NULL
+	);
+	`,
+	// 5: weighted fair scheduling across tenants
+	`
+	ALTER TABLE tenants ADD COLUMN weight INTEGER NOT NULL DEFAULT 0;
+
+	CREATE TABLE IF NOT EXISTS fair_share (
+		tenant_id TEXT PRIMARY KEY,
+		pass REAL NOT NULL,
+		leased INTEGER NOT NULL DEFAULT 0,
+		waited INTEGER NOT NULL DEFAULT 0,
+		wait_seconds REAL NOT NULL DEFAULT 0,
+		max_wait_seconds REAL NOT NULL DEFAULT 0
+	);
+	`,
+	// 6: API keys
+	`
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL DEFAULT '',
+		tenant_id TEXT NOT NULL DEFAULT '',
+		role TEXT NOT NULL,
+		key_hash TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		rotated_at DATETIME,
+		last_used_at DATETIME,
+		revoked_at DATETIME
+	);
+	`,
+	// 7: audit log; rows are only ever inserted, and deleted once past
+	// retention
+	`
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at DATETIME NOT NULL,
+		actor TEXT NOT NULL,
+		key_id TEXT NOT NULL DEFAULT '',
+		role TEXT NOT NULL,
+		source_ip TEXT NOT NULL DEFAULT '',
+		request_id TEXT NOT NULL DEFAULT '',
+		permission TEXT NOT NULL,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL,
+		outcome TEXT NOT NULL,
+		status INTEGER NOT NULL,
+		before TEXT,
+		after TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_audit_created ON audit_log(created_at);
+	CREATE INDEX IF NOT EXISTS idx_audit_target ON audit_log(target);
+
+	CREATE TRIGGER IF NOT EXISTS audit_log_append_only BEFORE UPDATE ON audit_log
+	BEGIN
+		SELECT RAISE(ABORT, 'audit_log is append-only');
+	END;
+	`,
+	// 8: start of the current attempt, for execution time metrics
+	`
+	ALTER TABLE jobs ADD COLUMN started_at DATETIME;
+	`,
+	// 9: W3C trace context of the submission
+	`
+	ALTER TABLE jobs ADD COLUMN traceparent TEXT NOT NULL DEFAULT '';
+	`,
+	// 10: lines logged by job handlers, capped per job and deleted once past
+	// retention
+	`
+	CREATE TABLE IF NOT EXISTS job_logs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id TEXT NOT NULL,
+		attempt INTEGER NOT NULL,
+		logged_at DATETIME NOT NULL,
+		level TEXT NOT NULL,
+		message TEXT NOT NULL,
+		attrs TEXT
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_job_logs_job ON job_logs(job_id, id);
+	CREATE INDEX IF NOT EXISTS idx_job_logs_logged ON job_logs(logged_at);
+	`,
+	// 11: job statistics over time, per minute, hour or day (resolution in
+	// seconds); wait_hist and run_hist are comma-separated histogram counts
+	`
+	CREATE TABLE IF NOT EXISTS metrics_history (
+		resolution INTEGER NOT NULL,
+		bucket_start DATETIME NOT NULL,
+		queue TEXT NOT NULL,
+		tenant_id TEXT NOT NULL,
+		enqueued INTEGER NOT NULL DEFAULT 0,
+		completed INTEGER NOT NULL DEFAULT 0,
+		failed INTEGER NOT NULL DEFAULT 0,
+		wait_hist TEXT NOT NULL,
+		run_hist TEXT NOT NULL,
+		PRIMARY KEY (resolution, bucket_start, queue, tenant_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_metrics_history_start ON metrics_history(bucket_start);
+	`,
+	// 12: alert rules; notify holds the sinks as JSON
+	`
+	CREATE TABLE IF NOT EXISTS alert_rules (
+		name TEXT PRIMARY KEY,
+		description TEXT NOT NULL DEFAULT '',
+		metric TEXT NOT NULL,
+		change INTEGER NOT NULL DEFAULT 0,
+		op TEXT NOT NULL,
+		threshold REAL NOT NULL,
+		window_seconds INTEGER NOT NULL DEFAULT 0,
+		for_seconds INTEGER NOT NULL DEFAULT 0,
+		group_by TEXT NOT NULL DEFAULT '',
+		queue TEXT NOT NULL DEFAULT '',
+		tenant_id TEXT NOT NULL DEFAULT '',
+		notify TEXT NOT NULL DEFAULT '[]',
+		disabled INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	`,
+	// 13: versioned JSON Schemas of job payloads per job type; jobs record the
+	// version they were validated against, 0 for none
+	`
+	CREATE TABLE IF NOT EXISTS job_schemas (
+		job_type TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		schema TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (job_type, version)
+	);
+
+	ALTER TABLE jobs ADD COLUMN schema_version INTEGER NOT NULL DEFAULT 0;
+	`,
+	// 14: content type of job payloads: empty for text, stored as TEXT like
+	// before; JSON payloads are stored as TEXT and binary ones as BLOB
+	`
+	ALTER TABLE jobs ADD COLUMN content_type TEXT NOT NULL DEFAULT '';
+	`,
+	// 15: per-tenant data keys wrapped by a master key from the keyring; jobs
+	// record the data key their payload and error message are encrypted
+	// with, 0 for plaintext
+	`
+	CREATE TABLE IF NOT EXISTS data_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		tenant_id TEXT NOT NULL,
+		master_key TEXT NOT NULL,
+		wrapped BLOB NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_data_keys_tenant ON data_keys(tenant_id, id);
+
+	ALTER TABLE jobs ADD COLUMN data_key INTEGER NOT NULL DEFAULT 0;
+	`,
+	// 16: the identity that registered each remote worker
+	`
+	ALTER TABLE workers ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+	`,
+	// 17: the pass at which each tenant was last leased a job
+	`
+	ALTER TABLE fair_share ADD COLUMN picked REAL NOT NULL DEFAULT 0;
+	UPDATE fair_share SET picked = pass - 1.0 / COALESCE(
+		(SELECT NULLIF(weight, 0) FROM tenants WHERE id = fair_share.tenant_id), 1);
+	`,
+	// 18: idempotency keys are unique per tenant. Keys were looked up across
+	// tenants, so a tenant may hold duplicates from concurrent submissions;
+	// the oldest job keeps the key.
+	`
+	DROP INDEX IF EXISTS idx_idempotency;
+	UPDATE jobs SET idempotency_key = NULL
+	WHERE idempotency_key IS NOT NULL AND rowid NOT IN (
+		SELECT MIN(rowid) FROM jobs WHERE idempotency_key IS NOT NULL
+		GROUP BY tenant_id, idempotency_key
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_tenant_idempotency
+		ON jobs(tenant_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
+	`,
+	// 19: the data key encrypting a log line's message and attributes, 0 for
+	// plaintext
+	`
+	ALTER TABLE job_logs ADD COLUMN data_key INTEGER NOT NULL DEFAULT 0;
+	`,
+}
+
+// SchemaVersion returns the schema version this build expects
+func SchemaVersion() int {
+	return len(migrations)
+}
+
+// CurrentSchemaVersion returns the schema version recorded in the database
+func (db *DB) CurrentSchemaVersion() (int, error) {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	return version, err
+}
+
+// migrate applies all migrations newer than the recorded schema version
+func (db *DB) migrate() error {
+	version, err := db.CurrentSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		// PRAGMA does not accept bound parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// refilledTokens is the SQL expression for the tokens of a rate_limits row
+// after refilling it up to @capacity at its previous rate
+const refilledTokens = "MIN(@capacity, tokens + MAX(0, @now - updated_at) * per_sec)"
+
+// TakeRateToken refills the token bucket stored under key and takes a token
+// from it if one is available. New buckets start full. It is a single
+// statement, so concurrent callers, in this or another process, never take
+// the same token. It reports whether a token was taken and how many are left.
+func (db *DB) TakeRateToken(key string, capacity, perSec float64, now time.Time) (bool, float64, error) {
+	var allowed bool
+	var tokens float64
+	err := db.QueryRow(`
+		INSERT INTO rate_limits (key, tokens, capacity, per_sec, updated_at, last_allowed)
+		VALUES (@key, @capacity - 1, @capacity, @per_sec, @now, 1)
+		ON CONFLICT(key) DO UPDATE SET
+			tokens = `+refilledTokens+` - (`+refilledTokens+` >= 1),
+			last_allowed = `+refilledTokens+` >= 1,
+			capacity = @capacity,
+			per_sec = @per_sec,
+			updated_at = MAX(updated_at, @now)
+		RETURNING last_allowed, tokens
+	`, sql.Named("key", key), sql.Named("capacity", capacity), sql.Named("per_sec", perSec),
+		sql.Named("now", unixSeconds(now))).Scan(&allowed, &tokens)
+	return allowed, tokens, err
+}
+
+// DeleteFullRateBuckets removes the buckets that have refilled completely,
+// which behave the same as missing ones
+func (db *DB) DeleteFullRateBuckets(now time.Time) (int64, error) {
+	res, err := db.Exec("DELETE FROM rate_limits WHERE tokens + (? - updated_at) * per_sec >= capacity",
+		unixSeconds(now))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
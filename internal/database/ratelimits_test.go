@@ -0,0 +1,36 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTakeRateToken(t *testing.T) {
+	db := newTestDB(t)
+	t0 := time.Unix(1_000_000, 0)
+
+	// A bucket of 2 tokens refilling one per second
+	steps := []struct {
+		at      time.Duration
+		allowed bool
+		tokens  float64
+	}{
+		{0, true, 1}, // New buckets start full
+		{0, true, 0},
+		{0, false, 0},
+		{500 * time.Millisecond, false, 0.5},  // Half a token is not enough
+		{1500 * time.Millisecond, true, 0.5},  // 1.5 tokens
+		{1000 * time.Millisecond, false, 0.5}, // A clock behind adds nothing
+		{time.Hour, true, 1},                  // Refills up to capacity only
+	}
+	for i, s := range steps {
+		allowed, tokens, err := db.TakeRateToken("acme", 2, 1, t0.Add(s.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != s.allowed || tokens != s.tokens {
+			t.Errorf("step %d at %v: got allowed=%v tokens=%v, want %v and %v",
+				i, s.at, allowed, tokens, s.allowed, s.tokens)
+		}
+	}
+}
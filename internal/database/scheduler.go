@@ -0,0 +1,169 @@
+package database
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/models"
+	"time"
+)
+
+// fairShare is a tenant's place in the lease schedule. Tenants are served in
+// order of their pass, which advances by 1/weight every time one of their
+// jobs is leased (stride scheduling), so over time each tenant with work
+// receives leases in proportion to its weight.
+type fairShare struct {
+	tenantID string
+	weight   float64
+	pass     float64 // Pass at which the tenant is served next
+}
+
+// pickTenant returns the tenant with eligible jobs that should be served next.
+// busy and where are the CTE and WHERE clause selecting eligible jobs.
+//
+// The schedule's current time is the latest pass at which one of these
+// tenants was served. A tenant that was idle would otherwise come back with a
+// lower pass and monopolize the workers until it caught up, so it joins at the
+// current time, as new tenants do. A tenant that has never been served wins
+// ties; other ties go to the tenant whose oldest job has waited longest.
+func (db *DB) pickTenant(tx *sql.Tx, busy, where string, args []interface{}) (*fairShare, error) {
+	defaultWeight := db.TenantDefaults().Weight
+	if defaultWeight <= 0 {
+		defaultWeight = 1
+	}
+
+	rows, err := tx.Query(busy+`
+		SELECT c.tenant_id, COALESCE(t.weight, 0), f.pass, COALESCE(f.picked, 0)
+		FROM (SELECT tenant_id, MIN(created_at) AS oldest FROM jobs`+where+` GROUP BY tenant_id) c
+		LEFT JOIN tenants t ON t.id = c.tenant_id
+		LEFT JOIN fair_share f ON f.tenant_id = c.tenant_id
+		ORDER BY c.oldest ASC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []fairShare
+	var passes []sql.NullFloat64
+	now := 0.0
+	for rows.Next() {
+		var c fairShare
+		var pass sql.NullFloat64
+		var weight int
+		var picked float64
+		if err := rows.Scan(&c.tenantID, &weight, &pass, &picked); err != nil {
+			return nil, err
+		}
+		now = max(now, picked)
+		if weight <= 0 {
+			weight = defaultWeight
+		}
+		c.weight = float64(weight)
+		candidates = append(candidates, c)
+		passes = append(passes, pass)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	next := -1
+	for i := range candidates {
+		candidates[i].pass = now
+		if passes[i].Valid && passes[i].Float64 > now {
+			candidates[i].pass = passes[i].Float64
+		}
+		if next < 0 || candidates[i].pass < candidates[next].pass ||
+			(candidates[i].pass == candidates[next].pass && !passes[i].Valid && passes[next].Valid) {
+			next = i
+		}
+	}
+	return &candidates[next], nil
+}
+
+// charge records that the tenant was served at its pass, advances the pass
+// for the leased job and records how long the job waited
+func (f *fairShare) charge(tx *sql.Tx, wait time.Duration) error {
+	waited := 0
+	if wait > 0 {
+		waited = 1
+	}
+	_, err := tx.Exec(`
+		INSERT INTO fair_share (tenant_id, pass, picked, leased, waited, wait_seconds, max_wait_seconds)
+		VALUES (?, ?, ?, 1, ?, ?, ?)
+		ON CONFLICT(tenant_id) DO UPDATE SET
+			pass = excluded.pass,
+			picked = excluded.picked,
+			leased = leased + 1,
+			waited = waited + excluded.waited,
+			wait_seconds = wait_seconds + excluded.wait_seconds,
+			max_wait_seconds = MAX(max_wait_seconds, excluded.max_wait_seconds)
+	`, f.tenantID, f.pass+1/f.weight, f.pass, waited, wait.Seconds(), wait.Seconds())
+	return err
+}
+
+// GetTenantMetrics returns scheduling statistics of every tenant that has
+// leased a job or has jobs waiting
+func (db *DB) GetTenantMetrics() ([]models.TenantMetrics, error) {
+	defaultWeight := db.TenantDefaults().Weight
+	if defaultWeight <= 0 {
+		defaultWeight = 1
+	}
+
+	now := time.Now()
+	rows, err := db.Query(`
+		WITH ids AS (
+			SELECT tenant_id FROM fair_share
+			UNION SELECT DISTINCT tenant_id FROM jobs WHERE status IN (?, ?)
+		),
+		queued AS (
+			SELECT tenant_id,
+				SUM(status = ?) AS pending,
+				SUM(status = ?) AS running,
+				MIN(CASE WHEN status = ? THEN created_at END) AS oldest
+			FROM jobs WHERE status IN (?, ?) GROUP BY tenant_id
+		)
+		SELECT ids.tenant_id, COALESCE(NULLIF(t.weight, 0), ?),
+			COALESCE(q.pending, 0), COALESCE(q.running, 0), q.oldest,
+			COALESCE(f.leased, 0), COALESCE(f.waited, 0),
+			COALESCE(f.wait_seconds, 0), COALESCE(f.max_wait_seconds, 0)
+		FROM ids
+		LEFT JOIN queued q ON q.tenant_id = ids.tenant_id
+		LEFT JOIN tenants t ON t.id = ids.tenant_id
+		LEFT JOIN fair_share f ON f.tenant_id = ids.tenant_id
+		ORDER BY ids.tenant_id ASC
+	`, models.StatusPending, models.StatusRunning,
+		models.StatusPending, models.StatusRunning, models.StatusPending,
+		models.StatusPending, models.StatusRunning, defaultWeight)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := []models.TenantMetrics{}
+	for rows.Next() {
+		var m models.TenantMetrics
+		var oldest sql.NullString
+		var waited int64
+		var waitTotal float64
+		err := rows.Scan(&m.TenantID, &m.Weight, &m.Pending, &m.Running, &oldest,
+			&m.Leased, &waited, &waitTotal, &m.MaxWaitSecs)
+		if err != nil {
+			return nil, err
+		}
+
+		if waited > 0 {
+			m.AvgWaitSecs = waitTotal / float64(waited)
+		}
+		if oldest.Valid {
+			t, err := parseTime(oldest.String)
+			if err != nil {
+				return nil, err
+			}
+			m.OldestWaitSecs = now.Sub(t).Seconds()
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
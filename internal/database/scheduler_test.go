@@ -0,0 +1,203 @@
+package database
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/models"
+	"testing"
+	"time"
+)
+
+const testWorker = "worker-1"
+
+// lease leases the next job for testWorker, or returns nil if there is none
+func lease(t *testing.T, db *DB) *models.Job {
+	t.Helper()
+	job, err := db.LeaseJob(LeaseFilter{WorkerID: testWorker}, time.Now().Add(time.Minute))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+// leaseTenant leases the next job and returns its tenant
+func leaseTenant(t *testing.T, db *DB) string {
+	t.Helper()
+	job := lease(t, db)
+	if job == nil {
+		t.Fatal("no job to lease")
+	}
+	return job.TenantID
+}
+
+// leaseAndAck leases and acknowledges n jobs and returns how many each tenant
+// got, and the tenants in lease order
+func leaseAndAck(t *testing.T, db *DB, n int) (map[string]int, []string) {
+	t.Helper()
+	counts := make(map[string]int)
+	var order []string
+	for i := 0; i < n; i++ {
+		job := lease(t, db)
+		if job == nil {
+			t.Fatalf("no job to lease after %d leases", i)
+		}
+		if err := db.AckJob(job.ID, testWorker); err != nil {
+			t.Fatal(err)
+		}
+		counts[job.TenantID]++
+		order = append(order, job.TenantID)
+	}
+	return counts, order
+}
+
+// queue inserts n jobs for a tenant, created a second apart from start
+func queue(t *testing.T, db *DB, tenantID string, n int, start time.Time) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		insertJob(t, db, tenantID, start.Add(time.Duration(i)*time.Second))
+	}
+}
+
+func TestLeaseSkipsTenantsAtConcurrencyLimit(t *testing.T) {
+	db := newTestDB(t)
+	db.SetTenantDefaults(models.TenantLimits{MaxConcurrent: 2})
+	if err := db.UpsertTenant(&models.Tenant{ID: "small", TenantLimits: models.TenantLimits{MaxConcurrent: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Hour)
+	queue(t, db, "big", 10, start)
+	queue(t, db, "small", 3, start.Add(time.Minute))
+
+	// big runs up to the default of 2 and small up to its own limit of 1,
+	// then nothing may be leased although both have jobs waiting
+	var running []*models.Job
+	counts := make(map[string]int)
+	for job := lease(t, db); job != nil; job = lease(t, db) {
+		running = append(running, job)
+		counts[job.TenantID]++
+	}
+	if counts["big"] != 2 || counts["small"] != 1 {
+		t.Fatalf("leased %v, want big 2 and small 1", counts)
+	}
+
+	// Finishing a job frees a slot for its tenant only
+	if err := db.AckJob(running[0].ID, testWorker); err != nil {
+		t.Fatal(err)
+	}
+	if job := lease(t, db); job == nil || job.TenantID != running[0].TenantID {
+		t.Fatalf("leased %v after %s finished a job", job, running[0].TenantID)
+	}
+	if job := lease(t, db); job != nil {
+		t.Fatalf("leased a job of %s with both tenants at their limit", job.TenantID)
+	}
+}
+
+func TestLeaseIgnoresExpiredLeasesForConcurrency(t *testing.T) {
+	db := newTestDB(t)
+	db.SetTenantDefaults(models.TenantLimits{MaxConcurrent: 1})
+	queue(t, db, "acme", 2, time.Now().Add(-time.Hour))
+
+	// A lease that expired does not hold a slot, and its job is leased again
+	// before the tenant's next one
+	expired, err := db.LeaseJob(LeaseFilter{WorkerID: testWorker}, time.Now().Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := lease(t, db)
+	if job == nil || job.ID != expired.ID {
+		t.Fatalf("leased %v, want the expired job %s again", job, expired.ID)
+	}
+	if job := lease(t, db); job != nil {
+		t.Fatalf("leased %s with the tenant at its limit", job.ID)
+	}
+}
+
+func TestLeaseSharesWorkersWhenOneTenantSaturates(t *testing.T) {
+	db := newTestDB(t)
+	start := time.Now().Add(-time.Hour)
+
+	// big queued 100 jobs before five other tenants queued 2 each
+	queue(t, db, "big", 100, start)
+	small := []string{"a", "b", "c", "d", "e"}
+	for _, tenant := range small {
+		queue(t, db, tenant, 2, start.Add(10*time.Minute))
+	}
+
+	// Leases go round the six tenants instead of draining big first
+	counts, _ := leaseAndAck(t, db, 12)
+	for _, tenant := range append(small, "big") {
+		if counts[tenant] != 2 {
+			t.Errorf("%s got %d of the first 12 leases, want 2", tenant, counts[tenant])
+		}
+	}
+
+	// Then big has the workers to itself
+	counts, _ = leaseAndAck(t, db, 10)
+	if counts["big"] != 10 {
+		t.Errorf("leased %v once the others were done, want only big", counts)
+	}
+}
+
+func TestLeaseByWeight(t *testing.T) {
+	db := newTestDB(t)
+	if err := db.UpsertTenant(&models.Tenant{ID: "heavy", TenantLimits: models.TenantLimits{Weight: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour)
+	queue(t, db, "light", 50, start)
+	queue(t, db, "heavy", 50, start.Add(time.Minute))
+
+	counts, _ := leaseAndAck(t, db, 40)
+	if counts["heavy"] != 30 || counts["light"] != 10 {
+		t.Errorf("leased %v, want heavy 30 and light 10", counts)
+	}
+}
+
+func TestLeaseTies(t *testing.T) {
+	db := newTestDB(t)
+	start := time.Now().Add(-time.Hour)
+	queue(t, db, "first", 5, start)
+	queue(t, db, "second", 5, start.Add(time.Minute))
+
+	// Among tenants never served the oldest job goes first; then second,
+	// never served, wins the tie with first at the same pass
+	_, order := leaseAndAck(t, db, 4)
+	want := []string{"first", "second", "first", "second"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("leased %v, want %v", order, want)
+		}
+	}
+
+	// A tenant arriving later joins at the current pass and wins the next
+	// tie, rather than waiting behind the older jobs
+	queue(t, db, "third", 5, start.Add(time.Hour))
+	if tenant := leaseTenant(t, db); tenant != "third" {
+		t.Fatalf("leased %s, want the new tenant third", tenant)
+	}
+}
+
+func TestLeaseReturningTenantDoesNotMonopolize(t *testing.T) {
+	db := newTestDB(t)
+	start := time.Now().Add(-time.Hour)
+
+	// idle was served once, then a and b took 20 leases between them
+	queue(t, db, "idle", 1, start)
+	leaseAndAck(t, db, 1)
+	queue(t, db, "a", 20, start.Add(time.Minute))
+	queue(t, db, "b", 20, start.Add(time.Minute))
+	leaseAndAck(t, db, 20)
+
+	// Back with work, idle shares the workers rather than catching up on the
+	// passes it missed
+	queue(t, db, "idle", 10, start.Add(2*time.Minute))
+	counts, _ := leaseAndAck(t, db, 9)
+	for _, tenant := range []string{"idle", "a", "b"} {
+		if counts[tenant] != 3 {
+			t.Errorf("%s got %d of 9 leases, want 3", tenant, counts[tenant])
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"time"
+)
+
+// jobSchemaColumns is the column list understood by scanJobSchema
+const jobSchemaColumns = `job_type, version, schema, created_at`
+
+// InsertJobSchema registers schema as the next version of a job type's
+// schema. If it is the same as the latest version, that version is returned
+// instead and created is false.
+func (db *DB) InsertJobSchema(jobType string, schema json.RawMessage) (s *models.JobSchema, created bool, err error) {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, schema); err != nil {
+		return nil, false, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	latest, err := scanJobSchema(tx.QueryRow("SELECT "+jobSchemaColumns+
+		" FROM job_schemas WHERE job_type = ? ORDER BY version DESC LIMIT 1", jobType))
+	switch {
+	case err == sql.ErrNoRows:
+		latest = &models.JobSchema{}
+	case err != nil:
+		return nil, false, err
+	case bytes.Equal(latest.Schema, compact.Bytes()):
+		return latest, false, nil
+	}
+
+	s = &models.JobSchema{
+		Type:      jobType,
+		Version:   latest.Version + 1,
+		Schema:    compact.Bytes(),
+		CreatedAt: time.Now(),
+	}
+	if _, err := tx.Exec("INSERT INTO job_schemas (job_type, version, schema, created_at) VALUES (?, ?, ?, ?)",
+		s.Type, s.Version, string(s.Schema), s.CreatedAt); err != nil {
+		return nil, false, err
+	}
+	return s, true, tx.Commit()
+}
+
+// GetJobSchema retrieves a version of a job type's schema, or the latest one
+// if version is 0. It returns sql.ErrNoRows if there is no such version.
+func (db *DB) GetJobSchema(jobType string, version int) (*models.JobSchema, error) {
+	if version == 0 {
+		return scanJobSchema(db.QueryRow("SELECT "+jobSchemaColumns+
+			" FROM job_schemas WHERE job_type = ? ORDER BY version DESC LIMIT 1", jobType))
+	}
+	return scanJobSchema(db.QueryRow("SELECT "+jobSchemaColumns+
+		" FROM job_schemas WHERE job_type = ? AND version = ?", jobType, version))
+}
+
+// ListJobSchemas retrieves every version of a job type's schema, oldest
+// first, or the latest version of every job type's schema if jobType is
+// empty
+func (db *DB) ListJobSchemas(jobType string) ([]models.JobSchema, error) {
+	query := "SELECT " + jobSchemaColumns + " FROM job_schemas WHERE job_type = ? ORDER BY version ASC"
+	args := []interface{}{jobType}
+	if jobType == "" {
+		query = "SELECT " + jobSchemaColumns + ` FROM job_schemas s
+			WHERE version = (SELECT MAX(version) FROM job_schemas WHERE job_type = s.job_type)
+			ORDER BY job_type ASC`
+		args = nil
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schemas := []models.JobSchema{}
+	for rows.Next() {
+		s, err := scanJobSchema(rows)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, *s)
+	}
+	return schemas, rows.Err()
+}
+
+// scanJobSchema scans a row selected with jobSchemaColumns
+func scanJobSchema(row rowScanner) (*models.JobSchema, error) {
+	var s models.JobSchema
+	var schema string
+	if err := row.Scan(&s.Type, &s.Version, &schema, &s.CreatedAt); err != nil {
+		return nil, err
+	}
+	s.Schema = json.RawMessage(schema)
+	return &s, nil
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/models"
+	"errors"
+	"time"
+)
+
+// tenantColumns is the column list understood by scanTenant
+const tenantColumns = `id, rate_per_minute, burst, max_concurrent, max_queued, max_payload_bytes,
+	weight, created_at, updated_at`
+
+// SetTenantDefaults sets the limits applied to tenants without a record and to
+// the zero fields of those with one
+func (db *DB) SetTenantDefaults(defaults models.TenantLimits) {
+	db.defaultsMu.Lock()
+	defer db.defaultsMu.Unlock()
+	db.defaults = defaults
+}
+
+// TenantDefaults returns the limits applied to tenants without a record
+func (db *DB) TenantDefaults() models.TenantLimits {
+	db.defaultsMu.RLock()
+	defer db.defaultsMu.RUnlock()
+	return db.defaults
+}
+
+// TenantLimits returns the effective limits of a tenant
+func (db *DB) TenantLimits(tenantID string) (models.TenantLimits, error) {
+	defaults := db.TenantDefaults()
+
+	t, err := db.GetTenant(tenantID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaults, nil
+	}
+	if err != nil {
+		return defaults, err
+	}
+	return t.TenantLimits.Or(defaults), nil
+}
+
+// MaxPayloadBytes returns the largest payload size limit of any tenant
+func (db *DB) MaxPayloadBytes() (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COALESCE(MAX(max_payload_bytes), 0) FROM tenants").Scan(&n)
+	return max(n, db.TenantDefaults().MaxPayloadBytes), err
+}
+
+// UpsertTenant creates or replaces the limits of a tenant
+func (db *DB) UpsertTenant(t *models.Tenant) error {
+	now := time.Now()
+	err := db.QueryRow(`
+		INSERT INTO tenants (id, rate_per_minute, burst, max_concurrent, max_queued, max_payload_bytes, weight, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			rate_per_minute = excluded.rate_per_minute,
+			burst = excluded.burst,
+			max_concurrent = excluded.max_concurrent,
+			max_queued = excluded.max_queued,
+			max_payload_bytes = excluded.max_payload_bytes,
+			weight = excluded.weight,
+			updated_at = excluded.updated_at
+		RETURNING created_at
+	`, t.ID, t.RatePerMinute, t.Burst, t.MaxConcurrent, t.MaxQueued, t.MaxPayloadBytes,
+		t.Weight, now, now).Scan(&t.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	t.UpdatedAt = now
+	return nil
+}
+
+// GetTenant retrieves the record of a tenant
+func (db *DB) GetTenant(id string) (*models.Tenant, error) {
+	return scanTenant(db.QueryRow("SELECT "+tenantColumns+" FROM tenants WHERE id = ?", id))
+}
+
+// ListTenants retrieves all tenant records
+func (db *DB) ListTenants() ([]models.Tenant, error) {
+	rows, err := db.Query("SELECT " + tenantColumns + " FROM tenants ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tenants := []models.Tenant{}
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, *t)
+	}
+	return tenants, rows.Err()
+}
+
+// DeleteTenant removes the record of a tenant, which reverts it to the
+// defaults. It reports whether a record existed.
+func (db *DB) DeleteTenant(id string) (bool, error) {
+	res, err := db.Exec("DELETE FROM tenants WHERE id = ?", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetQueuedJobsCount returns the number of jobs of a tenant waiting to run,
+// including failed jobs that will be retried
+func (db *DB) GetQueuedJobsCount(tenantID string) (int, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM jobs
+		WHERE tenant_id = ? AND (status = ? OR (status = ? AND retry_count < max_retries))
+	`, tenantID, models.StatusPending, models.StatusFailed).Scan(&count)
+	return count, err
+}
+
+// scanTenant scans a row selected with tenantColumns
+func scanTenant(row rowScanner) (*models.Tenant, error) {
+	var t models.Tenant
+	err := row.Scan(&t.ID, &t.RatePerMinute, &t.Burst, &t.MaxConcurrent, &t.MaxQueued,
+		&t.MaxPayloadBytes, &t.Weight, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"distributed-task-queue/internal/models"
+	"distributed-task-queue/internal/tracing"
+	"strconv"
+	"time"
+)
+
+// traceLease records the wait of a job that was just leased, unless it was
+// reclaimed from an expired lease, and the lease itself, which began at start,
+// as children of the job's submission span. Jobs submitted before tracing
+// have no trace to continue.
+func traceLease(job *models.Job, workerID string, wait time.Duration, start time.Time) {
+	parent, ok := tracing.ParseTraceparent(job.Traceparent)
+	if !ok {
+		return
+	}
+	attempt := strconv.Itoa(job.RetryCount + 1)
+
+	if wait > 0 {
+		span := tracing.Start("job.queue_wait", parent, start.Add(-wait))
+		span.SetAttribute("job.id", job.ID)
+		span.SetAttribute("job.queue", job.Queue)
+		span.SetAttribute("job.attempt", attempt)
+		span.EndAt(start, nil)
+	}
+
+	span := tracing.Start("job.lease", parent, start)
+	span.SetAttribute("job.id", job.ID)
+	span.SetAttribute("job.attempt", attempt)
+	span.SetAttribute("worker.id", workerID)
+	span.SetAttribute("job.reclaimed", strconv.FormatBool(job.Status == models.StatusRunning))
+	span.End(nil)
+}
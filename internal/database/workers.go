@@ -0,0 +1,204 @@
+package database
+
+import (
+	"database/sql"
+	"distributed-task-queue/internal/models"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrWorkerOwned is returned when registering a worker ID that another
+// owner registered
+var ErrWorkerOwned = errors.New("worker is registered by another owner")
+
+// workerColumns is the column list understood by scanWorker
+const workerColumns = `id, queues, job_types, status, current_job, jobs_processed, jobs_failed,
+	registered_at, last_heartbeat, owner`
+
+// UpsertWorker registers a worker. Re-registering an existing ID, e.g. after
+// a restart, refreshes its capabilities and resets its statistics. It fails
+// with ErrWorkerOwned if the ID belongs to another owner.
+func (db *DB) UpsertWorker(w *models.WorkerInfo) error {
+	res, err := db.Exec(`
+		INSERT INTO workers (id, queues, job_types, status, registered_at, last_heartbeat, owner)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			queues = excluded.queues,
+			job_types = excluded.job_types,
+			status = excluded.status,
+			current_job = NULL,
+			jobs_processed = 0,
+			jobs_failed = 0,
+			registered_at = excluded.registered_at,
+			last_heartbeat = excluded.last_heartbeat
+		WHERE workers.owner = excluded.owner
+	`, w.ID, strings.Join(w.Queues, ","), strings.Join(w.Types, ","), models.WorkerAlive,
+		w.RegisteredAt, w.LastHeartbeat, w.Owner)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrWorkerOwned
+	}
+
+	w.Status = models.WorkerAlive
+	return nil
+}
+
+// GetWorker retrieves a registered worker by its ID
+func (db *DB) GetWorker(id string) (*models.WorkerInfo, error) {
+	return scanWorker(db.QueryRow("SELECT "+workerColumns+" FROM workers WHERE id = ?", id))
+}
+
+// ListWorkers retrieves registered workers, optionally filtered by status
+func (db *DB) ListWorkers(status string) ([]models.WorkerInfo, error) {
+	query := "SELECT " + workerColumns + " FROM workers"
+	args := []interface{}{}
+
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	query += " ORDER BY status ASC, registered_at DESC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workers := []models.WorkerInfo{}
+	for rows.Next() {
+		w, err := scanWorker(rows)
+		if err != nil {
+			return nil, err
+		}
+		workers = append(workers, *w)
+	}
+	return workers, rows.Err()
+}
+
+// TouchWorker records a heartbeat from a worker. A worker previously marked
+// dead is considered alive again, although its reclaimed leases are gone.
+func (db *DB) TouchWorker(id string, at time.Time) error {
+	_, err := db.Exec("UPDATE workers SET last_heartbeat = ?, status = ? WHERE id = ?",
+		at, models.WorkerAlive, id)
+	return err
+}
+
+// StopWorker marks a worker as cleanly shut down
+func (db *DB) StopWorker(id string) error {
+	_, err := db.Exec("UPDATE workers SET status = ?, current_job = NULL WHERE id = ?",
+		models.WorkerStopped, id)
+	return err
+}
+
+// ReapWorkers marks alive workers whose last heartbeat is before deadline as
+// dead and returns the jobs they held to the queue. It returns the IDs of the
+// workers marked dead and the number of jobs reclaimed.
+func (db *DB) ReapWorkers(deadline time.Time) ([]string, int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id FROM workers WHERE status = ? AND last_heartbeat < ?",
+		models.WorkerAlive, deadline)
+	if err != nil {
+		return nil, 0, err
+	}
+	dead := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		dead = append(dead, id)
+	}
+	rows.Close()
+
+	if len(dead) == 0 {
+		return dead, 0, nil
+	}
+
+	args := make([]interface{}, 0, len(dead)+2)
+	args = append(args, models.WorkerDead)
+	for _, id := range dead {
+		args = append(args, id)
+	}
+	_, err = tx.Exec("UPDATE workers SET status = ?, current_job = NULL WHERE id IN ("+placeholders(len(dead))+")", args...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Reclaimed jobs go back to pending without using up a retry, the same
+	// as when a lease expires
+	args = append([]interface{}{models.StatusPending, time.Now(), models.StatusRunning}, args[1:]...)
+	res, err := tx.Exec(`
+		UPDATE jobs
+		SET status = ?, updated_at = ?, leased_until = NULL, leased_by = NULL
+		WHERE status = ? AND leased_by IN (`+placeholders(len(dead))+`)
+	`, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	reclaimed, err := res.RowsAffected()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, err
+	}
+
+	if reclaimed > 0 {
+		leaseExpirations.Add(float64(reclaimed), "worker_dead")
+		db.NotifyJobReady()
+	}
+	return dead, reclaimed, nil
+}
+
+// DeleteWorkersBefore removes dead and stopped workers whose last heartbeat is
+// before cutoff
+func (db *DB) DeleteWorkersBefore(cutoff time.Time) (int64, error) {
+	res, err := db.Exec("DELETE FROM workers WHERE status != ? AND last_heartbeat < ?",
+		models.WorkerAlive, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// scanWorker scans a row selected with workerColumns
+func scanWorker(row rowScanner) (*models.WorkerInfo, error) {
+	var w models.WorkerInfo
+	var queues, types string
+	var currentJob sql.NullString
+
+	err := row.Scan(&w.ID, &queues, &types, &w.Status, &currentJob,
+		&w.JobsProcessed, &w.JobsFailed, &w.RegisteredAt, &w.LastHeartbeat, &w.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Queues = splitList(queues)
+	w.Types = splitList(types)
+	if currentJob.Valid {
+		w.CurrentJob = currentJob.String
+	}
+	return &w, nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
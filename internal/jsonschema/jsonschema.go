@@ -0,0 +1,632 @@
+// Package jsonschema validates JSON documents against the commonly used
+// subset of JSON Schema: types, enums and constants, object properties,
+// arrays, number and string bounds, patterns and the allOf, anyOf, oneOf and
+// not combinators. References and formats are not supported; schemas using
+// them are rejected rather than silently ignoring the keyword.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxErrors is the number of failed checks Validate reports at most
+const maxErrors = 100
+
+// Schema is a compiled schema
+type Schema struct {
+	always *bool // Set for the boolean schemas true and false
+
+	types    []string
+	enum     []interface{}
+	constant *interface{}
+
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	minProperties        *int
+	maxProperties        *int
+
+	items       *Schema
+	minItems    *int
+	maxItems    *int
+	uniqueItems bool
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	allOf []*Schema
+	anyOf []*Schema
+	oneOf []*Schema
+	not   *Schema
+}
+
+// FieldError is a failed check at a location in the document, given as a
+// JSON Pointer such as /items/0/name; the document itself is ""
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// annotations are keywords that are accepted and ignored
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "deprecated": true, "readOnly": true, "writeOnly": true,
+}
+
+var knownTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true,
+	"number": true, "integer": true, "string": true,
+}
+
+// Compile parses a schema, rejecting keywords it does not support
+func Compile(data []byte) (*Schema, error) {
+	v, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	return compile(v, "")
+}
+
+func compile(v interface{}, path string) (*Schema, error) {
+	if b, ok := v.(bool); ok {
+		return &Schema{always: &b}, nil
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or a boolean", pathOrRoot(path))
+	}
+
+	s := &Schema{}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := s.compileKeyword(k, m[k], path); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *Schema) compileKeyword(k string, v interface{}, path string) error {
+	at := path + "/" + escape(k)
+	bad := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%s: %s", at, fmt.Sprintf(format, args...))
+	}
+
+	var err error
+	switch k {
+	case "type":
+		switch t := v.(type) {
+		case string:
+			s.types = []string{t}
+		case []interface{}:
+			for _, e := range t {
+				name, ok := e.(string)
+				if !ok {
+					return bad("must be a string or an array of strings")
+				}
+				s.types = append(s.types, name)
+			}
+		default:
+			return bad("must be a string or an array of strings")
+		}
+		for _, t := range s.types {
+			if !knownTypes[t] {
+				return bad("unknown type %q", t)
+			}
+		}
+	case "enum":
+		values, ok := v.([]interface{})
+		if !ok || len(values) == 0 {
+			return bad("must be a non-empty array")
+		}
+		s.enum = values
+	case "const":
+		s.constant = &v
+	case "properties":
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return bad("must be an object")
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, p := range props {
+			if s.properties[name], err = compile(p, at+"/"+escape(name)); err != nil {
+				return err
+			}
+		}
+	case "required":
+		names, ok := v.([]interface{})
+		if !ok {
+			return bad("must be an array of strings")
+		}
+		for _, n := range names {
+			name, ok := n.(string)
+			if !ok {
+				return bad("must be an array of strings")
+			}
+			s.required = append(s.required, name)
+		}
+	case "additionalProperties":
+		s.additionalProperties, err = compile(v, at)
+	case "items":
+		s.items, err = compile(v, at)
+	case "not":
+		s.not, err = compile(v, at)
+	case "allOf", "anyOf", "oneOf":
+		list, ok := v.([]interface{})
+		if !ok || len(list) == 0 {
+			return bad("must be a non-empty array of schemas")
+		}
+		schemas := make([]*Schema, len(list))
+		for i, e := range list {
+			if schemas[i], err = compile(e, at+"/"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+		switch k {
+		case "allOf":
+			s.allOf = schemas
+		case "anyOf":
+			s.anyOf = schemas
+		default:
+			s.oneOf = schemas
+		}
+	case "minProperties", "maxProperties", "minItems", "maxItems", "minLength", "maxLength":
+		n, ok := count(v)
+		if !ok {
+			return bad("must be a non-negative integer")
+		}
+		switch k {
+		case "minProperties":
+			s.minProperties = &n
+		case "maxProperties":
+			s.maxProperties = &n
+		case "minItems":
+			s.minItems = &n
+		case "maxItems":
+			s.maxItems = &n
+		case "minLength":
+			s.minLength = &n
+		default:
+			s.maxLength = &n
+		}
+	case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+		f, ok := number(v)
+		if !ok {
+			return bad("must be a number")
+		}
+		switch k {
+		case "minimum":
+			s.minimum = &f
+		case "maximum":
+			s.maximum = &f
+		case "exclusiveMinimum":
+			s.exclusiveMinimum = &f
+		default:
+			s.exclusiveMaximum = &f
+		}
+	case "multipleOf":
+		f, ok := number(v)
+		if !ok || f <= 0 {
+			return bad("must be a positive number")
+		}
+		s.multipleOf = &f
+	case "uniqueItems":
+		b, ok := v.(bool)
+		if !ok {
+			return bad("must be a boolean")
+		}
+		s.uniqueItems = b
+	case "pattern":
+		p, ok := v.(string)
+		if !ok {
+			return bad("must be a string")
+		}
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return bad("invalid pattern: %v", err)
+		}
+	default:
+		if !annotations[k] {
+			return bad("unsupported keyword")
+		}
+	}
+	return err
+}
+
+// Validate checks a JSON document against the schema and returns the failed
+// checks, up to maxErrors of them, or nil if the document is valid
+func (s *Schema) Validate(data []byte) []FieldError {
+	v, err := decode(data)
+	if err != nil {
+		return []FieldError{{Path: "", Message: "is not valid JSON"}}
+	}
+	var errs []FieldError
+	s.validate(v, "", &errs)
+	return errs
+}
+
+func (s *Schema) validate(v interface{}, path string, errs *[]FieldError) {
+	if len(*errs) >= maxErrors {
+		return
+	}
+	fail := func(format string, args ...interface{}) {
+		addError(errs, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if s.always != nil {
+		if !*s.always {
+			fail("is not allowed")
+		}
+		return
+	}
+
+	if len(s.types) > 0 && !hasType(v, s.types) {
+		fail("must be %s", strings.Join(s.types, " or "))
+		// Further checks would only repeat the type mismatch
+		return
+	}
+	if s.enum != nil && !containsEqual(s.enum, v) {
+		fail("must be one of %s", encodeList(s.enum))
+	}
+	if s.constant != nil && !equal(*s.constant, v) {
+		fail("must be %s", encode(*s.constant))
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		s.validateObject(v, path, errs, fail)
+	case []interface{}:
+		s.validateArray(v, path, errs, fail)
+	case json.Number:
+		s.validateNumber(v, fail)
+	case string:
+		s.validateString(v, fail)
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, path, errs)
+	}
+	if s.anyOf != nil {
+		matched := false
+		for _, sub := range s.anyOf {
+			if sub.valid(v) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("must match at least one of the anyOf schemas")
+		}
+	}
+	if s.oneOf != nil {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.valid(v) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("must match exactly one of the oneOf schemas, matches %d", matched)
+		}
+	}
+	if s.not != nil && s.not.valid(v) {
+		fail("must not match the not schema")
+	}
+}
+
+func (s *Schema) validateObject(v map[string]interface{}, path string, errs *[]FieldError, fail func(string, ...interface{})) {
+	for _, name := range s.required {
+		if _, ok := v[name]; !ok {
+			addError(errs, FieldError{Path: path + "/" + escape(name), Message: "is required"})
+		}
+	}
+	if s.minProperties != nil && len(v) < *s.minProperties {
+		fail("must have at least %d properties", *s.minProperties)
+	}
+	if s.maxProperties != nil && len(v) > *s.maxProperties {
+		fail("must have at most %d properties", *s.maxProperties)
+	}
+
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		at := path + "/" + escape(name)
+		if p, ok := s.properties[name]; ok {
+			p.validate(v[name], at, errs)
+		} else if s.additionalProperties != nil {
+			if a := s.additionalProperties; a.always != nil && !*a.always {
+				addError(errs, FieldError{Path: at, Message: "is not an allowed property"})
+			} else {
+				a.validate(v[name], at, errs)
+			}
+		}
+	}
+}
+
+func (s *Schema) validateArray(v []interface{}, path string, errs *[]FieldError, fail func(string, ...interface{})) {
+	if s.minItems != nil && len(v) < *s.minItems {
+		fail("must have at least %d items", *s.minItems)
+	}
+	if s.maxItems != nil && len(v) > *s.maxItems {
+		fail("must have at most %d items", *s.maxItems)
+	}
+	if s.uniqueItems {
+		seen := make(map[string]int, len(v))
+		for i, e := range v {
+			key := canonical(e)
+			if j, ok := seen[key]; ok {
+				fail("items %d and %d must not be equal", j, i)
+				break
+			}
+			seen[key] = i
+		}
+	}
+	if s.items != nil {
+		for i, e := range v {
+			s.items.validate(e, path+"/"+strconv.Itoa(i), errs)
+		}
+	}
+}
+
+func (s *Schema) validateNumber(v json.Number, fail func(string, ...interface{})) {
+	f, err := v.Float64()
+	if err != nil {
+		fail("is not a valid number")
+		return
+	}
+	if s.minimum != nil && f < *s.minimum {
+		fail("must be at least %g", *s.minimum)
+	}
+	if s.maximum != nil && f > *s.maximum {
+		fail("must be at most %g", *s.maximum)
+	}
+	if s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum {
+		fail("must be greater than %g", *s.exclusiveMinimum)
+	}
+	if s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum {
+		fail("must be less than %g", *s.exclusiveMaximum)
+	}
+	if s.multipleOf != nil {
+		q := f / *s.multipleOf
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			fail("must be a multiple of %g", *s.multipleOf)
+		}
+	}
+}
+
+func (s *Schema) validateString(v string, fail func(string, ...interface{})) {
+	n := utf8.RuneCountInString(v)
+	if s.minLength != nil && n < *s.minLength {
+		fail("must be at least %d characters", *s.minLength)
+	}
+	if s.maxLength != nil && n > *s.maxLength {
+		fail("must be at most %d characters", *s.maxLength)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(v) {
+		fail("must match pattern %s", s.pattern)
+	}
+}
+
+// addError adds a failed check unless maxErrors have been added
+func addError(errs *[]FieldError, e FieldError) {
+	if len(*errs) < maxErrors {
+		*errs = append(*errs, e)
+	}
+}
+
+// valid reports whether v passes the schema
+func (s *Schema) valid(v interface{}) bool {
+	var errs []FieldError
+	s.validate(v, "", &errs)
+	return len(errs) == 0
+}
+
+func hasType(v interface{}, types []string) bool {
+	for _, t := range types {
+		switch v := v.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case json.Number:
+			if t == "number" {
+				return true
+			}
+			// 1.0 is an integer too
+			if f, err := v.Float64(); t == "integer" && err == nil && f == math.Trunc(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// equal compares decoded JSON values, treating numbers by value
+func equal(a, b interface{}) bool {
+	na, aok := a.(json.Number)
+	nb, bok := b.(json.Number)
+	if aok && bok {
+		fa, errA := na.Float64()
+		fb, errB := nb.Float64()
+		return errA == nil && errB == nil && fa == fb
+	}
+
+	switch a := a.(type) {
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := b[k]; !ok || !equal(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// canonical encodes a decoded JSON value so that values are equal exactly
+// when their encodings are, as numbers are written by value and object keys
+// in order
+func canonical(v interface{}) string {
+	var b strings.Builder
+	writeCanonical(&b, v)
+	return b.String()
+}
+
+func writeCanonical(b *strings.Builder, v interface{}) {
+	switch v := v.(type) {
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			b.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+		} else {
+			b.WriteString(v.String())
+		}
+	case []interface{}:
+		b.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeCanonical(b, e)
+		}
+		b.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(strconv.Quote(k))
+			b.WriteByte(':')
+			writeCanonical(b, v[k])
+		}
+		b.WriteByte('}')
+	default:
+		b.WriteString(encode(v))
+	}
+}
+
+func containsEqual(values []interface{}, v interface{}) bool {
+	for _, e := range values {
+		if equal(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// decode parses a single JSON value, keeping numbers exact
+func decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return v, nil
+}
+
+func count(v interface{}) (int, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.Atoi(n.String())
+	return i, err == nil && i >= 0
+}
+
+func number(v interface{}) (float64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+func encode(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func encodeList(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = encode(v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// escape escapes a property name for use in a JSON Pointer
+func escape(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "schema"
+	}
+	return path
+}
@@ -0,0 +1,105 @@
+package jsonschema
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		keyword, schema, doc string
+		paths                []string // Paths of the expected errors; none if the document is valid
+	}{
+		{"true", `true`, `1`, nil},
+		{"false", `false`, `1`, []string{""}},
+		{"annotations", `{"title": "t", "description": "d", "default": 1}`, `"x"`, nil},
+
+		{"type", `{"type": "string"}`, `"x"`, nil},
+		{"type", `{"type": "string"}`, `1`, []string{""}},
+		{"type integer", `{"type": "integer"}`, `1.0`, nil},
+		{"type integer", `{"type": "integer"}`, `1.5`, []string{""}},
+		{"type list", `{"type": ["null", "boolean"]}`, `null`, nil},
+		{"enum", `{"enum": ["a", 1]}`, `1.0`, nil},
+		{"enum", `{"enum": ["a", 1]}`, `"b"`, []string{""}},
+		{"const", `{"const": {"a": [1]}}`, `{"a": [1]}`, nil},
+		{"const", `{"const": {"a": [1]}}`, `{"a": [2]}`, []string{""}},
+
+		{"properties", `{"properties": {"a": {"type": "string"}}}`, `{"a": "x"}`, nil},
+		{"properties", `{"properties": {"a": {"type": "string"}}}`, `{"a": 1}`, []string{"/a"}},
+		{"properties nested", `{"properties": {"a": {"items": {"properties": {"b/c": false}}}}}`, `{"a": [{}, {"b/c": 1}]}`, []string{"/a/1/b~1c"}},
+		{"required", `{"required": ["a", "b"]}`, `{"a": 1}`, []string{"/b"}},
+		{"required", `{"required": ["a"]}`, `[]`, nil},
+		{"additionalProperties", `{"properties": {"a": true}, "additionalProperties": false}`, `{"a": 1, "b": 2}`, []string{"/b"}},
+		{"additionalProperties", `{"additionalProperties": {"type": "number"}}`, `{"a": 1, "b": "x"}`, []string{"/b"}},
+		{"minProperties", `{"minProperties": 2}`, `{"a": 1}`, []string{""}},
+		{"maxProperties", `{"maxProperties": 1}`, `{"a": 1, "b": 2}`, []string{""}},
+
+		{"items", `{"items": {"type": "number"}}`, `[1, "x", 3]`, []string{"/1"}},
+		{"minItems", `{"minItems": 1}`, `[]`, []string{""}},
+		{"maxItems", `{"maxItems": 1}`, `[1, 2]`, []string{""}},
+		{"uniqueItems", `{"uniqueItems": true}`, `[1, "1", [1], {"a": 1}]`, nil},
+		{"uniqueItems", `{"uniqueItems": true}`, `[{"a": 1, "b": [2]}, {"b": [2.0], "a": 1.0}]`, []string{""}},
+		{"uniqueItems", `{"uniqueItems": true}`, `[0, 0, 0, 0]`, []string{""}},
+
+		{"minimum", `{"minimum": 1}`, `1`, nil},
+		{"minimum", `{"minimum": 1}`, `0.5`, []string{""}},
+		{"maximum", `{"maximum": 1}`, `2`, []string{""}},
+		{"exclusiveMinimum", `{"exclusiveMinimum": 1}`, `1`, []string{""}},
+		{"exclusiveMaximum", `{"exclusiveMaximum": 1}`, `1`, []string{""}},
+		{"multipleOf", `{"multipleOf": 0.5}`, `1.5`, nil},
+		{"multipleOf", `{"multipleOf": 0.5}`, `1.2`, []string{""}},
+
+		{"minLength", `{"minLength": 2}`, `"é"`, []string{""}},
+		{"maxLength", `{"maxLength": 2}`, `"éé"`, nil},
+		{"maxLength", `{"maxLength": 2}`, `"abc"`, []string{""}},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"abc"`, nil},
+		{"pattern", `{"pattern": "^[a-z]+$"}`, `"ab1"`, []string{""}},
+
+		{"allOf", `{"allOf": [{"minimum": 1}, {"maximum": 2}]}`, `3`, []string{""}},
+		{"anyOf", `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `1`, nil},
+		{"anyOf", `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `null`, []string{""}},
+		{"oneOf", `{"oneOf": [{"type": "number"}, {"minimum": 0}]}`, `-1`, nil},
+		{"oneOf", `{"oneOf": [{"type": "number"}, {"minimum": 0}]}`, `1`, []string{""}},
+		{"not", `{"not": {"type": "string"}}`, `"x"`, []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyword, func(t *testing.T) {
+			s, err := Compile([]byte(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var paths []string
+			for _, e := range s.Validate([]byte(tt.doc)) {
+				paths = append(paths, e.Path)
+			}
+			if strings.Join(paths, " ") != strings.Join(tt.paths, " ") {
+				t.Errorf("%s against %s: got errors at %q, want %q", tt.doc, tt.schema, paths, tt.paths)
+			}
+		})
+	}
+}
+
+func TestCompileRejectsUnknownKeywords(t *testing.T) {
+	for _, schema := range []string{
+		`{"format": "email"}`,
+		`{"properties": {"a": {"$ref": "#"}}}`,
+		`{"minimum": "1"}`,
+		`{"pattern": "("}`,
+		`[]`,
+	} {
+		if _, err := Compile([]byte(schema)); err == nil {
+			t.Errorf("%s compiled", schema)
+		}
+	}
+}
+
+func TestValidateCapsErrors(t *testing.T) {
+	s, err := Compile([]byte(`{"items": {"type": "string"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := "[" + strings.Repeat("1,", 10*maxErrors) + "1]"
+	if errs := s.Validate([]byte(doc)); len(errs) != maxErrors {
+		t.Fatalf("got %d errors, want %d", len(errs), maxErrors)
+	}
+}
@@ -0,0 +1,282 @@
+// Package keyring holds the master keys that encrypt job data at rest. Master
+// keys only wrap per-tenant data keys, which encrypt the data itself, so
+// rotating a master key re-wraps the data keys rather than the data.
+package keyring
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySize is the size of master and data keys, for AES-256
+const KeySize = 32
+
+// retireDelay is how long after a rotation no master key may be retired. A
+// server that read the previous primary just before the rotation has this
+// long to store the data key it wrapped, where retire will find it.
+const retireDelay = time.Minute
+
+// ErrUnknownKey is returned when unwrapping with a master key that is not in
+// the keyring
+var ErrUnknownKey = errors.New("unknown master key")
+
+// file is the JSON layout of a keyring file. Keys are base64-encoded.
+type file struct {
+	Primary   string            `json:"primary"` // Wraps new data keys
+	Keys      map[string][]byte `json:"keys"`
+	RotatedAt time.Time         `json:"rotated_at,omitempty"` // When Primary became the primary
+}
+
+// Keyring is a set of master keys loaded from a file. One of them, the
+// primary, wraps new data keys; the others are kept to unwrap older ones
+// until they are retired.
+type Keyring struct {
+	path string
+
+	mu        sync.RWMutex
+	primary   string
+	keys      map[string][]byte
+	rotatedAt time.Time
+}
+
+// Load reads a keyring file
+func Load(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Create writes a new keyring file with one master key. It fails if the file
+// exists.
+func Create(path string) (*Keyring, error) {
+	key, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{path: path, primary: "k1", keys: map[string][]byte{"k1": key}}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	if err := k.save(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *Keyring) load() error {
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("keyring %s: %w", k.path, err)
+	}
+	if _, ok := f.Keys[f.Primary]; !ok {
+		return fmt.Errorf("keyring %s: primary key %q is missing", k.path, f.Primary)
+	}
+	for id, key := range f.Keys {
+		if len(key) != KeySize {
+			return fmt.Errorf("keyring %s: key %q must be %d bytes", k.path, id, KeySize)
+		}
+	}
+
+	k.mu.Lock()
+	k.primary, k.keys, k.rotatedAt = f.Primary, f.Keys, f.RotatedAt
+	k.mu.Unlock()
+	return nil
+}
+
+// save writes the keyring to a temporary file and renames it over the
+// keyring file, so that servers reading it never see a partial file
+func (k *Keyring) save() error {
+	k.mu.RLock()
+	data, err := json.MarshalIndent(file{Primary: k.primary, Keys: k.keys, RotatedAt: k.rotatedAt}, "", "  ")
+	k.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(k.path), filepath.Base(k.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), k.path)
+}
+
+// Primary returns the ID of the master key that wraps new data keys
+func (k *Keyring) Primary() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary
+}
+
+// IDs returns the IDs of the master keys, oldest first
+func (k *Keyring) IDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return keyNumber(ids[i]) < keyNumber(ids[j]) })
+	return ids
+}
+
+// Rotate adds a master key, makes it the primary and saves the keyring. The
+// previous keys are kept.
+func (k *Keyring) Rotate() (string, error) {
+	key, err := NewKey()
+	if err != nil {
+		return "", err
+	}
+
+	k.mu.Lock()
+	next := 0
+	for id := range k.keys {
+		next = max(next, keyNumber(id))
+	}
+	id := "k" + strconv.Itoa(next+1)
+	k.keys[id] = key
+	k.primary = id
+	k.rotatedAt = time.Now()
+	k.mu.Unlock()
+
+	return id, k.save()
+}
+
+// Retire removes a master key that is not the primary and saves the
+// keyring. Data keys it still wraps can no longer be unwrapped. It fails for
+// retireDelay after a rotation, while servers may still be wrapping with the
+// previous primary.
+func (k *Keyring) Retire(id string) error {
+	k.mu.Lock()
+	if _, ok := k.keys[id]; !ok {
+		k.mu.Unlock()
+		return fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	if id == k.primary {
+		k.mu.Unlock()
+		return fmt.Errorf("cannot retire the primary key %q", id)
+	}
+	if wait := retireDelay - time.Since(k.rotatedAt); wait > 0 {
+		k.mu.Unlock()
+		return fmt.Errorf("servers may still be wrapping data keys with the previous primary; retry in %s",
+			wait.Round(time.Second))
+	}
+	delete(k.keys, id)
+	k.mu.Unlock()
+
+	return k.save()
+}
+
+// Wrap encrypts a data key with the primary master key. aad binds the
+// wrapped key to its owner. The keyring file is read first, so that a key
+// replaced as primary by a rotation in another process, and perhaps retired
+// since, is never used.
+func (k *Keyring) Wrap(dataKey, aad []byte) (string, []byte, error) {
+	if err := k.load(); err != nil {
+		return "", nil, err
+	}
+	k.mu.RLock()
+	id, key := k.primary, k.keys[k.primary]
+	k.mu.RUnlock()
+
+	wrapped, err := Seal(key, dataKey, aad)
+	return id, wrapped, err
+}
+
+// Unwrap decrypts a data key wrapped with the master key id. The keyring file
+// is read again for keys added since it was loaded, e.g. by a rotation.
+func (k *Keyring) Unwrap(id string, wrapped, aad []byte) ([]byte, error) {
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+
+	if !ok {
+		if err := k.load(); err != nil {
+			return nil, err
+		}
+		k.mu.RLock()
+		key, ok = k.keys[id]
+		k.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+		}
+	}
+	return Open(key, wrapped, aad)
+}
+
+// NewKey returns a random key
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+// Seal encrypts plaintext with AES-GCM and returns the nonce followed by the
+// ciphertext. aad is authenticated but not encrypted.
+func Seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// Open decrypts what Seal returned for the same key and aad
+func Open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// keyNumber returns the number of a key ID like k3, or 0 for other IDs
+func keyNumber(id string) int {
+	n, _ := strconv.Atoi(strings.TrimPrefix(id, "k"))
+	return n
+}
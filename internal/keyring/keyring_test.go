@@ -0,0 +1,63 @@
+package keyring
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrapUsesPrimaryRotatedElsewhere(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if _, err := Create(path); err != nil {
+		t.Fatal(err)
+	}
+	server, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The CLI rotates the keyring file while the server runs
+	cli, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary, err := cli.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, wrapped, err := server.Wrap([]byte("data key"), []byte("acme"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != primary {
+		t.Fatalf("wrapped with %s, want the new primary %s", id, primary)
+	}
+	if _, err := cli.Unwrap(id, wrapped, []byte("acme")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetireWaitsAfterRotation(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = k.Retire("k1")
+	if err == nil || !strings.Contains(err.Error(), "retry in") {
+		t.Fatalf("retiring right after a rotation: got %v", err)
+	}
+
+	k.rotatedAt = time.Now().Add(-retireDelay)
+	if err := k.Retire("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if ids := k.IDs(); len(ids) != 1 || ids[0] != "k2" {
+		t.Fatalf("keys after retiring k1: %v", ids)
+	}
+}
@@ -1,21 +1,41 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // Job represents a task in the queue
 type Job struct {
-	ID             string     `json:"id"`
-	TenantID       string     `json:"tenant_id"`
-	Payload        string     `json:"payload"`
-	Status         string     `json:"status"` // pending, running, done, failed
-	IdempotencyKey string     `json:"idempotency_key,omitempty"`
-	RetryCount     int        `json:"retry_count"`
-	MaxRetries     int        `json:"max_retries"`
-	CreatedAt      time.Time  `json:"created_at"`
-	UpdatedAt      time.Time  `json:"updated_at"`
-	LeasedUntil    *time.Time `json:"leased_until,omitempty"`
-	ErrorMessage   string     `json:"error_message,omitempty"`
-	TraceID        string     `json:"trace_id"`
+	ID              string          `json:"id"`
+	TenantID        string          `json:"tenant_id"`
+	Payload         json.RawMessage `json:"payload"`                    // See SetPayload
+	PayloadEncoding string          `json:"payload_encoding,omitempty"` // EncodingBase64 for binary payloads
+	ContentType     string          `json:"content_type,omitempty"`     // Of JSON and binary payloads
+	Status          string          `json:"status"`                     // pending, running, done, failed
+	IdempotencyKey  string          `json:"idempotency_key,omitempty"`
+	RetryCount      int             `json:"retry_count"`
+	MaxRetries      int             `json:"max_retries"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	LeasedUntil     *time.Time      `json:"leased_until,omitempty"`
+	ErrorMessage    string          `json:"error_message,omitempty"`
+	TraceID         string          `json:"trace_id"`
+	Traceparent     string          `json:"traceparent,omitempty"` // W3C trace context of the submission
+	Queue           string          `json:"queue"`
+	Type            string          `json:"type"`
+	LeasedBy        string          `json:"leased_by,omitempty"`      // ID of the worker holding the lease
+	SchemaVersion   int             `json:"schema_version,omitempty"` // Version of the type's schema the payload matched
+
+	Sealed *SealedJobData `json:"-"` // Encrypted payload and error message, until decrypted
+}
+
+// SealedJobData is the payload and error message of a job as they are stored
+// when encryption at rest is on
+type SealedJobData struct {
+	DataKey      int64  // ID of the tenant data key they are encrypted with
+	Payload      []byte // Nonce and ciphertext
+	ErrorMessage []byte // Nil when the job has no error message
 }
 
 // Metrics holds system metrics
@@ -27,16 +47,330 @@ type Metrics struct {
 	FailedJobs    int64 `json:"failed_jobs"`
 	DLQJobs       int64 `json:"dlq_jobs"`
 	TotalRetries  int64 `json:"total_retries"`
+
+	Pool    *PoolStats      `json:"pool,omitempty"` // Worker pool of the serving process
+	Tenants []TenantMetrics `json:"tenants,omitempty"`
+}
+
+// TenantMetrics describes how a tenant is being served by the scheduler
+type TenantMetrics struct {
+	TenantID       string  `json:"tenant_id"`
+	Weight         int     `json:"weight"`
+	Pending        int64   `json:"pending"`
+	Running        int64   `json:"running"`
+	Leased         int64   `json:"leased"`              // Leases granted since the tenant first ran
+	AvgWaitSecs    float64 `json:"avg_wait_seconds"`    // From queued to leased
+	MaxWaitSecs    float64 `json:"max_wait_seconds"`    // Longest wait of a leased job
+	OldestWaitSecs float64 `json:"oldest_wait_seconds"` // Age of the oldest pending job
+}
+
+// PoolStats describes the in-process worker pool and its autoscaler
+type PoolStats struct {
+	Size           int       `json:"size"`
+	MinSize        int       `json:"min_size"`
+	MaxSize        int       `json:"max_size"`
+	Autoscale      bool      `json:"autoscale"`
+	Backlog        int64     `json:"backlog"`
+	OldestWaitSecs float64   `json:"oldest_wait_seconds"`
+	ScaleUps       int64     `json:"scale_ups"`
+	ScaleDowns     int64     `json:"scale_downs"`
+	LastScaledAt   time.Time `json:"last_scaled_at,omitempty"`
+	LastDecision   string    `json:"last_decision,omitempty"`
+}
+
+// PoolResizeRequest changes the worker pool at runtime. Setting Size resizes
+// the pool and turns autoscaling off; Autoscale turns it back on.
+type PoolResizeRequest struct {
+	Size      *int  `json:"size,omitempty"`
+	MinSize   *int  `json:"min_size,omitempty"`
+	MaxSize   *int  `json:"max_size,omitempty"`
+	Autoscale *bool `json:"autoscale,omitempty"`
 }
 
 // JobSubmitRequest represents a job submission request
 type JobSubmitRequest struct {
-	TenantID       string `json:"tenant_id"`
-	Payload        string `json:"payload"`
-	IdempotencyKey string `json:"idempotency_key,omitempty"`
-	MaxRetries     int    `json:"max_retries,omitempty"`
+	TenantID       string          `json:"tenant_id"`
+	Payload        json.RawMessage `json:"payload"` // Any JSON value; a string is a text payload
+	IdempotencyKey string          `json:"idempotency_key,omitempty"`
+	MaxRetries     int             `json:"max_retries,omitempty"`
+	Queue          string          `json:"queue,omitempty"`
+	Type           string          `json:"type,omitempty"`
+	SchemaVersion  int             `json:"schema_version,omitempty"` // Validate against this version instead of the latest
+
+	// Binary payloads are sent as a base64 string with PayloadEncoding set to
+	// EncodingBase64, or as the payload part of a multipart submission
+	PayloadEncoding string `json:"payload_encoding,omitempty"`
+	ContentType     string `json:"content_type,omitempty"` // Of binary payloads, ContentTypeBinary by default
+}
+
+// JobSchema is a version of the JSON Schema that payloads of a job type must
+// match. Versions are numbered from 1 and never change once registered.
+type JobSchema struct {
+	Type      string          `json:"type"`
+	Version   int             `json:"version"`
+	Schema    json.RawMessage `json:"schema"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// TenantLimits are the quotas applied to a tenant. A zero field falls back to
+// the server default.
+type TenantLimits struct {
+	RatePerMinute   int `json:"rate_per_minute"`
+	Burst           int `json:"burst"`          // Submissions allowed at once after being idle
+	MaxConcurrent   int `json:"max_concurrent"` // Jobs running at once
+	MaxQueued       int `json:"max_queued"`     // Jobs waiting to run, including retries
+	MaxPayloadBytes int `json:"max_payload_bytes"`
+	Weight          int `json:"weight"` // Share of leases relative to other tenants with work
+}
+
+// Or returns l with its zero fields taken from defaults
+func (l TenantLimits) Or(defaults TenantLimits) TenantLimits {
+	or := func(v, d int) int {
+		if v == 0 {
+			return d
+		}
+		return v
+	}
+	return TenantLimits{
+		RatePerMinute:   or(l.RatePerMinute, defaults.RatePerMinute),
+		Burst:           or(l.Burst, defaults.Burst),
+		MaxConcurrent:   or(l.MaxConcurrent, defaults.MaxConcurrent),
+		MaxQueued:       or(l.MaxQueued, defaults.MaxQueued),
+		MaxPayloadBytes: or(l.MaxPayloadBytes, defaults.MaxPayloadBytes),
+		Weight:          or(l.Weight, defaults.Weight),
+	}
 }
 
+// Tenant holds the limits configured for a tenant. Tenants without a record
+// get the server defaults.
+type Tenant struct {
+	ID string `json:"id"`
+	TenantLimits
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Effective *TenantLimits `json:"effective,omitempty"` // Limits after applying defaults
+}
+
+// APIKey describes an API key. The secret key itself is only returned when the
+// key is created or rotated.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	TenantID   string     `json:"tenant_id,omitempty"` // Empty for keys acting across tenants
+	Role       string     `json:"role"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// DataKey describes a tenant's data key, which encrypts its jobs at rest. The
+// key itself never leaves the database unwrapped.
+type DataKey struct {
+	ID        int64     `json:"id"`
+	TenantID  string    `json:"tenant_id"`
+	MasterKey string    `json:"master_key"` // Keyring key that wraps it
+	Current   bool      `json:"current"`    // Newest key of the tenant, used for new jobs
+	Jobs      int64     `json:"jobs"`       // Jobs encrypted with it
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIKeyRequest creates an API key
+type APIKeyRequest struct {
+	Name     string `json:"name"`
+	TenantID string `json:"tenant_id,omitempty"`
+	Role     string `json:"role"`
+}
+
+// APIKeySecret is an API key together with its secret key
+type APIKeySecret struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// AuditEntry records a change made through the API, or an attempt refused
+// for lack of permission. Before and After hold only the fields that changed;
+// Before is empty for creations and After for deletions.
+type AuditEntry struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Actor      string          `json:"actor"` // Name of the API key
+	KeyID      string          `json:"key_id,omitempty"`
+	Role       string          `json:"role"`
+	SourceIP   string          `json:"source_ip"`
+	RequestID  string          `json:"request_id"`
+	Permission string          `json:"permission"`
+	Action     string          `json:"action"` // e.g. tenant.update
+	Target     string          `json:"target"` // e.g. tenant:acme
+	Outcome    string          `json:"outcome"`
+	Status     int             `json:"status"` // HTTP status of the response
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+}
+
+// MetricsHistory holds job statistics over time in points of StepSeconds,
+// per queue and tenant and in total
+type MetricsHistory struct {
+	From        time.Time       `json:"from"`
+	To          time.Time       `json:"to"`
+	StepSeconds int64           `json:"step_seconds"`
+	Total       []MetricsPoint  `json:"total"`
+	Series      []MetricsSeries `json:"series"`
+}
+
+// MetricsSeries holds the statistics of one queue and tenant
+type MetricsSeries struct {
+	Queue    string         `json:"queue"`
+	TenantID string         `json:"tenant_id"`
+	Points   []MetricsPoint `json:"points"`
+}
+
+// MetricsPoint holds the statistics of the step starting at Time. Wait and
+// run time percentiles are in seconds and null when nothing was measured.
+// Points without any activity are left out.
+type MetricsPoint struct {
+	Time      time.Time `json:"time"`
+	Enqueued  int64     `json:"enqueued"`
+	Completed int64     `json:"completed"`
+	Failed    int64     `json:"failed"` // Failed attempts, including those moved to the DLQ
+	WaitP50   *float64  `json:"wait_p50"`
+	WaitP95   *float64  `json:"wait_p95"`
+	WaitP99   *float64  `json:"wait_p99"`
+	RunP50    *float64  `json:"run_p50"`
+	RunP95    *float64  `json:"run_p95"`
+	RunP99    *float64  `json:"run_p99"`
+}
+
+// JobLogLine is a line a handler logged while running a job
+type JobLogLine struct {
+	ID      int64           `json:"id"`
+	Attempt int             `json:"attempt"`
+	Time    time.Time       `json:"time"`
+	Level   string          `json:"level"`
+	Message string          `json:"message"`
+	Attrs   json.RawMessage `json:"attrs,omitempty"`
+}
+
+// Audit outcomes
+const (
+	AuditSucceeded = "succeeded"
+	AuditFailed    = "failed"
+	AuditDenied    = "denied"
+)
+
+// AuditFilter selects audit entries, newest first. Empty fields match
+// everything; BeforeID continues a previous page.
+type AuditFilter struct {
+	KeyID    string
+	Action   string
+	Target   string
+	Outcome  string
+	Since    time.Time
+	Until    time.Time
+	BeforeID int64
+	Limit    int
+}
+
+// WorkerInfo describes a worker registered with the server
+type WorkerInfo struct {
+	ID            string    `json:"id"`
+	Queues        []string  `json:"queues"` // Empty means any queue
+	Types         []string  `json:"types"`  // Empty means any job type
+	Status        string    `json:"status"` // alive, dead, stopped
+	CurrentJob    string    `json:"current_job,omitempty"`
+	JobsProcessed int64     `json:"jobs_processed"`
+	JobsFailed    int64     `json:"jobs_failed"`
+	RegisteredAt  time.Time `json:"registered_at"` // Time the worker (re)started
+	LastHeartbeat time.Time `json:"last_heartbeat"`
+	Owner         string    `json:"-"` // Identity that registered a remote worker; empty for in-process workers
+}
+
+// WorkerRegisterRequest registers a remote worker. The server assigns an ID
+// when none is given.
+type WorkerRegisterRequest struct {
+	ID     string   `json:"id,omitempty"`
+	Queues []string `json:"queues,omitempty"`
+	Types  []string `json:"types,omitempty"`
+}
+
+// WorkerLeaseRequest asks the server for the next job a worker can run
+type WorkerLeaseRequest struct {
+	WorkerID     string `json:"worker_id"`
+	LeaseSeconds int    `json:"lease_seconds,omitempty"`
+}
+
+// WorkerAckRequest reports the outcome of a leased job. Error is only used by
+// nack.
+type WorkerAckRequest struct {
+	WorkerID string `json:"worker_id"`
+	JobID    string `json:"job_id"`
+	Error    string `json:"error,omitempty"`
+}
+
+// WorkerLogRequest ships lines a remote worker captured from the handler of
+// a job it holds the lease on
+type WorkerLogRequest struct {
+	WorkerID string       `json:"worker_id"`
+	JobID    string       `json:"job_id"`
+	Lines    []JobLogLine `json:"lines"`
+}
+
+// WorkerHeartbeatRequest keeps a worker registration alive and, when JobID is
+// set, extends the lease on the job it is running
+type WorkerHeartbeatRequest struct {
+	WorkerID     string `json:"worker_id"`
+	JobID        string `json:"job_id,omitempty"`
+	LeaseSeconds int    `json:"lease_seconds,omitempty"`
+}
+
+// AlertRule raises an alert when a metric crosses a threshold and keeps
+// doing so for ForSeconds. Rules are identified by their name.
+type AlertRule struct {
+	Name          string      `json:"name"`
+	Description   string      `json:"description,omitempty"`
+	Metric        string      `json:"metric"`           // e.g. backlog, dlq or failure_rate
+	Change        bool        `json:"change,omitempty"` // Compare the change over the window instead of the value
+	Op            string      `json:"op"`               // >, >=, < or <=
+	Threshold     float64     `json:"threshold"`
+	WindowSeconds int         `json:"window_seconds,omitempty"` // Span of counted metrics and of Change
+	ForSeconds    int         `json:"for_seconds,omitempty"`    // How long the condition must hold before firing
+	GroupBy       string      `json:"group_by,omitempty"`       // queue or tenant for one alert per group
+	Queue         string      `json:"queue,omitempty"`          // Only this queue
+	TenantID      string      `json:"tenant_id,omitempty"`      // Only this tenant
+	Notify        []AlertSink `json:"notify,omitempty"`         // Defaults to the log and the dashboard
+	Disabled      bool        `json:"disabled,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+}
+
+// AlertSink is where alerts of a rule are sent when they fire and resolve
+type AlertSink struct {
+	Type string `json:"type"`          // log, websocket or webhook
+	URL  string `json:"url,omitempty"` // Webhook URL
+}
+
+// Alert is the state of a rule for one group
+type Alert struct {
+	Rule       string     `json:"rule"`
+	Queue      string     `json:"queue,omitempty"`
+	TenantID   string     `json:"tenant_id,omitempty"`
+	State      string     `json:"state"` // pending, firing or resolved
+	Summary    string     `json:"summary"`
+	Value      float64    `json:"value"` // At the last evaluation
+	Threshold  float64    `json:"threshold"`
+	ActiveAt   time.Time  `json:"active_at"` // When the condition started to hold
+	FiredAt    *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Alert state constants
+const (
+	AlertPending  = "pending" // Condition holds, but not for long enough yet
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
 // Status constants
 const (
 	StatusPending = "pending"
@@ -45,3 +379,16 @@ const (
 	StatusFailed  = "failed"
 )
 
+// Worker status constants
+const (
+	WorkerAlive   = "alive"
+	WorkerDead    = "dead"    // Missed heartbeats; its leases were reclaimed
+	WorkerStopped = "stopped" // Shut down cleanly
+)
+
+// DefaultQueue and DefaultType are used when a job is submitted without them
+const (
+	DefaultQueue = "default"
+	DefaultType  = "default"
+)
+
@@ -0,0 +1,105 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"strings"
+)
+
+// Payload content types
+const (
+	ContentTypeJSON   = "application/json"
+	ContentTypeBinary = "application/octet-stream"
+)
+
+// EncodingBase64 is the encoding of binary payloads in JSON
+const EncodingBase64 = "base64"
+
+// IsJSONContentType reports whether a content type is JSON, such as
+// application/json or application/problem+json
+func IsJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == ContentTypeJSON ||
+		strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
+}
+
+// SetPayload sets a job's payload from its data and content type. Without a
+// content type the data is text and the payload is a JSON string. With a JSON
+// content type the payload is the JSON itself. Any other content type makes
+// a binary payload, a base64 JSON string.
+func (j *Job) SetPayload(data []byte, contentType string) {
+	j.ContentType = contentType
+	j.PayloadEncoding = ""
+
+	switch {
+	case contentType == "":
+		j.Payload, _ = json.Marshal(string(data))
+	case IsJSONContentType(contentType) && json.Valid(data):
+		j.Payload = json.RawMessage(data)
+	default:
+		j.Payload, _ = json.Marshal(data)
+		j.PayloadEncoding = EncodingBase64
+	}
+}
+
+// PayloadData returns the data of a job's payload: the text of a text
+// payload, the JSON of a JSON payload or the bytes of a binary one
+func (j *Job) PayloadData() ([]byte, error) {
+	switch {
+	case j.PayloadEncoding == EncodingBase64:
+		var data []byte
+		err := json.Unmarshal(j.Payload, &data)
+		return data, err
+	case j.PayloadEncoding != "":
+		return nil, errors.New("unknown payload encoding " + j.PayloadEncoding)
+	case j.ContentType == "":
+		var text string
+		err := json.Unmarshal(j.Payload, &text)
+		return []byte(text), err
+	default:
+		return j.Payload, nil
+	}
+}
+
+// PayloadData returns the data and content type of a submission's payload.
+// A JSON string is a text payload and has no content type; any other JSON
+// value is a JSON payload. With PayloadEncoding set the payload is a base64
+// string of binary data.
+func (req *JobSubmitRequest) PayloadData() ([]byte, string, error) {
+	switch req.PayloadEncoding {
+	case "":
+		if req.ContentType != "" {
+			return nil, "", errors.New("content_type requires payload_encoding base64")
+		}
+		if len(req.Payload) == 0 {
+			return nil, "", nil
+		}
+		// A null payload unmarshals as empty text, and is missing
+		var text string
+		if err := json.Unmarshal(req.Payload, &text); err == nil {
+			return []byte(text), "", nil
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, req.Payload); err != nil {
+			return nil, "", err
+		}
+		return compact.Bytes(), ContentTypeJSON, nil
+	case EncodingBase64:
+		var data []byte
+		if err := json.Unmarshal(req.Payload, &data); err != nil {
+			return nil, "", errors.New("payload must be a base64 string")
+		}
+		contentType := req.ContentType
+		if contentType == "" {
+			contentType = ContentTypeBinary
+		}
+		if IsJSONContentType(contentType) && !json.Valid(data) {
+			return nil, "", errors.New("payload is not valid JSON")
+		}
+		return data, contentType, nil
+	default:
+		return nil, "", errors.New("payload_encoding must be base64")
+	}
+}
@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"distributed-task-queue/internal/database"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DBLimiter is a Limiter whose buckets live in the database, so limits survive
+// restarts and hold across all servers sharing the database
+type DBLimiter struct {
+	db *database.DB
+
+	mu        sync.Mutex
+	lastEvict time.Time
+}
+
+// NewDBLimiter creates a Limiter backed by db
+func NewDBLimiter(db *database.DB) *DBLimiter {
+	return &DBLimiter{db: db, lastEvict: time.Now()}
+}
+
+// Allow takes a token from a tenant's bucket if one is available
+func (l *DBLimiter) Allow(tenantID string, perMinute, burst int) (Result, error) {
+	now := time.Now()
+	l.maybeEvict(now)
+
+	capacity, perSec := rates(perMinute, burst)
+	allowed, tokens, err := l.db.TakeRateToken(tenantID, capacity, perSec, now)
+	if err != nil {
+		return Result{}, err
+	}
+	return result(allowed, tokens, capacity, perSec, now), nil
+}
+
+// maybeEvict deletes full buckets at most once per evictInterval
+func (l *DBLimiter) maybeEvict(now time.Time) {
+	l.mu.Lock()
+	if now.Sub(l.lastEvict) < evictInterval {
+		l.mu.Unlock()
+		return
+	}
+	l.lastEvict = now
+	l.mu.Unlock()
+
+	if _, err := l.db.DeleteFullRateBuckets(now); err != nil {
+		slog.Error("Failed to evict rate limit buckets", "err", err)
+	}
+}
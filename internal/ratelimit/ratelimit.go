@@ -1,47 +1,122 @@
 package ratelimit
 
 import (
+	"math"
 	"sync"
 	"time"
 )
 
-// RateLimiter manages per-tenant rate limits using token bucket algorithm
+// evictInterval is how often buckets that have refilled completely are
+// dropped. A full bucket behaves the same as a missing one.
+const evictInterval = time.Minute
+
+// Limiter decides whether a tenant may submit a job. Each tenant has a token
+// bucket holding up to burst tokens that refills at perMinute tokens per
+// minute.
+type Limiter interface {
+	Allow(tenantID string, perMinute, burst int) (Result, error)
+}
+
+// Result describes a rate limit decision
+type Result struct {
+	Allowed    bool
+	Limit      int           // Burst size
+	Remaining  int           // Whole tokens left after this request
+	Reset      time.Time     // When the bucket will be full again
+	RetryAfter time.Duration // Until the next token, when not allowed
+}
+
+// RateLimiter is an in-memory Limiter. Its state is lost on restart and is
+// not shared between processes.
 type RateLimiter struct {
-	mu              sync.Mutex
-	tenantTokens    map[string]int
-	tenantLastReset map[string]time.Time
-	maxJobsPerMin   int
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastEvict time.Time
+}
+
+type bucket struct {
+	tokens   float64
+	updated  time.Time
+	capacity float64
+	perSec   float64
 }
 
 // New creates a new RateLimiter
-func New(maxJobsPerMin int) *RateLimiter {
+func New() *RateLimiter {
 	return &RateLimiter{
-		tenantTokens:    make(map[string]int),
-		tenantLastReset: make(map[string]time.Time),
-		maxJobsPerMin:   maxJobsPerMin,
+		buckets:   make(map[string]*bucket),
+		lastEvict: time.Now(),
 	}
 }
 
-// Allow checks if a tenant is allowed to submit a job
-func (rl *RateLimiter) Allow(tenantID string) bool {
+// Allow takes a token from a tenant's bucket if one is available
+func (rl *RateLimiter) Allow(tenantID string, perMinute, burst int) (Result, error) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
 	now := time.Now()
-	lastReset, exists := rl.tenantLastReset[tenantID]
+	if now.Sub(rl.lastEvict) >= evictInterval {
+		rl.evict(now)
+	}
+
+	capacity, perSec := rates(perMinute, burst)
 
-	// Reset tokens if a minute has passed
-	if !exists || now.Sub(lastReset) > time.Minute {
-		rl.tenantTokens[tenantID] = rl.maxJobsPerMin
-		rl.tenantLastReset[tenantID] = now
+	b, exists := rl.buckets[tenantID]
+	if !exists {
+		b = &bucket{tokens: capacity, updated: now, capacity: capacity, perSec: perSec}
+		rl.buckets[tenantID] = b
 	}
+	b.refill(now)
+	// Limits may have changed since the bucket was last used
+	b.capacity, b.perSec = capacity, perSec
+	b.tokens = math.Min(b.tokens, capacity)
 
-	// Check and consume token
-	if rl.tenantTokens[tenantID] > 0 {
-		rl.tenantTokens[tenantID]--
-		return true
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
 	}
+	return result(allowed, b.tokens, capacity, perSec, now), nil
+}
 
-	return false
+// refill adds the tokens earned since the bucket was last updated
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.perSec)
+		b.updated = now
+	}
 }
 
+// evict drops the buckets that have refilled completely
+func (rl *RateLimiter) evict(now time.Time) {
+	for tenantID, b := range rl.buckets {
+		b.refill(now)
+		if b.tokens >= b.capacity {
+			delete(rl.buckets, tenantID)
+		}
+	}
+	rl.lastEvict = now
+}
+
+// rates returns the bucket size and refill rate per second of a limit
+func rates(perMinute, burst int) (capacity, perSec float64) {
+	return float64(max(burst, 1)), float64(max(perMinute, 1)) / 60
+}
+
+// result describes a bucket left with tokens after a decision
+func result(allowed bool, tokens, capacity, perSec float64, now time.Time) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     int(capacity),
+		Remaining: int(tokens),
+		Reset:     now.Add(seconds((capacity - tokens) / perSec)),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / perSec)
+	}
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,178 @@
+// Package tlscert builds the TLS configurations of servers and workers and
+// reloads server certificates when their files are renewed
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate loaded from a certificate and key file and
+// replaces it when the files change, so renewed certificates are picked up
+// without a restart
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modified time.Time // Latest modification time of the loaded files
+}
+
+// NewReloader loads the certificate and key pair
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate and key files again. The current certificate
+// is kept if they cannot be loaded.
+func (r *Reloader) Reload() error {
+	modified, err := r.modTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modified = modified
+	r.mu.Unlock()
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch reloads the certificate whenever its files change, checking every
+// interval until ctx is done
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modified, err := r.modTime()
+		if err != nil {
+			slog.Error("Failed to check certificate files", "err", err)
+			continue
+		}
+		r.mu.RLock()
+		changed := modified.After(r.modified)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		// Renewals often write the certificate and key separately; a
+		// mismatched pair is retried on the next check
+		if err := r.Reload(); err != nil {
+			slog.Warn("Keeping current certificate", "err", err)
+			continue
+		}
+		slog.Info("Reloaded certificate", "file", r.certFile)
+	}
+}
+
+// modTime returns the latest modification time of the certificate and key
+func (r *Reloader) modTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// ServerConfig returns a server TLS configuration serving r's certificate.
+// When clientCAFile is set, client certificates signed by it are verified,
+// and required if requireClientCert is set.
+func ServerConfig(r *Reloader, clientCAFile string, requireClientCert bool) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+
+	pool, err := loadPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if requireClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// ClientConfig returns a client TLS configuration trusting the CA in caFile,
+// or the system roots if it is empty, and presenting the certificate in
+// certFile and keyFile if they are set. It returns nil if nothing is set.
+func ClientConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("a client certificate needs both a certificate and a key file")
+	}
+
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := loadPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// loadPool reads PEM encoded CA certificates
+func loadPool(name string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", name)
+	}
+	return pool, nil
+}
@@ -0,0 +1,275 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// authority is a CA that issues certificates into a test's directory
+type authority struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM file of the CA certificate
+}
+
+func newAuthority(t *testing.T) *authority {
+	t.Helper()
+	key := newKey(t)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &authority{t: t, dir: t.TempDir(), cert: cert, key: key}
+	ca.file = filepath.Join(ca.dir, "ca.pem")
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+var (
+	serial  int64 = 1
+	written int
+)
+
+// issue writes a certificate of key for 127.0.0.1 and subject to name.pem
+// and key to name-key.pem, and returns the certificate's serial number
+func (ca *authority) issue(name, subject string, key *ecdsa.PrivateKey) int64 {
+	t := ca.t
+	t.Helper()
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: subject},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, ca.path(name), "CERTIFICATE", der)
+	writePEM(t, ca.path(name+"-key"), "EC PRIVATE KEY", keyDER)
+	return serial
+}
+
+func (ca *authority) path(name string) string {
+	return filepath.Join(ca.dir, name+".pem")
+}
+
+// copy replaces the file to with the file from
+func (ca *authority) copy(from, to string) {
+	t := ca.t
+	t.Helper()
+	data, err := os.ReadFile(ca.path(from))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	writePEM(t, ca.path(to), block.Type, block.Bytes)
+}
+
+// writePEM writes a PEM block, dated a second later than any earlier write
+// so that the change is seen on file systems with coarse modification times
+func writePEM(t *testing.T, name, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(name, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	written++
+	mtime := time.Now().Add(time.Duration(written) * time.Second)
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedSerial returns the serial number of the certificate r serves
+func servedSerial(t *testing.T, r *Reloader) int64 {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestWatchReloadsReplacedCertificate(t *testing.T) {
+	ca := newAuthority(t)
+	first := ca.issue("server", "server", newKey(t))
+	r, err := NewReloader(ca.path("server"), ca.path("server-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	// A certificate written without its new key is not served
+	second := ca.issue("renewed", "server", newKey(t))
+	ca.copy("renewed", "server")
+	time.Sleep(100 * time.Millisecond)
+	if got := servedSerial(t, r); got != first {
+		t.Fatalf("serving certificate %d without its key, want %d", got, first)
+	}
+
+	// and is served once the key is
+	ca.copy("renewed-key", "server-key")
+	deadline := time.Now().Add(5 * time.Second)
+	for servedSerial(t, r) != second {
+		if time.Now().After(deadline) {
+			t.Fatalf("still serving certificate %d, want %d", servedSerial(t, r), second)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloadKeepsCertificateOnMismatchedPair(t *testing.T) {
+	ca := newAuthority(t)
+	first := ca.issue("server", "server", newKey(t))
+	r, err := NewReloader(ca.path("server"), ca.path("server-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := os.ReadFile(ca.path("server-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.issue("server", "server", newKey(t))
+	if err := os.WriteFile(ca.path("server-key"), key, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Reload(); err == nil {
+		t.Fatal("reloaded a certificate with another certificate's key")
+	}
+	if got := servedSerial(t, r); got != first {
+		t.Fatalf("serving certificate %d, want %d", got, first)
+	}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	ca := newAuthority(t)
+	ca.issue("server", "server", newKey(t))
+	ca.issue("client", "worker", newKey(t))
+	r, err := NewReloader(ca.path("server"), ca.path("server-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ServerConfig(r, ca.file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		TLSConfig: cfg,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+		}),
+		ErrorLog: log.New(io.Discard, "", 0),
+	}
+	go srv.ServeTLS(ln, "", "")
+	t.Cleanup(func() { srv.Close() })
+	url := "https://" + ln.Addr().String()
+
+	get := func(certFile, keyFile string) (string, error) {
+		clientCfg, err := ClientConfig(ca.file, certFile, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+		defer client.CloseIdleConnections()
+		resp, err := client.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	if _, err := get("", ""); err == nil {
+		t.Fatal("a client without a certificate connected")
+	}
+	subject, err := get(ca.path("client"), ca.path("client-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "worker" {
+		t.Fatalf("server saw client %q, want worker", subject)
+	}
+
+	// Certificates from another CA are rejected too
+	other := newAuthority(t)
+	other.issue("client", "worker", newKey(t))
+	if _, err := get(other.path("client"), other.path("client-key")); err == nil {
+		t.Fatal("a client with a certificate from another CA connected")
+	}
+}
+
+func TestClientConfigNeedsCertificateAndKey(t *testing.T) {
+	if cfg, err := ClientConfig("", "", ""); cfg != nil || err != nil {
+		t.Fatalf("got %v, %v without any files, want nil", cfg, err)
+	}
+	if _, err := ClientConfig("", "client.pem", ""); err == nil {
+		t.Fatal("accepted a client certificate without a key")
+	}
+}
@@ -0,0 +1,141 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"distributed-task-queue/internal/api"
+	"distributed-task-queue/internal/config"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"distributed-task-queue/internal/tracing"
+	"distributed-task-queue/internal/websocket"
+	"distributed-task-queue/internal/worker"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// syncBuffer is a buffer that spans can be read from while they are written
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// spans decodes the spans written so far by name
+func (b *syncBuffer) spans(t *testing.T) map[string]tracing.Span {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	spans := make(map[string]tracing.Span)
+	dec := json.NewDecoder(bytes.NewReader(b.buf.Bytes()))
+	for dec.More() {
+		var s tracing.Span
+		if err := dec.Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+		spans[s.Name] = s
+	}
+	return spans
+}
+
+func TestJobSpansContinueTheCallersTrace(t *testing.T) {
+	var buf syncBuffer
+	tracing.SetExporter(tracing.NewWriterExporter(&buf))
+	t.Cleanup(func() { tracing.SetExporter(nil) })
+
+	db, err := database.New(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	wsManager := websocket.New(db)
+	t.Cleanup(wsManager.Close)
+	mux := http.NewServeMux()
+	api.NewServer(db, wsManager, config.Default()).SetupRoutes(mux)
+
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		callerSpanID = "00f067aa0ba902b7"
+	)
+	r := httptest.NewRequest("POST", "/api/jobs", strings.NewReader(`{"tenant_id": "acme", "payload": "x"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("traceparent", "00-"+traceID+"-"+callerSpanID+"-01")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("submission returned %d: %s", w.Code, w.Body)
+	}
+	var job models.Job
+	if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the job a measurable wait before a worker leases it
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	wk := worker.NewWithSource(worker.LocalID(1), worker.NewDBSource(db), config.Default().Workers, ctx)
+	wk.Handle(worker.AnyType, func(ctx context.Context, job *models.Job) error { return nil })
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		wk.Start()
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	names := []string{"job.submit", "job.queue_wait", "job.lease", "job.execute"}
+	deadline := time.Now().Add(5 * time.Second)
+	spans := buf.spans(t)
+	for len(spans) < len(names) {
+		if time.Now().After(deadline) {
+			t.Fatalf("exported %v, want %v", spans, names)
+		}
+		time.Sleep(10 * time.Millisecond)
+		spans = buf.spans(t)
+	}
+
+	submit := spans["job.submit"]
+	if submit.ParentSpanID != callerSpanID {
+		t.Errorf("submission span has parent %q, want the caller's %s", submit.ParentSpanID, callerSpanID)
+	}
+	if job.TraceID != traceID {
+		t.Errorf("job has trace ID %s, want %s", job.TraceID, traceID)
+	}
+	for _, name := range names {
+		span, ok := spans[name]
+		switch {
+		case !ok:
+			t.Errorf("%s was not exported", name)
+		case span.TraceID != traceID:
+			t.Errorf("%s has trace ID %s, want %s", name, span.TraceID, traceID)
+		case name != "job.submit" && span.ParentSpanID != submit.SpanID:
+			t.Errorf("%s has parent %q, want the submission span %s", name, span.ParentSpanID, submit.SpanID)
+		case span.Attributes["job.id"] != job.ID:
+			t.Errorf("%s is for job %q, want %s", name, span.Attributes["job.id"], job.ID)
+		}
+	}
+}
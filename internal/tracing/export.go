@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// WriterExporter writes each span as a line of JSON
+type WriterExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewWriterExporter creates an exporter writing to w
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{enc: json.NewEncoder(w)}
+}
+
+// Export implements Exporter
+func (e *WriterExporter) Export(span *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.enc.Encode(span); err != nil {
+		slog.Error("Failed to export span", "span_id", span.SpanID, "err", err)
+	}
+}
+
+// Open returns the exporter for an output setting: "" disables tracing,
+// "stdout" writes spans to standard output and anything else is a file that
+// spans are appended to. The returned closer must be called on shutdown.
+func Open(output string) (Exporter, io.Closer, error) {
+	switch output {
+	case "":
+		return nil, io.NopCloser(nil), nil
+	case "stdout":
+		return NewWriterExporter(os.Stdout), io.NopCloser(nil), nil
+	}
+
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewWriterExporter(f), f, nil
+}
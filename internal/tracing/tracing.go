@@ -0,0 +1,178 @@
+// Package tracing follows jobs from submission through execution with W3C
+// trace context (https://www.w3.org/TR/trace-context/) and hands finished
+// spans to an Exporter
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// ParseTraceparent parses a traceparent header. It reports false for missing
+// or malformed values, in which case a new trace should be started.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	// Version 00 has exactly four fields; later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(parts[0])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, false
+	}
+	if !sc.Valid() {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// Valid reports whether the trace and span IDs are set
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a traceparent header, or "" if it is not valid
+func (sc SpanContext) Traceparent() string {
+	if !sc.Valid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// TraceIDString returns the trace ID in hex
+func (sc SpanContext) TraceIDString() string {
+	return hex.EncodeToString(sc.TraceID[:])
+}
+
+// Span is a timed operation within a trace
+type Span struct {
+	Name         string            `json:"name"`
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	StartTime    time.Time         `json:"start"`
+	EndTime      time.Time         `json:"end"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Error        string            `json:"error,omitempty"`
+
+	sc SpanContext
+}
+
+// Start begins a span named name at start. It continues the trace of parent,
+// or starts a new sampled trace if parent is not valid.
+func Start(name string, parent SpanContext, start time.Time) *Span {
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+	s := &Span{Name: name, StartTime: start}
+	if parent.Valid() {
+		s.ParentSpanID = hex.EncodeToString(parent.SpanID[:])
+	} else {
+		rand.Read(sc.TraceID[:])
+		sc.Sampled = true
+	}
+	rand.Read(sc.SpanID[:])
+
+	s.sc = sc
+	s.TraceID = hex.EncodeToString(sc.TraceID[:])
+	s.SpanID = hex.EncodeToString(sc.SpanID[:])
+	return s
+}
+
+// Context returns the span's context, to propagate to its children
+func (s *Span) Context() SpanContext {
+	return s.sc
+}
+
+// SetAttribute records a key and value on the span
+func (s *Span) SetAttribute(key, value string) {
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = value
+}
+
+// End finishes the span now, see EndAt
+func (s *Span) End(err error) {
+	s.EndAt(time.Now(), err)
+}
+
+// EndAt finishes the span at end, recording err if it is not nil, and
+// exports it if its trace is sampled
+func (s *Span) EndAt(end time.Time, err error) {
+	s.EndTime = end
+	if err != nil {
+		s.Error = err.Error()
+	}
+	if !s.sc.Sampled {
+		return
+	}
+
+	exporterMu.RLock()
+	e := exporter
+	exporterMu.RUnlock()
+	if e != nil {
+		e.Export(s)
+	}
+}
+
+// Exporter receives finished spans. Export must be safe for concurrent use
+// and should not block for long.
+type Exporter interface {
+	Export(span *Span)
+}
+
+var (
+	exporterMu sync.RWMutex
+	exporter   Exporter
+)
+
+// SetExporter sets where finished spans go; nil discards them
+func SetExporter(e Exporter) {
+	exporterMu.Lock()
+	defer exporterMu.Unlock()
+	exporter = e
+}
+
+type contextKey struct{}
+
+// WithSpanContext returns a copy of ctx carrying sc, so that handlers can
+// continue a job's trace
+func WithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// FromContext returns the span context carried by ctx, if any
+func FromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(contextKey{}).(SpanContext)
+	return sc
+}
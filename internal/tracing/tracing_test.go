@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name, header string
+		ok, sampled  bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"unsampled", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"other flags", "00-" + traceID + "-" + spanID + "-03", true, true},
+		{"surrounding space", " 00-" + traceID + "-" + spanID + "-01 ", true, true},
+		{"later version", "01-" + traceID + "-" + spanID + "-01", true, true},
+		{"later version with more fields", "01-" + traceID + "-" + spanID + "-01-extra", true, true},
+
+		{"empty", "", false, false},
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"version 00 with more fields", "00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"zero trace ID", "00-" + strings.Repeat("0", 32) + "-" + spanID + "-01", false, false},
+		{"zero span ID", "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01", false, false},
+		{"short trace ID", "00-" + traceID[1:] + "-" + spanID + "-01", false, false},
+		{"short span ID", "00-" + traceID + "-" + spanID[1:] + "-01", false, false},
+		{"not hex", "00-" + strings.Repeat("g", 32) + "-" + spanID + "-01", false, false},
+		{"bad flags", "00-" + traceID + "-" + spanID + "-0x", false, false},
+		{"missing flags", "00-" + traceID + "-" + spanID, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(tt.header)
+			if ok != tt.ok {
+				t.Fatalf("got ok %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if sc.Sampled != tt.sampled {
+				t.Errorf("got sampled %v, want %v", sc.Sampled, tt.sampled)
+			}
+			if sc.TraceIDString() != traceID {
+				t.Errorf("got trace ID %s, want %s", sc.TraceIDString(), traceID)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, sampled := range []bool{true, false} {
+		sc := Start("op", SpanContext{}, time.Now()).Context()
+		sc.Sampled = sampled
+		parsed, ok := ParseTraceparent(sc.Traceparent())
+		if !ok || parsed != sc {
+			t.Errorf("%s parsed as %+v, %v", sc.Traceparent(), parsed, ok)
+		}
+	}
+	if got := (SpanContext{}).Traceparent(); got != "" {
+		t.Errorf("invalid context formatted as %q", got)
+	}
+}
+
+// exported sets a WriterExporter for the test and returns a function that
+// decodes the spans it has written
+func exported(t *testing.T) func() []Span {
+	var buf bytes.Buffer
+	SetExporter(NewWriterExporter(&buf))
+	t.Cleanup(func() { SetExporter(nil) })
+	return func() []Span {
+		var spans []Span
+		dec := json.NewDecoder(bytes.NewReader(buf.Bytes()))
+		for dec.More() {
+			var s Span
+			if err := dec.Decode(&s); err != nil {
+				t.Fatal(err)
+			}
+			spans = append(spans, s)
+		}
+		return spans
+	}
+}
+
+func TestSpansExportOnlySampledTraces(t *testing.T) {
+	spans := exported(t)
+
+	root := Start("root", SpanContext{}, time.Now())
+	child := Start("child", root.Context(), time.Now())
+	child.SetAttribute("job.id", "job-1")
+	child.End(errors.New("boom"))
+	root.End(nil)
+
+	unsampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	Start("dropped", unsampled, time.Now()).End(nil)
+
+	got := spans()
+	if len(got) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(got))
+	}
+	c, r := got[0], got[1]
+	if c.TraceID != r.TraceID || c.ParentSpanID != r.SpanID || r.ParentSpanID != "" {
+		t.Errorf("child %+v is not a child of root %+v", c, r)
+	}
+	if c.Attributes["job.id"] != "job-1" || c.Error != "boom" {
+		t.Errorf("child has attributes %v and error %q", c.Attributes, c.Error)
+	}
+}
@@ -2,46 +2,80 @@ package websocket
 
 import (
 	"distributed-task-queue/internal/database"
-	"log"
+	"distributed-task-queue/internal/models"
+	"log/slog"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// maxJobs is the number of most recent jobs sent in an update
+const maxJobs = 100
+
 // Manager manages WebSocket connections and broadcasts
 type Manager struct {
-	clients   map[*websocket.Conn]bool
+	clients   map[*websocket.Conn]*client
 	clientsMu sync.Mutex
 	db        *database.DB
+	alerts    func() []models.Alert // Alerts shown as a banner; nil without alerting
+}
+
+// Scope limits what a client is sent
+type Scope struct {
+	TenantID string // Only send this tenant's jobs; empty for all tenants
+	Workers  bool   // Send the worker list
+}
+
+// client is a connection and the updates pending for it. Only the
+// connection's writer goroutine writes updates, as a connection supports one
+// concurrent writer.
+type client struct {
+	conn    *websocket.Conn
+	scope   Scope
+	pending chan struct{}
 }
 
 // New creates a new WebSocket manager
 func New(db *database.DB) *Manager {
 	return &Manager{
-		clients: make(map[*websocket.Conn]bool),
+		clients: make(map[*websocket.Conn]*client),
 		db:      db,
 	}
 }
 
-// AddClient adds a new WebSocket client
-func (m *Manager) AddClient(conn *websocket.Conn) {
+// SetAlerts sets the source of the alerts sent to clients
+func (m *Manager) SetAlerts(alerts func() []models.Alert) {
+	m.alerts = alerts
+}
+
+// AddClient adds a new WebSocket client that is sent updates within scope
+func (m *Manager) AddClient(conn *websocket.Conn, scope Scope) {
+	c := &client{conn: conn, scope: scope, pending: make(chan struct{}, 1)}
+	// Send initial data
+	c.pending <- struct{}{}
+
 	m.clientsMu.Lock()
-	m.clients[conn] = true
+	m.clients[conn] = c
 	m.clientsMu.Unlock()
 
-	log.Printf("[WEBSOCKET] New client connected. Total clients: %d", len(m.clients))
+	slog.Info("WebSocket client connected", "clients", m.ClientCount())
 
-	// Send initial data
-	m.SendUpdateToClient(conn)
+	go func() {
+		for range c.pending {
+			m.sendUpdate(c)
+		}
+	}()
 
 	// Handle disconnection
 	go func() {
 		defer func() {
 			m.clientsMu.Lock()
 			delete(m.clients, conn)
+			close(c.pending)
 			m.clientsMu.Unlock()
 			conn.Close()
-			log.Printf("[WEBSOCKET] Client disconnected. Total clients: %d", len(m.clients))
+			slog.Info("WebSocket client disconnected", "clients", m.ClientCount())
 		}()
 
 		for {
@@ -52,28 +86,76 @@ func (m *Manager) AddClient(conn *websocket.Conn) {
 	}()
 }
 
-// Broadcast sends updates to all connected clients
+// Broadcast sends updates to all connected clients. Updates are coalesced, so
+// a client still being sent one is sent a single update afterwards however
+// many broadcasts there were meanwhile.
 func (m *Manager) Broadcast() {
 	m.clientsMu.Lock()
 	defer m.clientsMu.Unlock()
 
-	for client := range m.clients {
-		go m.SendUpdateToClient(client)
+	for _, c := range m.clients {
+		select {
+		case c.pending <- struct{}{}:
+		default:
+		}
 	}
 }
 
-// SendUpdateToClient sends current state to a specific client
-func (m *Manager) SendUpdateToClient(conn *websocket.Conn) {
-	jobs, _ := m.db.GetAllJobs()
-	metrics, _ := m.db.GetMetrics()
+// sendUpdate sends the current state within a client's scope to the client
+func (m *Manager) sendUpdate(c *client) {
+	scope := c.scope
+	jobs, err := m.db.ListJobs("", scope.TenantID, maxJobs)
+	if err != nil {
+		slog.Error("Failed to get jobs for WebSocket update", "err", err)
+		return
+	}
+	metrics, err := m.db.GetMetrics(scope.TenantID)
+	if err != nil {
+		slog.Error("Failed to get metrics for WebSocket update", "err", err)
+		return
+	}
+
+	for i := range jobs {
+		if err := m.db.DecryptJob(&jobs[i]); err != nil {
+			slog.Error("Failed to decrypt job for WebSocket update", "job_id", jobs[i].ID, "err", err)
+		}
+	}
+
+	workers := []models.WorkerInfo{}
+	if scope.Workers {
+		workers, _ = m.db.ListWorkers("")
+	}
+
+	alerts := []models.Alert{}
+	if m.alerts != nil {
+		for _, alert := range m.alerts() {
+			if scope.TenantID == "" || alert.TenantID == scope.TenantID {
+				alerts = append(alerts, alert)
+			}
+		}
+	}
 
 	update := map[string]interface{}{
 		"jobs":    jobs,
 		"metrics": metrics,
+		"workers": workers,
+		"alerts":  alerts,
+	}
+
+	if err := c.conn.WriteJSON(update); err != nil {
+		slog.Error("Failed to send WebSocket update", "err", err)
 	}
+}
 
-	if err := conn.WriteJSON(update); err != nil {
-		log.Printf("[ERROR] Failed to send WebSocket update: %v", err)
+// Close disconnects all clients with a going-away close message
+func (m *Manager) Close() {
+	m.clientsMu.Lock()
+	defer m.clientsMu.Unlock()
+
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for client := range m.clients {
+		client.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+		client.Close()
 	}
 }
 
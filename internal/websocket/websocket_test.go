@@ -0,0 +1,128 @@
+package websocket
+
+import (
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// connect starts a manager on a database with jobs for two tenants and
+// returns it and a client connected with scope
+func connect(t *testing.T, jobs int, scope Scope) (*Manager, *websocket.Conn) {
+	t.Helper()
+	db, err := database.New(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.InitSchema(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < jobs; i++ {
+		created := start.Add(time.Duration(i) * time.Second)
+		job := &models.Job{ID: fmt.Sprintf("job-%d", i), TenantID: []string{"acme", "globex"}[i%2],
+			Status: models.StatusPending, CreatedAt: created, UpdatedAt: created,
+			Queue: models.DefaultQueue, Type: models.DefaultType}
+		job.SetPayload([]byte("x"), "")
+		if err := db.InsertJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := New(db)
+	t.Cleanup(m.Close)
+	var upgrader websocket.Upgrader
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		m.AddClient(conn, scope)
+	}))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return m, conn
+}
+
+// message is the part of an update the tests check
+type message struct {
+	Jobs    []models.Job   `json:"jobs"`
+	Metrics models.Metrics `json:"metrics"`
+}
+
+// update reads the next update sent to conn
+func update(t *testing.T, conn *websocket.Conn) message {
+	t.Helper()
+	var u message
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestUpdatesSendRecentJobsInScope(t *testing.T) {
+	_, conn := connect(t, 3*maxJobs, Scope{TenantID: "acme"})
+
+	u := update(t, conn)
+	jobs := u.Jobs
+	if len(jobs) != maxJobs {
+		t.Fatalf("sent %d jobs, want %d", len(jobs), maxJobs)
+	}
+	for _, job := range jobs {
+		if job.TenantID != "acme" {
+			t.Fatalf("sent job %s of %s to an acme client", job.ID, job.TenantID)
+		}
+	}
+	if newest := fmt.Sprintf("job-%d", 3*maxJobs-2); jobs[0].ID != newest {
+		t.Fatalf("first job sent is %s, want the newest %s", jobs[0].ID, newest)
+	}
+	if u.Metrics.TotalJobs != int64(3*maxJobs/2) {
+		t.Fatalf("metrics count %d jobs, want acme's %d", u.Metrics.TotalJobs, 3*maxJobs/2)
+	}
+}
+
+func TestConcurrentBroadcasts(t *testing.T) {
+	m, conn := connect(t, 10, Scope{})
+	update(t, conn)
+
+	// Broadcasts from many goroutines are written one at a time and
+	// coalesced while a write is in progress
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Broadcast()
+		}()
+	}
+	wg.Wait()
+
+	if jobs := update(t, conn).Jobs; len(jobs) != 10 {
+		t.Fatalf("sent %d jobs, want 10", len(jobs))
+	}
+}
@@ -0,0 +1,199 @@
+package worker
+
+import (
+	"context"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ScalingPolicy controls how the Autoscaler sizes a pool
+type ScalingPolicy struct {
+	MinSize int
+	MaxSize int
+	// Interval between scaling evaluations
+	Interval time.Duration
+	// BacklogPerWorker is the number of waiting jobs one worker is expected
+	// to absorb; the desired size is backlog / BacklogPerWorker
+	BacklogPerWorker int
+	// MaxQueueWait adds a worker whenever the oldest waiting job has waited
+	// longer than this, even if the backlog is small
+	MaxQueueWait time.Duration
+	// Minimum time between scale-ups, and between any scaling and a scale-down
+	ScaleUpCooldown   time.Duration
+	ScaleDownCooldown time.Duration
+}
+
+// DefaultScalingPolicy returns a policy scaling between min and max workers
+func DefaultScalingPolicy(min, max int) ScalingPolicy {
+	return ScalingPolicy{
+		MinSize:           min,
+		MaxSize:           max,
+		Interval:          5 * time.Second,
+		BacklogPerWorker:  5,
+		MaxQueueWait:      10 * time.Second,
+		ScaleUpCooldown:   10 * time.Second,
+		ScaleDownCooldown: time.Minute,
+	}
+}
+
+// Autoscaler resizes a pool between its policy's bounds based on backlog
+// depth and queue wait time. Scale-ups may add several workers at once;
+// scale-downs remove one worker at a time.
+type Autoscaler struct {
+	pool *Pool
+	db   *database.DB
+	ctx  context.Context
+
+	mu      sync.Mutex
+	policy  ScalingPolicy
+	enabled bool
+	stats   models.PoolStats
+}
+
+// NewAutoscaler creates an autoscaler for pool and brings the pool within the
+// policy's bounds
+func NewAutoscaler(pool *Pool, db *database.DB, policy ScalingPolicy, ctx context.Context) *Autoscaler {
+	a := &Autoscaler{
+		pool:    pool,
+		db:      db,
+		ctx:     ctx,
+		policy:  policy,
+		enabled: true,
+	}
+	a.pool.Resize(clamp(a.pool.Size(), policy.MinSize, policy.MaxSize))
+	return a
+}
+
+// Start evaluates the policy every interval until the context is cancelled
+func (a *Autoscaler) Start() {
+	ticker := time.NewTicker(a.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+			a.evaluate()
+		}
+	}
+}
+
+func (a *Autoscaler) evaluate() {
+	backlog, oldest, err := a.db.GetBacklog()
+	if err != nil {
+		slog.Error("Autoscaler failed to read backlog", "err", err)
+		return
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	if !oldest.IsZero() {
+		wait = now.Sub(oldest)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.stats.Backlog = backlog
+	a.stats.OldestWaitSecs = wait.Seconds()
+	if !a.enabled {
+		return
+	}
+
+	p := a.policy
+	size := a.pool.Size()
+
+	desired := int((backlog + int64(p.BacklogPerWorker) - 1) / int64(p.BacklogPerWorker))
+	reason := fmt.Sprintf("backlog=%d wait=%v", backlog, wait.Round(time.Second))
+	if wait > p.MaxQueueWait && desired <= size {
+		desired = size + 1
+	}
+	desired = clamp(desired, p.MinSize, p.MaxSize)
+
+	sinceScale := now.Sub(a.stats.LastScaledAt)
+	switch {
+	case desired > size && sinceScale >= p.ScaleUpCooldown:
+		a.scale(size, desired, reason, now)
+		a.stats.ScaleUps++
+	case desired < size && sinceScale >= p.ScaleDownCooldown:
+		a.scale(size, size-1, reason, now)
+		a.stats.ScaleDowns++
+	}
+}
+
+// scale resizes the pool and records the decision. Callers hold a.mu.
+func (a *Autoscaler) scale(from, to int, reason string, now time.Time) {
+	a.pool.Resize(to)
+	a.stats.LastScaledAt = now
+	a.stats.LastDecision = fmt.Sprintf("%d -> %d (%s)", from, to, reason)
+	slog.Info("Autoscaled workers", "from", from, "to", to, "reason", reason)
+}
+
+// Stats returns the current pool size and autoscaler state
+func (a *Autoscaler) Stats() models.PoolStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := a.stats
+	stats.Size = a.pool.Size()
+	stats.MinSize = a.policy.MinSize
+	stats.MaxSize = a.policy.MaxSize
+	stats.Autoscale = a.enabled
+	return stats
+}
+
+// Apply changes the pool at runtime. A fixed size turns autoscaling off until
+// it is explicitly re-enabled.
+func (a *Autoscaler) Apply(req models.PoolResizeRequest) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	policy := a.policy
+	if req.MinSize != nil {
+		policy.MinSize = *req.MinSize
+	}
+	if req.MaxSize != nil {
+		policy.MaxSize = *req.MaxSize
+	}
+	if policy.MinSize < 0 || policy.MaxSize < policy.MinSize {
+		return errors.New("require 0 <= min_size <= max_size")
+	}
+	if req.Size != nil && (*req.Size < 0 || *req.Size > policy.MaxSize) {
+		return fmt.Errorf("size must be between 0 and max_size (%d)", policy.MaxSize)
+	}
+	a.policy = policy
+
+	size := a.pool.Size()
+	switch {
+	case req.Size != nil:
+		a.enabled = false
+		a.scale(size, *req.Size, "manual resize", time.Now())
+	case req.Autoscale != nil:
+		a.enabled = *req.Autoscale
+		fallthrough
+	default:
+		if bounded := clamp(size, policy.MinSize, policy.MaxSize); bounded != size {
+			a.scale(size, bounded, "bounds changed", time.Now())
+		}
+	}
+
+	slog.Info("Pool updated", "size", a.pool.Size(), "min", policy.MinSize, "max", policy.MaxSize,
+		"autoscale", a.enabled)
+	return nil
+}
+
+func clamp(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
@@ -0,0 +1,159 @@
+package worker
+
+import (
+	"context"
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// logFlushInterval is how often lines captured from a running job are sent to
+// the source, so that they can be tailed while the job runs
+const logFlushInterval = time.Second
+
+// jobLog collects the lines a handler logs while running a job until they
+// are flushed to the source
+type jobLog struct {
+	attempt int
+
+	mu    sync.Mutex
+	lines []models.JobLogLine
+
+	flushMu sync.Mutex // Keeps flushes, and so lines, in order
+}
+
+func (l *jobLog) add(line models.JobLogLine) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, line)
+}
+
+// flushLog sends the lines collected so far to the source. Lines that cannot
+// be sent are dropped.
+func (w *Worker) flushLog(job *models.Job, l *jobLog) {
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+
+	l.mu.Lock()
+	lines := l.lines
+	l.lines = nil
+	l.mu.Unlock()
+	if len(lines) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+	if err := w.source.AppendLogs(ctx, w.id, job.ID, lines); err != nil {
+		slog.Warn("Failed to store job log lines", "job_id", job.ID, "worker_id", w.id,
+			"lines", len(lines), "err", err)
+	}
+}
+
+// shipLog flushes the job's log every logFlushInterval until done is closed
+func (w *Worker) shipLog(job *models.Job, l *jobLog, done <-chan struct{}) {
+	ticker := time.NewTicker(logFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			w.flushLog(job, l)
+		}
+	}
+}
+
+// captureHandler passes records on to the next handler and also collects them
+// in a job log. Records at info level and above are collected even if the
+// next handler drops them.
+type captureHandler struct {
+	next   slog.Handler
+	log    *jobLog
+	attrs  []slog.Attr // Attributes added with WithAttrs, keys already prefixed
+	prefix string      // Group prefix of attributes added from now on
+}
+
+func newCaptureHandler(next slog.Handler, l *jobLog) *captureHandler {
+	return &captureHandler{next: next, log: l}
+}
+
+// Enabled implements slog.Handler
+func (h *captureHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo || h.next.Enabled(ctx, level)
+}
+
+// Handle implements slog.Handler
+func (h *captureHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := map[string]interface{}{}
+	for _, a := range h.attrs {
+		addAttr(attrs, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addAttr(attrs, h.prefix, a)
+		return true
+	})
+
+	line := models.JobLogLine{
+		Attempt: h.log.attempt,
+		Time:    r.Time,
+		Level:   r.Level.String(),
+		Message: r.Message,
+	}
+	if len(attrs) > 0 {
+		line.Attrs, _ = json.Marshal(attrs)
+	}
+	h.log.add(line)
+
+	if !h.next.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.next = h.next.WithAttrs(attrs)
+	c.attrs = append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		c.attrs = append(c.attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+	}
+	return &c
+}
+
+// WithGroup implements slog.Handler
+func (h *captureHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.next = h.next.WithGroup(name)
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+// addAttr adds a to attrs under its key with prefix. Groups are flattened
+// into dotted keys and values without a natural JSON form are formatted as
+// strings.
+func addAttr(attrs map[string]interface{}, prefix string, a slog.Attr) {
+	v := a.Value.Resolve()
+	switch {
+	case v.Kind() == slog.KindGroup:
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			addAttr(attrs, prefix, ga)
+		}
+	case a.Key == "":
+	case v.Kind() == slog.KindString, v.Kind() == slog.KindInt64, v.Kind() == slog.KindUint64,
+		v.Kind() == slog.KindFloat64, v.Kind() == slog.KindBool:
+		attrs[prefix+a.Key] = v.Any()
+	default:
+		attrs[prefix+a.Key] = v.String()
+	}
+}
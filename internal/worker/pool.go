@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Factory creates the n-th worker of a pool. The worker must be created with
+// ctx so that the pool can stop it.
+type Factory func(n int, ctx context.Context) *Worker
+
+// Pool runs a resizable set of workers and drains them on shutdown. Workers
+// stop leasing when they are removed by Resize or when the pool's context is
+// cancelled; jobs already in flight are given until Drain's timeout to finish
+// before being aborted and released back to the queue.
+type Pool struct {
+	ctx     context.Context
+	factory Factory
+	abort   context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	mu     sync.Mutex
+	active []*member // Workers that are leasing
+	ids    []string  // Workers running, and stopped ones that may hold a lease
+	next   int
+}
+
+type member struct {
+	w    *Worker
+	id   string // Captured at creation; the worker owns w.id once started
+	stop context.CancelFunc
+}
+
+// NewPool creates an empty pool whose workers run until ctx is cancelled
+func NewPool(ctx context.Context, factory Factory) *Pool {
+	abort, cancel := context.WithCancel(context.Background())
+	return &Pool{
+		ctx:     ctx,
+		factory: factory,
+		abort:   abort,
+		cancel:  cancel,
+	}
+}
+
+// Resize starts or stops workers until n are leasing. Stopped workers finish
+// the job they are running before exiting.
+func (p *Pool) Resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ctx.Err() != nil {
+		return
+	}
+
+	for len(p.active) < n {
+		p.next++
+		ctx, stop := context.WithCancel(p.ctx)
+		w := p.factory(p.next, ctx)
+		w.SetAbortContext(p.abort)
+		m := &member{w: w, id: w.ID(), stop: stop}
+
+		p.active = append(p.active, m)
+		p.ids = append(p.ids, m.id)
+		p.wg.Add(1)
+		go p.run(m)
+	}
+
+	for len(p.active) > n {
+		m := p.active[len(p.active)-1]
+		p.active = p.active[:len(p.active)-1]
+		m.stop()
+	}
+}
+
+func (p *Pool) run(m *member) {
+	defer p.wg.Done()
+	defer m.stop()
+
+	m.w.Start()
+	if m.w.MayHoldLease() {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, id := range p.ids {
+		if id == m.id {
+			p.ids = append(p.ids[:i], p.ids[i+1:]...)
+			break
+		}
+	}
+}
+
+// Size returns the number of workers leasing jobs
+func (p *Pool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.active)
+}
+
+// IDs returns the IDs of the workers that are running and of those that
+// stopped without reporting their last job, which keep its lease after
+// exiting, so that all leases the pool may hold are released after Drain.
+func (p *Pool) IDs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.ids...)
+}
+
+// Drain waits for the workers to finish their jobs in flight. Once timeout
+// passes, remaining jobs are aborted and released, and Drain waits up to
+// reportTimeout more for the workers to exit. It reports whether all workers
+// finished without being aborted. The pool's context must already be
+// cancelled.
+func (p *Pool) Drain(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+	}
+
+	slog.Warn("Drain timeout reached, aborting jobs in flight", "timeout", timeout)
+	p.cancel()
+
+	select {
+	case <-done:
+	case <-time.After(reportTimeout):
+		slog.Warn("Workers did not exit after abort")
+	}
+	return false
+}
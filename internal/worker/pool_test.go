@@ -0,0 +1,277 @@
+//go:build unix
+
+package worker
+
+import (
+	"context"
+	"distributed-task-queue/internal/config"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"errors"
+	"os/signal"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// runs counts the attempts started and finished for each job
+type runs struct {
+	mu       sync.Mutex
+	started  map[string]int
+	finished map[string]int
+	startedC chan string
+}
+
+func newRuns() *runs {
+	return &runs{started: make(map[string]int), finished: make(map[string]int), startedC: make(chan string, 100)}
+}
+
+// handler returns a handler that takes d, or until the job is aborted
+func (r *runs) handler(d time.Duration) Handler {
+	return func(ctx context.Context, job *models.Job) error {
+		r.mu.Lock()
+		r.started[job.ID]++
+		r.mu.Unlock()
+		r.startedC <- job.ID
+
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		r.mu.Lock()
+		r.finished[job.ID]++
+		r.mu.Unlock()
+		return nil
+	}
+}
+
+// waitStarted waits for n attempts to start
+func (r *runs) waitStarted(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.startedC:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d of %d jobs started", i, n)
+		}
+	}
+}
+
+// newTestPool starts a pool of n workers running handler that stops leasing
+// on SIGTERM, as the server's does
+func newTestPool(t *testing.T, db *database.DB, n int, handler Handler) (*Pool, context.Context) {
+	t.Helper()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	t.Cleanup(stop)
+
+	cfg := config.Default().Workers
+	pool := NewPool(ctx, func(n int, ctx context.Context) *Worker {
+		w := NewWithSource(LocalID(n), NewDBSource(db), cfg, ctx)
+		w.Handle(AnyType, handler)
+		return w
+	})
+	pool.Resize(n)
+	return pool, ctx
+}
+
+// shutdown signals the process and drains pool the way the server does
+func shutdown(t *testing.T, db *database.DB, pool *Pool, ctx context.Context, timeout time.Duration) bool {
+	t.Helper()
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGTERM did not stop the pool")
+	}
+
+	finished := pool.Drain(timeout)
+	if err := db.ReleaseWorkerLeases(pool.IDs()); err != nil {
+		t.Fatal(err)
+	}
+	return finished
+}
+
+// insertJobs inserts n jobs and returns their IDs
+func insertJobs(t *testing.T, db *database.DB, n int) []string {
+	t.Helper()
+	ids := make([]string, n)
+	for i := range ids {
+		job := newTestJob("acme")
+		if err := db.InsertJob(job); err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = job.ID
+	}
+	return ids
+}
+
+// statuses returns the number of jobs in each status, failing the test if
+// any job is still leased or has used up an attempt
+func statuses(t *testing.T, db *database.DB, ids []string) map[string]int {
+	t.Helper()
+	counts := make(map[string]int)
+	for _, id := range ids {
+		job, err := db.GetJobByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.LeasedBy != "" || job.LeasedUntil != nil {
+			t.Errorf("job %s is still leased by %q", id, job.LeasedBy)
+		}
+		if job.RetryCount != 0 {
+			t.Errorf("job %s used up %d attempts", id, job.RetryCount)
+		}
+		counts[job.Status]++
+	}
+	return counts
+}
+
+// finishAll restarts a pool and waits for every job to be done
+func finishAll(t *testing.T, db *database.DB, ids []string, r *runs) {
+	t.Helper()
+	pool, ctx := newTestPool(t, db, 3, r.handler(10*time.Millisecond))
+	deadline := time.Now().Add(10 * time.Second)
+	for countDone(t, db, ids) < len(ids) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d of %d jobs done after restart", countDone(t, db, ids), len(ids))
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	shutdown(t, db, pool, ctx, 5*time.Second)
+}
+
+// countDone returns the number of jobs that are done
+func countDone(t *testing.T, db *database.DB, ids []string) int {
+	t.Helper()
+	done := 0
+	for _, id := range ids {
+		job, err := db.GetJobByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == models.StatusDone {
+			done++
+		}
+	}
+	return done
+}
+
+func TestDrainFinishesJobsInFlight(t *testing.T) {
+	db := newTestDB(t)
+	ids := insertJobs(t, db, 6)
+	r := newRuns()
+
+	pool, ctx := newTestPool(t, db, 3, r.handler(300*time.Millisecond))
+	r.waitStarted(t, 3)
+	if !shutdown(t, db, pool, ctx, 5*time.Second) {
+		t.Fatal("drain timed out")
+	}
+
+	// The jobs in flight finished and the others were never leased
+	got := statuses(t, db, ids)
+	if got[models.StatusDone] != 3 || got[models.StatusPending] != 3 {
+		t.Fatalf("after drain: %v, want 3 done and 3 pending", got)
+	}
+
+	finishAll(t, db, ids, r)
+	for _, id := range ids {
+		if r.started[id] != 1 || r.finished[id] != 1 {
+			t.Errorf("job %s started %d times and finished %d times, want once", id, r.started[id], r.finished[id])
+		}
+	}
+}
+
+func TestDrainTimeoutReleasesJobsInFlight(t *testing.T) {
+	db := newTestDB(t)
+	ids := insertJobs(t, db, 3)
+	r := newRuns()
+
+	pool, ctx := newTestPool(t, db, 3, r.handler(time.Hour))
+	r.waitStarted(t, 3)
+	if shutdown(t, db, pool, ctx, 100*time.Millisecond) {
+		t.Fatal("drain finished jobs that run for an hour")
+	}
+
+	// Aborted jobs are back in the queue without losing an attempt
+	if got := statuses(t, db, ids); got[models.StatusPending] != 3 {
+		t.Fatalf("after drain: %v, want 3 pending", got)
+	}
+
+	finishAll(t, db, ids, r)
+	for _, id := range ids {
+		if r.started[id] != 2 || r.finished[id] != 1 {
+			t.Errorf("job %s started %d times and finished %d times, want 2 and 1", id, r.started[id], r.finished[id])
+		}
+	}
+}
+
+// unackedSource is a DBSource that fails to acknowledge jobs
+type unackedSource struct {
+	*DBSource
+}
+
+func (s unackedSource) Ack(ctx context.Context, workerID, jobID string) error {
+	return errors.New("connection reset")
+}
+
+func TestPoolForgetsWorkersWithoutLeases(t *testing.T) {
+	db := newTestDB(t)
+	source := unackedSource{NewDBSource(db)}
+	acked := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool := NewPool(ctx, func(n int, ctx context.Context) *Worker {
+		w := NewWithSource(LocalID(n), source, config.Default().Workers, ctx)
+		w.Handle(AnyType, func(ctx context.Context, job *models.Job) error {
+			acked <- struct{}{}
+			return nil
+		})
+		return w
+	})
+
+	// waitIDs waits for the pool to list n workers
+	waitIDs := func(n int) []string {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for len(pool.IDs()) != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("pool lists %d workers, want %d", len(pool.IDs()), n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return pool.IDs()
+	}
+
+	// Idle workers that were scaled down hold no lease
+	for i := 0; i < 5; i++ {
+		pool.Resize(3)
+		waitIDs(3)
+		pool.Resize(0)
+		waitIDs(0)
+	}
+
+	// A worker that could not report its job keeps it leased after exiting
+	ids := insertJobs(t, db, 1)
+	pool.Resize(1)
+	<-acked
+	pool.Resize(0)
+	cancel()
+	if !pool.Drain(5 * time.Second) {
+		t.Fatal("drain did not finish")
+	}
+	held := pool.IDs()
+	if len(held) != 1 {
+		t.Fatalf("pool lists %v, want the worker holding a lease", held)
+	}
+	if err := db.ReleaseWorkerLeases(held); err != nil {
+		t.Fatal(err)
+	}
+	if got := statuses(t, db, ids); got[models.StatusPending] != 1 {
+		t.Fatalf("after release: %v, want 1 pending", got)
+	}
+}
@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"context"
+	"distributed-task-queue/internal/database"
+	"log/slog"
+	"time"
+)
+
+// workerRetention is how long dead and stopped workers stay visible
+const workerRetention = 24 * time.Hour
+
+// Reaper marks workers that stopped heartbeating as dead and returns the jobs
+// they held to the queue without waiting for their leases to expire
+type Reaper struct {
+	db       *database.DB
+	timeout  time.Duration
+	ctx      context.Context
+	onUpdate func() // Callback for broadcasting updates
+}
+
+// NewReaper creates a reaper that considers a worker dead once it has not
+// heartbeated for timeout
+func NewReaper(db *database.DB, timeout time.Duration, ctx context.Context, onUpdate func()) *Reaper {
+	return &Reaper{
+		db:       db,
+		timeout:  timeout,
+		ctx:      ctx,
+		onUpdate: onUpdate,
+	}
+}
+
+// Start runs the reaper until its context is cancelled
+func (r *Reaper) Start() {
+	ticker := time.NewTicker(r.timeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.reap()
+		}
+	}
+}
+
+func (r *Reaper) reap() {
+	now := time.Now()
+
+	dead, reclaimed, err := r.db.ReapWorkers(now.Add(-r.timeout))
+	if err != nil {
+		slog.Error("Failed to reap workers", "err", err)
+		return
+	}
+	for _, id := range dead {
+		slog.Warn("Worker marked dead", "worker_id", id, "silent_for", r.timeout)
+	}
+	if reclaimed > 0 {
+		slog.Info("Reclaimed jobs from dead workers", "jobs", reclaimed)
+	}
+
+	if _, err := r.db.DeleteWorkersBefore(now.Add(-workerRetention)); err != nil {
+		slog.Error("Failed to prune workers", "err", err)
+	}
+
+	if len(dead) > 0 && r.onUpdate != nil {
+		r.onUpdate()
+	}
+}
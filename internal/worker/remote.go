@@ -0,0 +1,157 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RemoteSource leases jobs from a server over the HTTP worker protocol
+type RemoteSource struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+// NewRemoteSource creates a source talking to the server at baseURL. apiKey
+// may be empty when the server does not require authentication, and
+// tlsConfig nil to use the default TLS settings.
+func NewRemoteSource(baseURL, apiKey string, tlsConfig *tls.Config) *RemoteSource {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &RemoteSource{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}
+}
+
+// Register implements Source
+func (s *RemoteSource) Register(ctx context.Context, req models.WorkerRegisterRequest) (*models.WorkerInfo, error) {
+	var info models.WorkerInfo
+	if _, err := s.post(ctx, "/api/worker/register", req, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Deregister implements Source
+func (s *RemoteSource) Deregister(ctx context.Context, workerID string) error {
+	_, err := s.post(ctx, "/api/worker/deregister", models.WorkerHeartbeatRequest{WorkerID: workerID}, nil)
+	return err
+}
+
+// Lease implements Source
+func (s *RemoteSource) Lease(ctx context.Context, workerID string, leaseFor time.Duration) (*models.Job, error) {
+	var job models.Job
+	status, err := s.post(ctx, "/api/worker/lease", models.WorkerLeaseRequest{
+		WorkerID:     workerID,
+		LeaseSeconds: int(leaseFor / time.Second),
+	}, &job)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNoContent {
+		return nil, ErrNoJob
+	}
+	return &job, nil
+}
+
+// Ack implements Source
+func (s *RemoteSource) Ack(ctx context.Context, workerID, jobID string) error {
+	_, err := s.post(ctx, "/api/worker/ack", models.WorkerAckRequest{WorkerID: workerID, JobID: jobID}, nil)
+	return err
+}
+
+// Nack implements Source
+func (s *RemoteSource) Nack(ctx context.Context, workerID, jobID, errorMsg string) (bool, error) {
+	var resp struct {
+		DLQ bool `json:"dlq"`
+	}
+	_, err := s.post(ctx, "/api/worker/nack", models.WorkerAckRequest{
+		WorkerID: workerID,
+		JobID:    jobID,
+		Error:    errorMsg,
+	}, &resp)
+	return resp.DLQ, err
+}
+
+// Release implements Source
+func (s *RemoteSource) Release(ctx context.Context, workerID, jobID string) error {
+	_, err := s.post(ctx, "/api/worker/release", models.WorkerAckRequest{WorkerID: workerID, JobID: jobID}, nil)
+	return err
+}
+
+// AppendLogs implements Source
+func (s *RemoteSource) AppendLogs(ctx context.Context, workerID, jobID string, lines []models.JobLogLine) error {
+	_, err := s.post(ctx, "/api/worker/logs", models.WorkerLogRequest{
+		WorkerID: workerID,
+		JobID:    jobID,
+		Lines:    lines,
+	}, nil)
+	return err
+}
+
+// Heartbeat implements Source
+func (s *RemoteSource) Heartbeat(ctx context.Context, workerID, jobID string, leaseFor time.Duration) error {
+	_, err := s.post(ctx, "/api/worker/heartbeat", models.WorkerHeartbeatRequest{
+		WorkerID:     workerID,
+		JobID:        jobID,
+		LeaseSeconds: int(leaseFor / time.Second),
+	}, nil)
+	return err
+}
+
+// Ready implements Source. Remote workers cannot be notified and rely on
+// polling.
+func (s *RemoteSource) Ready() <-chan struct{} {
+	return nil
+}
+
+// post sends body as JSON and decodes a JSON response into out if given. It
+// returns the response status; 409 Conflict maps to database.ErrLeaseLost and
+// 410 Gone, which the server only sends for unknown workers, to
+// ErrNotRegistered.
+func (s *RemoteSource) post(ctx context.Context, path string, body, out interface{}) (int, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		return resp.StatusCode, database.ErrLeaseLost
+	case resp.StatusCode == http.StatusGone:
+		return resp.StatusCode, ErrNotRegistered
+	case resp.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode, fmt.Errorf("%s: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	case resp.StatusCode == http.StatusNoContent || out == nil:
+		return resp.StatusCode, nil
+	}
+
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"context"
+	"distributed-task-queue/internal/database"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRemoteSourceErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   error // nil for an error that is neither of the below
+	}{
+		{"unknown worker", http.StatusGone, ErrNotRegistered},
+		{"lost lease", http.StatusConflict, database.ErrLeaseLost},
+		{"wrong URL", http.StatusNotFound, nil},
+		{"forbidden", http.StatusForbidden, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, http.StatusText(tt.status), tt.status)
+			}))
+			defer srv.Close()
+
+			err := NewRemoteSource(srv.URL, "", nil).Heartbeat(context.Background(), "w1", "job-1", time.Minute)
+			switch {
+			case err == nil:
+				t.Fatal("got no error")
+			case tt.want != nil && !errors.Is(err, tt.want):
+				t.Fatalf("got %v, want %v", err, tt.want)
+			case tt.want == nil && (errors.Is(err, ErrNotRegistered) || errors.Is(err, database.ErrLeaseLost)):
+				t.Fatalf("got %v for %d", err, tt.status)
+			}
+		})
+	}
+}
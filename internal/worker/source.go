@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"context"
+	"database/sql"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNoJob is returned by Source.Lease when no job is available
+var ErrNoJob = errors.New("no job available")
+
+// ErrNotRegistered is returned when the server does not know the worker, for
+// example after its registration was pruned. The worker should re-register.
+var ErrNotRegistered = errors.New("worker not registered")
+
+// Source is the queue a worker leases jobs from and reports outcomes to
+type Source interface {
+	// Register registers the worker and its capabilities. The returned ID
+	// may differ from the requested one if the source assigns IDs.
+	Register(ctx context.Context, req models.WorkerRegisterRequest) (*models.WorkerInfo, error)
+	// Deregister marks the worker as cleanly stopped
+	Deregister(ctx context.Context, workerID string) error
+	// Lease leases the next job the worker can run, or returns ErrNoJob
+	Lease(ctx context.Context, workerID string, leaseFor time.Duration) (*models.Job, error)
+	// Ack marks a leased job as done
+	Ack(ctx context.Context, workerID, jobID string) error
+	// Nack records a failed attempt and reports whether the job moved to the DLQ
+	Nack(ctx context.Context, workerID, jobID, errorMsg string) (bool, error)
+	// Release returns an unfinished job to the queue without counting an attempt
+	Release(ctx context.Context, workerID, jobID string) error
+	// AppendLogs stores lines the handler of a leased job logged
+	AppendLogs(ctx context.Context, workerID, jobID string, lines []models.JobLogLine) error
+	// Heartbeat keeps the worker registration alive and, if jobID is not
+	// empty, extends the lease on that running job
+	Heartbeat(ctx context.Context, workerID, jobID string, leaseFor time.Duration) error
+	// Ready is signalled when a job may have become available. Sources that
+	// cannot notify return nil and rely on polling.
+	Ready() <-chan struct{}
+}
+
+// DBSource leases jobs directly from the database
+type DBSource struct {
+	db *database.DB
+
+	mu      sync.Mutex
+	filters map[string]database.LeaseFilter // Capabilities by worker ID
+}
+
+// NewDBSource creates a source that leases from the database
+func NewDBSource(db *database.DB) *DBSource {
+	return &DBSource{
+		db:      db,
+		filters: make(map[string]database.LeaseFilter),
+	}
+}
+
+// Register implements Source
+func (s *DBSource) Register(ctx context.Context, req models.WorkerRegisterRequest) (*models.WorkerInfo, error) {
+	now := time.Now()
+	info := &models.WorkerInfo{
+		ID:            req.ID,
+		Queues:        req.Queues,
+		Types:         req.Types,
+		RegisteredAt:  now,
+		LastHeartbeat: now,
+	}
+	if err := s.db.UpsertWorker(info); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.filters[req.ID] = database.LeaseFilter{WorkerID: req.ID, Queues: req.Queues, Types: req.Types}
+	s.mu.Unlock()
+
+	return info, nil
+}
+
+// Deregister implements Source
+func (s *DBSource) Deregister(ctx context.Context, workerID string) error {
+	s.mu.Lock()
+	delete(s.filters, workerID)
+	s.mu.Unlock()
+
+	return s.db.StopWorker(workerID)
+}
+
+// Lease implements Source
+func (s *DBSource) Lease(ctx context.Context, workerID string, leaseFor time.Duration) (*models.Job, error) {
+	s.mu.Lock()
+	filter, ok := s.filters[workerID]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrNotRegistered
+	}
+
+	job, err := s.db.LeaseJob(filter, time.Now().Add(leaseFor))
+	if err == sql.ErrNoRows {
+		return nil, ErrNoJob
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.OpenLeasedJob(job, workerID); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Ack implements Source
+func (s *DBSource) Ack(ctx context.Context, workerID, jobID string) error {
+	return s.db.AckJob(jobID, workerID)
+}
+
+// Nack implements Source
+func (s *DBSource) Nack(ctx context.Context, workerID, jobID, errorMsg string) (bool, error) {
+	return s.db.NackJob(jobID, workerID, errorMsg)
+}
+
+// Release implements Source
+func (s *DBSource) Release(ctx context.Context, workerID, jobID string) error {
+	return s.db.ReleaseJob(jobID, workerID)
+}
+
+// AppendLogs implements Source
+func (s *DBSource) AppendLogs(ctx context.Context, workerID, jobID string, lines []models.JobLogLine) error {
+	return s.db.AppendJobLogs(jobID, workerID, lines)
+}
+
+// Heartbeat implements Source
+func (s *DBSource) Heartbeat(ctx context.Context, workerID, jobID string, leaseFor time.Duration) error {
+	now := time.Now()
+	if err := s.db.TouchWorker(workerID, now); err != nil {
+		return err
+	}
+	if jobID == "" {
+		return nil
+	}
+	return s.db.ExtendLease(jobID, workerID, now.Add(leaseFor))
+}
+
+// Ready implements Source
+func (s *DBSource) Ready() <-chan struct{} {
+	return s.db.JobReady()
+}
@@ -2,115 +2,383 @@ package worker
 
 import (
 	"context"
-	"database/sql"
+	"distributed-task-queue/internal/config"
 	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/logging"
 	"distributed-task-queue/internal/models"
-	"log"
+	"distributed-task-queue/internal/tracing"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
 	"time"
 )
 
+// minPollTime is the idle poll interval right after a worker runs out of jobs.
+// It doubles on every empty poll up to the worker's pollTime.
+const minPollTime = 50 * time.Millisecond
+
+// reportTimeout bounds calls reporting a job outcome. They use their own
+// context so that outcomes are still recorded while the worker drains.
+const reportTimeout = 10 * time.Second
+
+// HeartbeatInterval is how often a worker reports that it is alive. Workers
+// silent for several intervals are reaped as dead.
+const HeartbeatInterval = 10 * time.Second
+
+// AnyType registers a handler for job types without a dedicated handler
+const AnyType = "*"
+
+// Handler executes a job. Returning an error fails the attempt; the job is
+// retried until it runs out of retries and moves to the DLQ.
+type Handler func(ctx context.Context, job *models.Job) error
+
 // Worker processes jobs from the queue
 type Worker struct {
-	id       int
-	db       *database.DB
-	pollTime time.Duration
-	ctx      context.Context
-	onUpdate func() // Callback for broadcasting updates
+	id       string
+	source   Source
+	queues   []string
+	handlers map[string]Handler
+	pollTime time.Duration   // Maximum idle poll interval
+	lease    time.Duration   // Lease length; renewed every third of it
+	ctx      context.Context // Cancelled to stop leasing new jobs
+	abort    context.Context // Cancelled to abort the job in flight
+	onUpdate func()          // Callback for broadcasting updates
+
+	unreported bool // The last job could not be reported and may still be leased
 }
 
-// New creates a new worker
-func New(id int, db *database.DB, pollTime time.Duration, ctx context.Context, onUpdate func()) *Worker {
+// New creates a new worker that leases jobs directly from the database and
+// runs the simulated handler for every job type. Its ID is made unique across
+// processes sharing the database by prefixing the host name and process ID.
+func New(id int, db *database.DB, cfg config.WorkerConfig, ctx context.Context, onUpdate func()) *Worker {
+	w := NewWithSource(LocalID(id), NewDBSource(db), cfg, ctx)
+	w.onUpdate = onUpdate
+	w.Handle(AnyType, Simulate)
+	return w
+}
+
+// NewWithSource creates a worker that leases jobs from source. Handlers must
+// be registered with Handle before calling Start.
+func NewWithSource(id string, source Source, cfg config.WorkerConfig, ctx context.Context) *Worker {
 	return &Worker{
 		id:       id,
-		db:       db,
-		pollTime: pollTime,
+		source:   source,
+		handlers: make(map[string]Handler),
+		pollTime: cfg.PollInterval,
+		lease:    cfg.LeaseDuration,
 		ctx:      ctx,
-		onUpdate: onUpdate,
+		abort:    ctx,
+	}
+}
+
+// LocalID returns the ID of the n-th in-process worker of this process
+func LocalID(n int) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "local"
 	}
+	return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), n)
 }
 
-// Start starts the worker
+// ID returns the worker's ID
+func (w *Worker) ID() string {
+	return w.id
+}
+
+// MayHoldLease reports whether the last job the worker leased could not be
+// reported, so that the worker may still hold its lease. It must only be
+// called once Start has returned.
+func (w *Worker) MayHoldLease() bool {
+	return w.unreported
+}
+
+// SetQueues restricts the worker to leasing from the given queues. By default
+// it leases from any queue.
+func (w *Worker) SetQueues(queues []string) {
+	w.queues = queues
+}
+
+// SetAbortContext lets a job in flight keep running after the worker's context
+// is cancelled, until it finishes or abort is cancelled. An aborted job is
+// released back to the queue. By default jobs are aborted as soon as the
+// worker's context is cancelled.
+func (w *Worker) SetAbortContext(abort context.Context) {
+	w.abort = abort
+}
+
+// Handle registers the handler for a job type. Use AnyType for a fallback.
+func (w *Worker) Handle(jobType string, h Handler) {
+	w.handlers[jobType] = h
+}
+
+// Types returns the job types this worker has dedicated handlers for, or nil
+// if it has a fallback handler and can run any type
+func (w *Worker) Types() []string {
+	if _, ok := w.handlers[AnyType]; ok {
+		return nil
+	}
+	types := make([]string, 0, len(w.handlers))
+	for t := range w.handlers {
+		types = append(types, t)
+	}
+	return types
+}
+
+// Start starts the worker. The worker sleeps until it is woken by a new or
+// retried job, or until its idle poll timer fires, then drains jobs
+// back-to-back while work exists. The idle poll interval backs off
+// exponentially from minPollTime to pollTime so that jobs becoming available
+// without a notification (expired leases) are still picked up.
 func (w *Worker) Start() {
-	log.Printf("[WORKER-%d] Started", w.id)
+	if !w.register() {
+		return
+	}
+	slog.Info("Worker started", "worker_id", w.id)
 
-	ticker := time.NewTicker(w.pollTime)
-	defer ticker.Stop()
+	go w.keepAlive()
+	defer w.deregister()
+
+	idle := minPollTime
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
 	for {
+		polled := false
 		select {
 		case <-w.ctx.Done():
-			log.Printf("[WORKER-%d] Shutting down", w.id)
+			slog.Info("Worker shutting down", "worker_id", w.id)
 			return
-		case <-ticker.C:
-			w.processNextJob()
+		case <-w.source.Ready():
+		case <-timer.C:
+			polled = true
+		}
+
+		processed := false
+		for w.ctx.Err() == nil && w.processNextJob() {
+			processed = true
+		}
+
+		if processed {
+			idle = minPollTime
+		} else if polled {
+			idle *= 2
+			if idle > w.pollTime {
+				idle = w.pollTime
+			}
+		}
+
+		if !polled && !timer.Stop() {
+			<-timer.C
 		}
+		timer.Reset(idle)
 	}
 }
 
-// processNextJob leases and processes a job
-func (w *Worker) processNextJob() {
-	now := time.Now()
-	leaseDuration := 30 * time.Second
-	leaseUntil := now.Add(leaseDuration)
-
+// processNextJob leases and processes a job. It reports whether a job was
+// leased so the caller can keep draining the queue.
+func (w *Worker) processNextJob() bool {
 	// Lease a job
-	job, err := w.db.LeaseJob(leaseUntil)
-	if err == sql.ErrNoRows {
-		return // No jobs available
+	job, err := w.source.Lease(w.ctx, w.id, w.lease)
+	if err == ErrNoJob {
+		return false // No jobs available
+	}
+	if err == ErrNotRegistered {
+		w.reregister()
+		return false
 	}
 	if err != nil {
-		log.Printf("[WORKER-%d] Failed to lease job: %v", w.id, err)
-		return
+		slog.Error("Failed to lease job", "worker_id", w.id, "err", err)
+		return false
 	}
 
-	log.Printf("[START] TraceID=%s JobID=%s WorkerID=%d Status=running", job.TraceID, job.ID, w.id)
+	logger := slog.With("job_id", job.ID, "tenant_id", job.TenantID, "trace_id", job.TraceID,
+		"attempt", job.RetryCount+1, "worker_id", w.id)
+	logger.Info("Job started", "queue", job.Queue, "type", job.Type)
 	if w.onUpdate != nil {
 		w.onUpdate()
 	}
 
-	// Process the job
-	success := w.executeJob(job)
-
-	// Acknowledge or retry the job
-	if success {
-		err = w.db.UpdateJobStatus(job.ID, models.StatusDone, "")
-		log.Printf("[FINISH] TraceID=%s JobID=%s WorkerID=%d Status=done", job.TraceID, job.ID, w.id)
-	} else {
-		job.RetryCount++
-		if job.RetryCount >= job.MaxRetries {
-			// Move to DLQ
-			err = w.db.UpdateJobStatus(job.ID, models.StatusFailed, "Max retries exceeded - moved to DLQ")
-			log.Printf("[DLQ] TraceID=%s JobID=%s WorkerID=%d Status=failed RetryCount=%d",
-				job.TraceID, job.ID, w.id, job.RetryCount)
-		} else {
-			// Retry
-			err = w.db.UpdateJobForRetry(job.ID, job.RetryCount, "Job failed - will retry")
-			log.Printf("[RETRY] TraceID=%s JobID=%s WorkerID=%d RetryCount=%d/%d",
-				job.TraceID, job.ID, w.id, job.RetryCount, job.MaxRetries)
+	// Process the job, continuing the trace of its submission, and hand the
+	// handler a logger that identifies the job and captures its lines
+	parent, traced := tracing.ParseTraceparent(job.Traceparent)
+	span := tracing.Start("job.execute", parent, time.Now())
+	span.SetAttribute("job.id", job.ID)
+	span.SetAttribute("job.type", job.Type)
+	span.SetAttribute("job.attempt", strconv.Itoa(job.RetryCount+1))
+	span.SetAttribute("worker.id", w.id)
+	log := &jobLog{attempt: job.RetryCount + 1}
+	ctx := logging.WithLogger(tracing.WithSpanContext(w.abort, span.Context()),
+		slog.New(newCaptureHandler(logger.Handler(), log)))
+	execErr := w.executeJob(ctx, job, log)
+	if traced {
+		span.End(execErr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reportTimeout)
+	defer cancel()
+
+	// Acknowledge, retry or release the job
+	switch {
+	case execErr == nil:
+		err = w.source.Ack(ctx, w.id, job.ID)
+		if err == nil {
+			logger.Info("Job done")
+		}
+	case w.abort.Err() != nil:
+		err = w.source.Release(ctx, w.id, job.ID)
+		if err == nil {
+			logger.Info("Job released")
+		}
+	default:
+		var dlq bool
+		dlq, err = w.source.Nack(ctx, w.id, job.ID, execErr.Error())
+		if err == nil && dlq {
+			logger.Warn("Job moved to DLQ", "error", execErr)
+		} else if err == nil {
+			logger.Warn("Job failed, will retry", "error", execErr, "max_retries", job.MaxRetries)
 		}
 	}
 
-	if err != nil {
-		log.Printf("[ERROR] TraceID=%s Failed to update job status: %v", job.TraceID, err)
+	if errors.Is(err, database.ErrLeaseLost) {
+		logger.Warn("Job lease lost")
+	} else if err != nil {
+		logger.Error("Failed to update job status", "err", err)
 	}
+	w.unreported = err != nil && !errors.Is(err, database.ErrLeaseLost)
 
 	if w.onUpdate != nil {
 		w.onUpdate()
 	}
+
+	return true
+}
+
+// register registers the worker with its source, retrying every pollTime
+// until it succeeds, and adopts the ID the source assigned. It returns false
+// if the worker is stopped first.
+func (w *Worker) register() bool {
+	for {
+		info, err := w.source.Register(w.ctx, w.registration())
+		if err == nil {
+			w.id = info.ID
+			return true
+		}
+
+		slog.Error("Failed to register worker", "worker_id", w.id, "err", err)
+		select {
+		case <-w.ctx.Done():
+			return false
+		case <-time.After(w.pollTime):
+		}
+	}
+}
+
+// reregister restores a registration the source has forgotten
+func (w *Worker) reregister() {
+	if _, err := w.source.Register(w.ctx, w.registration()); err != nil && w.ctx.Err() == nil {
+		slog.Error("Failed to re-register worker", "worker_id", w.id, "err", err)
+	}
+}
+
+func (w *Worker) registration() models.WorkerRegisterRequest {
+	return models.WorkerRegisterRequest{
+		ID:     w.id,
+		Queues: w.queues,
+		Types:  w.Types(),
+	}
 }
 
-// executeJob simulates job execution
-func (w *Worker) executeJob(job *models.Job) bool {
+// deregister marks the worker as stopped once its context is cancelled
+func (w *Worker) deregister() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := w.source.Deregister(ctx, w.id); err != nil {
+		slog.Error("Failed to deregister worker", "worker_id", w.id, "err", err)
+	}
+}
+
+// keepAlive heartbeats the worker registration until the worker stops
+func (w *Worker) keepAlive() {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-ticker.C:
+			err := w.source.Heartbeat(w.ctx, w.id, "", 0)
+			if err == ErrNotRegistered {
+				w.reregister()
+			} else if err != nil && w.ctx.Err() == nil {
+				slog.Error("Heartbeat failed", "worker_id", w.id, "err", err)
+			}
+		}
+	}
+}
+
+// executeJob runs the handler for a job with ctx while renewing its lease and
+// storing the lines it logs
+func (w *Worker) executeJob(ctx context.Context, job *models.Job, log *jobLog) error {
+	handler, ok := w.handlers[job.Type]
+	if !ok {
+		handler, ok = w.handlers[AnyType]
+	}
+	if !ok {
+		return fmt.Errorf("no handler registered for job type %q", job.Type)
+	}
+
+	logging.FromContext(ctx).Debug("Executing job", "type", job.Type)
+
+	done := make(chan struct{})
+	go w.renewLease(job, done)
+	go w.shipLog(job, log, done)
+
+	err := handler(ctx, job)
+	close(done)
+	w.flushLog(job, log)
+	return err
+}
+
+// renewLease heartbeats a running job until done is closed so that handlers
+// may run for longer than a single lease
+func (w *Worker) renewLease(job *models.Job, done <-chan struct{}) {
+	ticker := time.NewTicker(w.lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := w.source.Heartbeat(w.abort, w.id, job.ID, w.lease); err != nil {
+				slog.Error("Failed to renew lease", "job_id", job.ID, "worker_id", w.id, "err", err)
+			}
+		}
+	}
+}
+
+// Simulate is a demo handler that sleeps for 2-5 seconds and fails about 20%
+// of the time
+func Simulate(ctx context.Context, job *models.Job) error {
 	// Simulate work (2-5 seconds)
 	duration := time.Duration(2+time.Now().Unix()%3) * time.Second
-	log.Printf("[EXECUTE] TraceID=%s JobID=%s WorkerID=%d Payload=%s Duration=%v",
-		job.TraceID, job.ID, w.id, job.Payload, duration)
+	logger := logging.FromContext(ctx)
+	logger.Info("Simulating work", "duration", duration)
 
-	time.Sleep(duration)
+	select {
+	case <-time.After(duration):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
 	// Simulate 20% failure rate for demonstration
-	success := time.Now().Unix()%5 != 0
-
-	return success
+	if time.Now().Unix()%5 == 0 {
+		logger.Error("Simulated failure")
+		return errors.New("simulated failure")
+	}
+	return nil
 }
-
@@ -0,0 +1,119 @@
+package worker
+
+import (
+	"context"
+	"distributed-task-queue/internal/config"
+	"distributed-task-queue/internal/database"
+	"distributed-task-queue/internal/models"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	os.Exit(m.Run())
+}
+
+// newTestDB opens an empty database in a temporary directory
+func newTestDB(tb testing.TB) *database.DB {
+	tb.Helper()
+	db, err := database.New(filepath.Join(tb.TempDir(), "tasks.db"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	if err := db.InitSchema(); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+var jobSeq atomic.Int64
+
+// newTestJob returns a pending text job for a tenant
+func newTestJob(tenantID string) *models.Job {
+	now := time.Now()
+	job := &models.Job{
+		ID:         fmt.Sprintf("job-%d", jobSeq.Add(1)),
+		TenantID:   tenantID,
+		Status:     models.StatusPending,
+		MaxRetries: 3,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		Queue:      models.DefaultQueue,
+		Type:       models.DefaultType,
+	}
+	job.SetPayload([]byte("payload"), "")
+	return job
+}
+
+// startWorkers starts n workers leasing from db with the default settings
+// and stops them when the test ends
+func startWorkers(tb testing.TB, db *database.DB, n int, handler Handler) {
+	tb.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 1; i <= n; i++ {
+		w := NewWithSource(LocalID(i), NewDBSource(db), config.Default().Workers, ctx)
+		w.Handle(AnyType, handler)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Start()
+		}()
+	}
+	tb.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+}
+
+// BenchmarkLatency measures the time from submitting a job to an idle worker
+// leasing it
+func BenchmarkLatency(b *testing.B) {
+	db := newTestDB(b)
+	leased := make(chan struct{})
+	startWorkers(b, db, 1, func(ctx context.Context, job *models.Job) error {
+		leased <- struct{}{}
+		return nil
+	})
+	time.Sleep(100 * time.Millisecond) // Let the worker register and go idle
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := db.InsertJob(newTestJob("acme")); err != nil {
+			b.Fatal(err)
+		}
+		<-leased
+	}
+}
+
+// BenchmarkThroughput measures how fast a pool of workers drains jobs
+// submitted back-to-back
+func BenchmarkThroughput(b *testing.B) {
+	db := newTestDB(b)
+	var done sync.WaitGroup
+	startWorkers(b, db, 4, func(ctx context.Context, job *models.Job) error {
+		done.Done()
+		return nil
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	b.ResetTimer()
+	done.Add(b.N)
+	for i := 0; i < b.N; i++ {
+		if err := db.InsertJob(newTestJob(fmt.Sprintf("tenant-%d", i%8))); err != nil {
+			b.Fatal(err)
+		}
+	}
+	done.Wait()
+}
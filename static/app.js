@@ -9,17 +9,32 @@ let currentPage = 1;
 let pageSize = 25;
 let totalPages = 1;
 
+// API key sent with every request when the server requires authentication
+let apiKey = localStorage.getItem('apiKey') || '';
+
+// Event stream of the job shown in the log panel
+let logStream = null;
+
+// Window of the history charts
+let historyWindow = '1h';
+
 // Initialize
 document.addEventListener('DOMContentLoaded', () => {
+    setupApiKey();
     setupWebSocket();
     setupEventListeners();
     fetchInitialData();
+    fetchHistory();
 });
 
 // Setup WebSocket connection
 function setupWebSocket() {
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const wsUrl = `${protocol}//${window.location.host}/ws`;
+    let wsUrl = `${protocol}//${window.location.host}/ws`;
+    // Browsers cannot set headers on WebSocket connections
+    if (apiKey) {
+        wsUrl += `?api_key=${encodeURIComponent(apiKey)}`;
+    }
     
     ws = new WebSocket(wsUrl);
     
@@ -46,6 +61,37 @@ function setupWebSocket() {
     };
 }
 
+// Setup the API key form
+function setupApiKey() {
+    const input = document.getElementById('api-key');
+    input.value = apiKey;
+
+    document.getElementById('api-key-form').addEventListener('submit', (e) => {
+        e.preventDefault();
+        apiKey = input.value.trim();
+        if (apiKey) {
+            localStorage.setItem('apiKey', apiKey);
+        } else {
+            localStorage.removeItem('apiKey');
+        }
+
+        // Reconnect with the new key; onclose schedules the reconnect
+        if (ws) {
+            ws.close();
+        }
+        fetchInitialData();
+    });
+}
+
+// Fetch with the API key, if one is set
+function apiFetch(url, options = {}) {
+    const headers = { ...(options.headers || {}) };
+    if (apiKey) {
+        headers['Authorization'] = `Bearer ${apiKey}`;
+    }
+    return fetch(url, { ...options, headers });
+}
+
 // Update connection status indicator
 function updateConnectionStatus(connected) {
     const statusCard = document.getElementById('connection-status');
@@ -65,15 +111,23 @@ function updateConnectionStatus(connected) {
 // Fetch initial data
 async function fetchInitialData() {
     try {
-        const [jobsResponse, metricsResponse] = await Promise.all([
-            fetch('/api/jobs'),
-            fetch('/api/metrics')
+        const [jobsResponse, metricsResponse, workersResponse] = await Promise.all([
+            apiFetch('/api/jobs'),
+            apiFetch('/api/metrics'),
+            apiFetch('/api/workers')
         ]);
         
+        if (jobsResponse.status === 401) {
+            showMessage('error', 'This server requires an API key');
+            return;
+        }
+        
         const jobs = await jobsResponse.json();
         const metrics = await metricsResponse.json();
+        // Only admin keys may list workers
+        const workers = workersResponse.ok ? await workersResponse.json() : [];
         
-        updateDashboard({ jobs, metrics });
+        updateDashboard({ jobs, metrics, workers });
     } catch (error) {
         console.error('Failed to fetch initial data:', error);
     }
@@ -90,6 +144,69 @@ function updateDashboard(data) {
         renderJobs();
         renderDLQ();
     }
+    
+    if (data.workers) {
+        renderWorkers(data.workers);
+    }
+    
+    if (data.alerts) {
+        renderAlerts(data.alerts);
+    }
+}
+
+// Render the banner of firing alerts
+function renderAlerts(alerts) {
+    const banner = document.getElementById('alert-banner');
+    banner.hidden = alerts.length === 0;
+    banner.innerHTML = alerts.map(alert => `
+        <div class="alert-item">
+            <span class="alert-icon">🔔</span>
+            <strong>${escapeHtml(alert.rule)}</strong>
+            <span class="alert-summary">${escapeHtml(alert.summary)}</span>
+            <span class="alert-since">since ${new Date(alert.fired_at).toLocaleString()}</span>
+        </div>
+    `).join('');
+}
+
+// Render worker status panel
+function renderWorkers(workers) {
+    const container = document.getElementById('workers-container');
+    
+    if (workers.length === 0) {
+        container.innerHTML = '<div class="empty-state">No workers registered</div>';
+        return;
+    }
+    
+    container.innerHTML = workers.map(worker => `
+        <div class="worker-card status-${worker.status}">
+            <div class="job-header">
+                <span class="job-id">${escapeHtml(worker.id)}</span>
+                <span class="worker-status ${worker.status}">${worker.status}</span>
+            </div>
+            <div class="job-details">
+                <div class="job-detail">
+                    <strong>Current Job:</strong>
+                    <span>${worker.current_job ? escapeHtml(worker.current_job) : 'idle'}</span>
+                </div>
+                <div class="job-detail">
+                    <strong>Processed:</strong>
+                    <span>${worker.jobs_processed} (${worker.jobs_failed} failed)</span>
+                </div>
+                <div class="job-detail">
+                    <strong>Queues / Types:</strong>
+                    <span>${escapeHtml(worker.queues.join(', ') || 'any')} / ${escapeHtml(worker.types.join(', ') || 'any')}</span>
+                </div>
+                <div class="job-detail">
+                    <strong>Started:</strong>
+                    <span>${new Date(worker.registered_at).toLocaleString()}</span>
+                </div>
+                <div class="job-detail">
+                    <strong>Last Heartbeat:</strong>
+                    <span>${new Date(worker.last_heartbeat).toLocaleString()}</span>
+                </div>
+            </div>
+        </div>
+    `).join('');
 }
 
 // Update metrics display
@@ -255,7 +372,7 @@ function createJobCard(job, isDLQ = false) {
                 <div class="job-detail">
                     <strong>Payload:</strong>
                 </div>
-                <div class="job-payload">${escapeHtml(job.payload)}</div>
+                <div class="job-payload">${escapeHtml(formatPayload(job))}</div>
                 ${job.idempotency_key ? `
                 <div class="job-detail">
                     <strong>Idempotency Key:</strong>
@@ -280,6 +397,7 @@ function createJobCard(job, isDLQ = false) {
                 </div>
                 ` : ''}
                 ${isDLQ ? '<div class="job-error"><strong>⚠️ This job is in the Dead Letter Queue</strong></div>' : ''}
+                <button class="view-logs-btn" data-job-id="${escapeHtml(job.id)}">📜 View logs</button>
             </div>
         </div>
     `;
@@ -323,6 +441,27 @@ function setupEventListeners() {
         }
     });
     
+    // Job cards are re-rendered on every update, so log buttons are handled
+    // on the document
+    document.addEventListener('click', (e) => {
+        const btn = e.target.closest('.view-logs-btn');
+        if (btn) {
+            openJobLogs(btn.dataset.jobId);
+        }
+    });
+    document.getElementById('log-panel-close').addEventListener('click', closeJobLogs);
+    
+    // History window buttons
+    document.querySelectorAll('.window-btn').forEach(btn => {
+        btn.addEventListener('click', (e) => {
+            document.querySelectorAll('.window-btn').forEach(b => b.classList.remove('active'));
+            e.target.classList.add('active');
+            historyWindow = e.target.dataset.window;
+            fetchHistory();
+        });
+    });
+    window.addEventListener('resize', () => drawHistory(lastHistory));
+    
     // Next page button
     document.getElementById('next-page').addEventListener('click', () => {
         if (currentPage < totalPages) {
@@ -344,14 +483,14 @@ function scrollToJobSection() {
 // Submit new job
 async function submitJob() {
     const tenantId = document.getElementById('tenant-id').value;
-    const payload = document.getElementById('payload').value;
+    const payload = parsePayload(document.getElementById('payload').value);
     const idempotencyKey = document.getElementById('idempotency-key').value;
     const maxRetries = parseInt(document.getElementById('max-retries').value);
     
     const messageDiv = document.getElementById('submit-message');
     
     try {
-        const response = await fetch('/api/jobs', {
+        const response = await apiFetch('/api/jobs', {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json'
@@ -383,6 +522,244 @@ async function submitJob() {
     }
 }
 
+// Open the log panel for a job and tail its logs until it finishes
+function openJobLogs(jobId) {
+    closeJobLogs();
+    
+    const lines = document.getElementById('log-panel-lines');
+    const status = document.getElementById('log-panel-status');
+    lines.innerHTML = '';
+    status.textContent = 'Following…';
+    document.getElementById('log-panel-job').textContent = jobId;
+    document.getElementById('log-panel').hidden = false;
+    
+    // Event streams cannot carry headers, so the key goes in the URL
+    let url = `/api/jobs/${encodeURIComponent(jobId)}/logs?follow=true`;
+    if (apiKey) {
+        url += `&api_key=${encodeURIComponent(apiKey)}`;
+    }
+    
+    logStream = new EventSource(url);
+    logStream.addEventListener('log', (event) => {
+        lines.insertAdjacentHTML('beforeend', createLogLine(JSON.parse(event.data)));
+        lines.scrollTop = lines.scrollHeight;
+    });
+    logStream.addEventListener('end', (event) => {
+        const end = JSON.parse(event.data);
+        status.textContent = `Job ${end.status}`;
+        if (!lines.hasChildNodes()) {
+            lines.innerHTML = '<div class="empty-state">No log lines</div>';
+        }
+        closeJobLogs(false);
+    });
+    logStream.onerror = () => {
+        if (logStream && logStream.readyState === EventSource.CLOSED) {
+            status.textContent = 'Could not load logs';
+        } else {
+            status.textContent = 'Reconnecting…';
+        }
+    };
+}
+
+// Stop tailing and, unless hide is false, close the log panel
+function closeJobLogs(hide = true) {
+    if (logStream) {
+        logStream.close();
+        logStream = null;
+    }
+    if (hide) {
+        document.getElementById('log-panel').hidden = true;
+    }
+}
+
+// Create log line HTML
+function createLogLine(line) {
+    const time = new Date(line.time).toLocaleTimeString();
+    const attrs = line.attrs
+        ? Object.entries(line.attrs).map(([k, v]) => `${k}=${typeof v === 'string' ? v : JSON.stringify(v)}`).join(' ')
+        : '';
+    
+    return `
+        <div class="log-line level-${escapeHtml(line.level.toLowerCase())}">
+            <span class="log-time">${time}</span>
+            <span class="log-attempt">#${line.attempt}</span>
+            <span class="log-level">${escapeHtml(line.level)}</span>
+            <span class="log-message">${escapeHtml(line.message)}</span>
+            <span class="log-attrs">${escapeHtml(attrs)}</span>
+        </div>
+    `;
+}
+
+// Lengths of the history windows in milliseconds
+const historyWindows = {
+    '1h': 3600e3,
+    '6h': 6 * 3600e3,
+    '24h': 24 * 3600e3,
+    '7d': 7 * 24 * 3600e3,
+    '30d': 30 * 24 * 3600e3
+};
+
+const throughputLines = [
+    { key: 'enqueued', label: 'Enqueued', color: '#667eea' },
+    { key: 'completed', label: 'Completed', color: '#28a745' },
+    { key: 'failed', label: 'Failed', color: '#dc3545' }
+];
+
+const latencyLines = [
+    { key: 'wait_p50', label: 'Wait p50', color: '#a3b1f5' },
+    { key: 'wait_p95', label: 'Wait p95', color: '#667eea' },
+    { key: 'wait_p99', label: 'Wait p99', color: '#3a4bb3' },
+    { key: 'run_p50', label: 'Run p50', color: '#f7b267' },
+    { key: 'run_p95', label: 'Run p95', color: '#f08a24' },
+    { key: 'run_p99', label: 'Run p99', color: '#b35900' }
+];
+
+// History last fetched, redrawn when the page is resized
+let lastHistory = null;
+
+// Fetch job statistics over the selected window and chart them
+async function fetchHistory() {
+    const to = new Date();
+    const from = new Date(to.getTime() - historyWindows[historyWindow]);
+    const params = new URLSearchParams({ from: from.toISOString(), to: to.toISOString() });
+    
+    try {
+        const response = await apiFetch(`/api/metrics/history?${params}`);
+        if (!response.ok) {
+            return;
+        }
+        lastHistory = await response.json();
+        drawHistory(lastHistory);
+    } catch (error) {
+        console.error('Failed to fetch history:', error);
+    }
+}
+
+// Draw the throughput and latency charts. Steps without activity are left
+// out of the response, so throughput counts them as zero and latency as a gap.
+function drawHistory(history) {
+    if (!history) {
+        return;
+    }
+    
+    const step = history.step_seconds * 1000;
+    const start = Math.floor(new Date(history.from).getTime() / step) * step;
+    const end = new Date(history.to).getTime();
+    const byTime = new Map((history.total || []).map(p => [new Date(p.time).getTime(), p]));
+    const points = [];
+    for (let t = start; t < end; t += step) {
+        points.push({ time: t, point: byTime.get(t) });
+    }
+    
+    drawChart('throughput-chart', points, throughputLines, p => p ? p : { enqueued: 0, completed: 0, failed: 0 });
+    drawChart('latency-chart', points, latencyLines, p => p || {});
+    renderLegend('throughput-legend', throughputLines);
+    renderLegend('latency-legend', latencyLines);
+}
+
+// Draw one line per series on a canvas. value maps a point, which may be
+// undefined, to an object holding the series values; null values are gaps.
+function drawChart(canvasId, points, lines, value) {
+    const canvas = document.getElementById(canvasId);
+    const ratio = window.devicePixelRatio || 1;
+    const width = canvas.clientWidth;
+    const height = canvas.clientHeight;
+    canvas.width = width * ratio;
+    canvas.height = height * ratio;
+    
+    const ctx = canvas.getContext('2d');
+    ctx.scale(ratio, ratio);
+    ctx.clearRect(0, 0, width, height);
+    
+    const pad = { top: 10, right: 10, bottom: 22, left: 50 };
+    const plotWidth = width - pad.left - pad.right;
+    const plotHeight = height - pad.top - pad.bottom;
+    
+    let maxValue = 0;
+    points.forEach(({ point }) => {
+        const v = value(point);
+        lines.forEach(line => {
+            if (v[line.key] != null) {
+                maxValue = Math.max(maxValue, v[line.key]);
+            }
+        });
+    });
+    if (maxValue === 0) {
+        maxValue = 1;
+    }
+    
+    const x = i => pad.left + (points.length > 1 ? i / (points.length - 1) : 0.5) * plotWidth;
+    const y = v => pad.top + plotHeight - (v / maxValue) * plotHeight;
+    
+    // Grid and axis labels
+    ctx.font = '11px sans-serif';
+    ctx.fillStyle = '#888';
+    ctx.strokeStyle = '#eee';
+    ctx.lineWidth = 1;
+    ctx.textAlign = 'right';
+    ctx.textBaseline = 'middle';
+    for (let i = 0; i <= 4; i++) {
+        const v = maxValue * i / 4;
+        ctx.beginPath();
+        ctx.moveTo(pad.left, y(v));
+        ctx.lineTo(width - pad.right, y(v));
+        ctx.stroke();
+        ctx.fillText(formatChartValue(v), pad.left - 6, y(v));
+    }
+    
+    ctx.textBaseline = 'top';
+    const spansDays = points.length > 1 && points[points.length - 1].time - points[0].time > 24 * 3600e3;
+    const ticks = Math.min(points.length, 6);
+    for (let i = 0; i < ticks; i++) {
+        const index = ticks > 1 ? Math.round(i * (points.length - 1) / (ticks - 1)) : 0;
+        const date = new Date(points[index].time);
+        ctx.textAlign = i === 0 ? 'left' : i === ticks - 1 ? 'right' : 'center';
+        ctx.fillText(spansDays
+            ? date.toLocaleDateString([], { month: 'short', day: 'numeric' })
+            : date.toLocaleTimeString([], { hour: '2-digit', minute: '2-digit' }),
+            x(index), height - pad.bottom + 6);
+    }
+    
+    // Series
+    ctx.lineWidth = 2;
+    lines.forEach(line => {
+        const values = points.map(({ point }) => value(point)[line.key]);
+        ctx.strokeStyle = line.color;
+        ctx.fillStyle = line.color;
+        ctx.beginPath();
+        values.forEach((v, i) => {
+            if (v == null) {
+                return;
+            }
+            if (i > 0 && values[i - 1] != null) {
+                ctx.lineTo(x(i), y(v));
+            } else {
+                ctx.moveTo(x(i), y(v));
+            }
+            // Lines need two points, so isolated ones are drawn as dots
+            if ((i === 0 || values[i - 1] == null) && values[i + 1] == null) {
+                ctx.fillRect(x(i) - 2, y(v) - 2, 4, 4);
+            }
+        });
+        ctx.stroke();
+    });
+}
+
+// Format an axis value compactly
+function formatChartValue(v) {
+    if (v >= 1000) {
+        return `${(v / 1000).toFixed(1)}k`;
+    }
+    return Number.isInteger(v) ? `${v}` : v.toPrecision(2);
+}
+
+// Render the legend of a chart
+function renderLegend(legendId, lines) {
+    document.getElementById(legendId).innerHTML = lines.map(line => `
+        <span class="legend-item"><span class="legend-swatch" style="background: ${line.color}"></span>${line.label}</span>
+    `).join('');
+}
+
 // Show message
 function showMessage(type, text) {
     const messageDiv = document.getElementById('submit-message');
@@ -396,12 +773,41 @@ function showMessage(type, text) {
 }
 
 // Escape HTML to prevent XSS
+// Text payloads are shown as they are, JSON ones pretty-printed and binary
+// ones by their content type and size
+function formatPayload(job) {
+    if (job.payload_encoding === 'base64') {
+        const size = Math.floor(job.payload.length * 3 / 4) - (job.payload.match(/=*$/)[0].length);
+        return `[${job.content_type}, ${size} bytes]`;
+    }
+    if (typeof job.payload === 'string') {
+        return job.payload;
+    }
+    return JSON.stringify(job.payload, null, 2);
+}
+
+// Objects and arrays are submitted as JSON payloads, anything else as text
+function parsePayload(text) {
+    try {
+        const value = JSON.parse(text);
+        if (value !== null && typeof value === 'object') {
+            return value;
+        }
+    } catch (e) {
+        // Not JSON
+    }
+    return text;
+}
+
 function escapeHtml(text) {
     const div = document.createElement('div');
     div.textContent = text;
     return div.innerHTML;
 }
 
+// Refresh the history charts every minute, as statistics are kept per minute
+setInterval(fetchHistory, 60000);
+
 // Polling fallback if WebSocket fails
 setInterval(() => {
     if (ws.readyState !== WebSocket.OPEN) {